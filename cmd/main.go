@@ -2,43 +2,141 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/lonelysadness/OpenMonitor/pkg/api"
 	"github.com/lonelysadness/OpenMonitor/pkg/display"
 	"github.com/lonelysadness/OpenMonitor/pkg/ebpf"
 	"github.com/lonelysadness/OpenMonitor/pkg/ebpf/bandwidth"
 	"github.com/lonelysadness/OpenMonitor/pkg/ebpf/connection_listener"
+	"github.com/lonelysadness/OpenMonitor/pkg/ebpf/dns"
+	"github.com/lonelysadness/OpenMonitor/pkg/ebpf/enforce"
 	"github.com/lonelysadness/OpenMonitor/pkg/ebpf/exec"
 	"github.com/lonelysadness/OpenMonitor/pkg/nfq"
+	"github.com/lonelysadness/OpenMonitor/pkg/policy"
+	"github.com/lonelysadness/OpenMonitor/pkg/process"
+	"github.com/lonelysadness/OpenMonitor/pkg/rules"
+	"github.com/lonelysadness/OpenMonitor/pkg/sockets"
 )
 
 func main() {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	var opts options
+	flag.StringVar(&opts.rulesFile, "rules", "", "JSON file with connection rules, blocks added in the user interface are saved to it")
+	flag.BoolVar(&opts.enforceRules, "enforce", false, "block outbound connections in the kernel with cgroup hooks")
+	flag.BoolVar(&opts.pinObjects, "pin", false, "pin eBPF maps and links under "+ebpf.PinRoot+" so they survive restarts")
+	opts.mapSizes = ebpf.MapSizes{}
+	flag.Var(opts.mapSizes, "map-size", "override the size of an eBPF map as `name=entries`, ring buffers in bytes (repeatable)")
+	flag.StringVar(&opts.disable, "disable", "", "comma-separated eBPF `components` not to start: bandwidth, connections, exec, dns, enforce")
+	flag.BoolVar(&opts.daemon, "daemon", false, "run without a terminal and log activity as JSON to stderr, for running as a service")
+	logLevel := flag.String("log-level", "info", "with -daemon, the lowest `level` logged: debug (includes accepted packets), info, warn or error")
+	flag.StringVar(&opts.socket, "socket", api.DefaultSocket, "Unix `socket` serving the state of the monitor to the status command, empty to disable")
+	output := flag.String("output", "auto", "how the display is drawn: ansi, plain (no escape sequences), json (a frame per line) or auto, which is ansi on terminals and plain otherwise")
+	flag.StringVar(&opts.web, "web", "", "serve a live dashboard on a loopback `address` such as 127.0.0.1:8080, or unix:/path")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [cleanup|status]\n\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "cleanup removes all pinned eBPF maps and links and exits.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "status prints the state of the monitor listening on -socket as JSON and exits.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if opts.daemon {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+			log.Fatalf("Invalid log level: %v", err)
+		}
+		// The standard logger goes through the same handler
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	}
+	var err error
+	opts.renderer, err = display.NewRenderer(*output, os.Stdout)
+	if err != nil {
+		log.Fatalf("Invalid output: %v", err)
+	}
+
+	// Asking a running monitor needs no setup of its own
+	if flag.Arg(0) == "status" {
+		if err := printStatus(context.Background(), opts.socket); err != nil {
+			log.Fatalf("Failed to get status: %v", err)
+		}
+		return
+	}
 
 	if os.Geteuid() != 0 {
 		log.Fatal("This program must be run as root")
 	}
 
+	switch flag.Arg(0) {
+	case "":
+	case "cleanup":
+		if err := ebpf.RemovePins(ebpf.PinRoot); err != nil {
+			log.Fatalf("Failed to clean up: %v", err)
+		}
+		log.Printf("Removed pinned eBPF objects from %s", ebpf.PinRoot)
+		return
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(opts); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// options are the settings of a monitor run, taken from the flags.
+type options struct {
+	rulesFile    string
+	enforceRules bool
+	pinObjects   bool
+	mapSizes     ebpf.MapSizes
+	disable      string
+	daemon       bool
+	socket       string
+	web          string
+	renderer     display.Renderer
+}
+
+// run sets up the monitor and shows it until it is interrupted. Errors are
+// returned rather than fatal so that the iptables rules, the queues and the
+// eBPF objects are taken down on the way out.
+func run(opts options) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Pinned maps and links outlive the process, nil disables pinning
+	var pins *ebpf.Pins
+	if opts.pinObjects {
+		var err error
+		if pins, err = ebpf.OpenPins(ebpf.PinRoot); err != nil {
+			return fmt.Errorf("failed to prepare pinning: %w", err)
+		}
+	}
+
 	// Initialize NFQueue and iptables
 	if err := nfq.StartNFQueue(); err != nil {
-		log.Fatalf("Failed to setup iptables: %v", err)
+		return fmt.Errorf("failed to setup iptables: %w", err)
 	}
 	defer nfq.StopNFQueue()
 
 	if err := nfq.InitConntrack(); err != nil {
-		log.Fatalf("Failed to initialize conntrack: %v", err)
+		return fmt.Errorf("failed to initialize conntrack: %w", err)
 	}
 	defer nfq.CloseConntrack()
 
 	// Create packet handlers with proper cleanup
 	outQueue, err := nfq.New(17040, false) // OUTPUT queue
 	if err != nil {
-		log.Fatalf("Failed to create outbound queue: %v", err)
+		return fmt.Errorf("failed to create outbound queue: %w", err)
 	}
 	defer func() {
 		outQueue.Destroy()
@@ -47,33 +145,178 @@ func main() {
 
 	inQueue, err := nfq.New(17041, false) // INPUT queue
 	if err != nil {
-		log.Fatalf("Failed to create inbound queue: %v", err)
+		return fmt.Errorf("failed to create inbound queue: %w", err)
 	}
 	defer func() {
 		inQueue.Destroy()
 		time.Sleep(100 * time.Millisecond) // Allow time for cleanup
 	}()
 
-	// Start monitors
+	// Programs are picked to fit the kernel, tell what is missing
+	for _, degraded := range ebpf.ProbeFeatures().Degraded() {
+		log.Printf("Kernel feature missing, %s", degraded)
+	}
+
+	manager, err := ebpf.NewManager(pins, opts.mapSizes)
+	if err != nil {
+		return fmt.Errorf("failed to prepare eBPF: %w", err)
+	}
+	defer manager.Close()
+
+	// Register the eBPF components, the manager starts them below
 	bandwidthUpdates := make(chan *ebpf.BandwidthInfo, 100)
-	go bandwidth.BandwidthStatsWorker(ctx, 5*time.Second, bandwidthUpdates)
+	listenEvents := make(chan *ebpf.ListenEvent, 100)
+	listenCounters := ebpf.NewRingBufferCounters("listeners")
+	bandwidthMap := ebpf.NewMapCounters("om_bandwidth_map")
+	healthMap := ebpf.NewMapCounters("om_tcp_health_map")
+	manager.Register("bandwidth", bandwidth.New(5*time.Second, bandwidthUpdates, listenEvents,
+		listenCounters, bandwidthMap, healthMap), true)
 
 	connEvents := make(chan *ebpf.ConnectionEvent, 100)
-	go connection_listener.ConnectionListenerWorker(ctx, connEvents)
+	connCounters := ebpf.NewRingBufferCounters("connections")
+	manager.Register("connections", connection_listener.New(connEvents, connCounters), true)
 
-	execTracer, err := exec.New()
-	if err != nil {
-		log.Fatalf("Failed to start exec tracer: %v", err)
+	// Subscriptions outlive restarts of the tracers. While the exec tracer
+	// is down, processes are only read from /proc.
+	execTracer := exec.New()
+	manager.Register("exec", execTracer, true)
+	execEvents, unsubscribe := execTracer.Subscribe(1024)
+	defer unsubscribe()
+
+	dnsTracer := dns.New()
+	manager.Register("dns", dnsTracer, true)
+	dnsMessages, unsubscribeDNS := dnsTracer.Subscribe(256)
+	defer unsubscribeDNS()
+
+	// Rules are checked for queued packets and, with -enforce, in the kernel
+	engine := rules.NewEngine()
+	if opts.rulesFile != "" {
+		if err := engine.LoadFile(opts.rulesFile); err != nil {
+			return fmt.Errorf("failed to load rules: %w", err)
+		}
+	}
+
+	cgroupPath, err := ebpf.FindCgroupPath()
+	if err != nil && opts.enforceRules {
+		return fmt.Errorf("failed to find cgroup path: %w", err)
+	}
+	enforcer := enforce.New(cgroupPath)
+	manager.Register("enforce", enforcer, opts.enforceRules)
+	engine.OnChange(func(ruleSet []rules.Rule) {
+		if err := enforcer.Apply(ruleSet); err != nil {
+			log.Printf("Failed to apply rules in the kernel: %v", err)
+		}
+	})
+	blocked, unsubscribeBlocked := enforcer.Subscribe(256)
+	defer unsubscribeBlocked()
+
+	if opts.disable != "" {
+		for _, name := range strings.Split(opts.disable, ",") {
+			if err := manager.Disable(strings.TrimSpace(name)); err != nil {
+				return fmt.Errorf("failed to disable eBPF component: %w", err)
+			}
+		}
+	}
+	if err := manager.CheckMapSizes(); err != nil {
+		return fmt.Errorf("invalid -map-size: %w", err)
 	}
-	defer execTracer.Close()
+	manager.Start(ctx)
+
+	counters := []*ebpf.RingBufferCounters{connCounters, listenCounters, execTracer.Counters(), dnsTracer.Counters()}
+	if opts.enforceRules {
+		counters = append(counters, enforcer.Counters())
+	}
+
+	// Build the process table and keep it current from exec events
+	procs := process.NewTable()
+	if err := procs.Bootstrap(); err != nil {
+		return fmt.Errorf("failed to read process list: %w", err)
+	}
+	go procs.Run(ctx, execEvents)
+
+	// Inventory of listening sockets, kept current from listen events
+	listeners := sockets.NewInventory()
+	if err := listeners.Bootstrap(); err != nil {
+		return fmt.Errorf("failed to read listening sockets: %w", err)
+	}
+	go listeners.Run(ctx, listenEvents)
+
+	// Queued packets get their verdicts independently of the monitor,
+	// which only shows them
+	owners := policy.NewOwners(listeners)
+	pipeline := policy.New(owners, procs, engine)
+	go pipeline.Run(ctx, inQueue, outQueue)
 
 	// Start the monitor
-	monitor := display.NewMonitor()
-	go monitor.Start(ctx, connEvents, bandwidthUpdates, inQueue.PacketChannel(), outQueue.PacketChannel(), inQueue, outQueue)
+	monitor := display.NewMonitor(opts.renderer, procs, owners, listeners, engine, manager.Status,
+		[]*ebpf.MapCounters{bandwidthMap, healthMap}, counters...)
+	if opts.rulesFile != "" {
+		// Blocks added in the user interface go to the rules file
+		monitor.SaveRulesTo(opts.rulesFile)
+	}
+	if opts.daemon {
+		monitor.Headless(slog.Default())
+	}
+
+	// Other clients see the state through the socket, with or without a
+	// terminal
+	if opts.socket != "" {
+		l, err := api.ListenUnix(opts.socket)
+		if err != nil {
+			return fmt.Errorf("failed to open control socket: %w", err)
+		}
+		defer os.Remove(opts.socket)
+		go func() {
+			if err := api.NewServer(monitor.Snapshot).Serve(ctx, l); err != nil {
+				log.Printf("Control socket stopped: %v", err)
+			}
+		}()
+	}
+	if opts.web != "" {
+		l, err := api.Listen(opts.web)
+		if err != nil {
+			return fmt.Errorf("failed to open dashboard: %w", err)
+		}
+		if path, ok := strings.CutPrefix(opts.web, "unix:"); ok {
+			defer os.Remove(path)
+		}
+		go func() {
+			if err := api.NewServer(monitor.Snapshot).Serve(ctx, l); err != nil {
+				log.Printf("Dashboard stopped: %v", err)
+			}
+		}()
+		log.Printf("Dashboard listening on %s", opts.web)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		monitor.Start(ctx, connEvents, dnsMessages, blocked, bandwidthUpdates, pipeline.Decisions(), inQueue, outQueue)
+	}()
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	select {
+	case <-sigChan:
+	case <-done:
+	}
 
+	// Give the terminal back before anything else is logged
+	cancel()
+	<-done
 	log.Println("Shutting down...")
+	return nil
+}
+
+// printStatus prints the state of a running monitor as indented JSON.
+func printStatus(ctx context.Context, socket string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	snapshot, err := api.Status(ctx, socket)
+	if err != nil {
+		return err
+	}
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	return out.Encode(snapshot)
 }
@@ -9,6 +9,8 @@ require (
 	github.com/florianl/go-nfqueue v1.3.2
 	github.com/hashicorp/go-multierror v1.1.1
 	github.com/tevino/abool v1.2.0
+	golang.org/x/net v0.6.0
+	golang.org/x/sys v0.17.0
 )
 
 require (
@@ -18,7 +20,5 @@ require (
 	github.com/mdlayher/netlink v1.6.0 // indirect
 	github.com/mdlayher/socket v0.1.1 // indirect
 	golang.org/x/exp v0.0.0-20231127185646-65229373498e // indirect
-	golang.org/x/net v0.6.0 // indirect
 	golang.org/x/sync v0.1.0 // indirect
-	golang.org/x/sys v0.17.0 // indirect
 )
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/display"
+)
+
+// Status asks the monitor listening on a Unix socket for its state.
+func Status(ctx context.Context, path string) (display.Snapshot, error) {
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+
+	// The host is ignored, the transport always dials the socket
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://openmonitor/status", nil)
+	if err != nil {
+		return display.Snapshot{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return display.Snapshot{}, fmt.Errorf("failed to connect to %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return display.Snapshot{}, fmt.Errorf("monitor answered %s: %s", resp.Status, msg)
+	}
+
+	var snapshot display.Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+		return display.Snapshot{}, fmt.Errorf("failed to decode status: %w", err)
+	}
+	return snapshot, nil
+}
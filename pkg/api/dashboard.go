@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The dashboard is plain HTML and JavaScript without external resources,
+// it works on hosts without internet access.
+//
+//go:embed static
+var static embed.FS
+
+// How often the dashboard is sent a new snapshot.
+const eventInterval = time.Second
+
+// Listen listens for dashboard clients on "unix:/path" or a loopback
+// "host:port". Other addresses are refused, the dashboard has no
+// authentication.
+func Listen(address string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		return ListenUnix(path)
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", address, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("refusing to listen on %s, only loopback addresses are allowed", address)
+	}
+
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+	return l, nil
+}
+
+// checkHost rejects requests over TCP naming a host other than the
+// loopback, which is what a page using DNS rebinding to reach the dashboard
+// from the browser would send.
+func checkHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, tcp := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr); tcp {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			host = strings.Trim(host, "[]")
+			if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+				http.Error(w, "forbidden host", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func dashboardHandler() http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		// The directory is embedded, this can't fail at runtime
+		panic(err)
+	}
+	return http.FileServer(http.FS(files))
+}
+
+// handleEvents streams a snapshot every eventInterval as Server-Sent Events
+// until the client goes away or the server shuts down.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ticker := time.NewTicker(eventInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.sendSnapshot(r.Context(), w); err != nil {
+			log.Printf("Failed to send dashboard event: %v", err)
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-ticker.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) sendSnapshot(ctx context.Context, w http.ResponseWriter) error {
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+	snapshot, err := s.source(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			// Client gone or shutting down, nothing to report
+			return nil
+		}
+		return err
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "event: snapshot\ndata: %s\n\n", data)
+	return err
+}
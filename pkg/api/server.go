@@ -0,0 +1,102 @@
+// Package api serves what the monitor sees over HTTP, as JSON for the status
+// command and as a live dashboard for browsers. It only listens on Unix
+// sockets and loopback addresses.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/display"
+)
+
+// DefaultSocket is where the daemon listens unless told otherwise.
+const DefaultSocket = "/run/openmonitor.sock"
+
+// Source returns the current state of the monitor.
+type Source func(ctx context.Context) (display.Snapshot, error)
+
+// How long a client waits for the monitor loop to answer.
+const snapshotTimeout = 5 * time.Second
+
+// Server answers requests for the state of the monitor.
+type Server struct {
+	source Source
+	http   *http.Server
+}
+
+func NewServer(source Source) *Server {
+	s := &Server{source: source}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/events", s.handleEvents)
+	mux.Handle("/", dashboardHandler())
+	s.http = &http.Server{Handler: checkHost(mux), ReadHeaderTimeout: 5 * time.Second}
+	return s
+}
+
+// ListenUnix listens on a Unix socket only root can connect to. A socket
+// left behind by a previous run is replaced, one still in use is not.
+func ListenUnix(path string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("socket %s is in use by another instance", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+	return l, nil
+}
+
+// Serve answers requests on l until ctx is done.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	// Event streams end with ctx instead of holding up the shutdown
+	s.http.BaseContext = func(net.Listener) context.Context { return ctx }
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.http.Shutdown(shutdownCtx)
+	}()
+
+	if err := s.http.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), snapshotTimeout)
+	defer cancel()
+	snapshot, err := s.source(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		log.Printf("Failed to write status: %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+// Live view of the monitor, fed by the snapshots on /events.
+"use strict";
+
+const chartSeconds = 300;
+const history = []; // {time, rx, tx} rates, oldest first
+
+function formatBytes(n) {
+  const units = ["B", "KB", "MB", "GB", "TB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return (i === 0 ? n.toFixed(0) : n.toFixed(1)) + " " + units[i];
+}
+
+function formatRate(n) {
+  return formatBytes(n) + "/s";
+}
+
+function formatAge(from, now) {
+  const s = Math.max(0, Math.round((now - from) / 1000));
+  if (s < 60) return s + "s";
+  if (s < 3600) return Math.floor(s / 60) + "m" + String(s % 60).padStart(2, "0") + "s";
+  return Math.floor(s / 3600) + "h" + String(Math.floor(s / 60) % 60).padStart(2, "0") + "m";
+}
+
+function cell(text, className) {
+  const td = document.createElement("td");
+  td.textContent = text;
+  td.title = text;
+  if (className) td.className = className;
+  return td;
+}
+
+// fill replaces the rows of a table, row returns the cells of one item.
+function fill(id, items, row) {
+  const body = document.querySelector("#" + id + " tbody");
+  const rows = items.map(item => {
+    const tr = document.createElement("tr");
+    tr.append(...row(item));
+    return tr;
+  });
+  body.replaceChildren(...rows);
+}
+
+function render(s) {
+  const now = new Date(s.time);
+  const bw = s.bandwidth;
+  document.getElementById("rx-rate").textContent = formatRate(bw.rx_rate);
+  document.getElementById("tx-rate").textContent = formatRate(bw.tx_rate);
+  document.getElementById("rx-total").textContent = formatBytes(bw.rx);
+  document.getElementById("tx-total").textContent = formatBytes(bw.tx);
+
+  history.push({ time: now.getTime(), rx: bw.rx_rate, tx: bw.tx_rate });
+  while (history.length && history[0].time < now.getTime() - chartSeconds * 1000) history.shift();
+  drawChart(now.getTime());
+
+  const query = document.getElementById("connection-filter").value.trim().toLowerCase();
+  const connections = (s.connections || []).filter(c => !query ||
+    [c.protocol, c.direction, c.local, c.remote, c.process, c.scope].join(" ").toLowerCase().includes(query));
+  document.getElementById("connection-count").textContent =
+    "(" + connections.length + (query ? " of " + (s.connections || []).length : "") + ")";
+  fill("connections", connections, c => [
+    cell(c.protocol),
+    cell(c.direction === "inbound" ? "IN" : c.direction === "outbound" ? "OUT" : "?"),
+    cell(c.local),
+    cell(c.remote),
+    cell(c.scope),
+    cell(c.process || ""),
+    cell(formatRate(c.rx_rate + c.tx_rate), "num"),
+    cell(formatBytes(c.rx + c.tx), "num"),
+    cell(formatAge(new Date(c.first_seen), now), "num"),
+  ]);
+
+  fill("processes", s.processes || [], p => [
+    cell(p.name + "[" + p.pid + "]"),
+    cell(formatRate(p.rx_rate), "num"),
+    cell(formatRate(p.tx_rate), "num"),
+    cell(formatBytes(p.rx), "num"),
+    cell(formatBytes(p.tx), "num"),
+  ]);
+
+  fill("queues", s.queues || [], q => [
+    cell((q.inbound ? "IN" : "OUT") + " #" + q.id),
+    cell(q.total, "num"),
+    cell(q.accept, "num"),
+    cell(q.block, "num"),
+    cell(q.drop, "num"),
+    cell(q.errors, "num"),
+  ]);
+
+  fill("programs", s.programs || [], p => [
+    cell(p.name),
+    cell(p.state, "state-" + p.state),
+    cell(p.error || (p.degraded || []).join(", ")),
+  ]);
+
+  fill("activities", s.activities || [], a => [
+    cell(new Date(a.time).toLocaleTimeString()),
+    cell(a.verdict, "verdict-" + a.verdict),
+    cell(a.message),
+  ]);
+}
+
+function drawChart(now) {
+  const canvas = document.getElementById("chart");
+  const ratio = window.devicePixelRatio || 1;
+  canvas.width = canvas.clientWidth * ratio;
+  canvas.height = canvas.clientHeight * ratio;
+  const ctx = canvas.getContext("2d");
+  ctx.scale(ratio, ratio);
+  const w = canvas.clientWidth, h = canvas.clientHeight;
+  const style = getComputedStyle(document.documentElement);
+
+  const peak = Math.max(1, ...history.map(p => Math.max(p.rx, p.tx)));
+  ctx.fillStyle = style.getPropertyValue("--muted");
+  ctx.font = "11px system-ui, sans-serif";
+  ctx.fillText(formatRate(peak), 4, 12);
+
+  for (const [key, color] of [["rx", "--rx"], ["tx", "--tx"]]) {
+    ctx.strokeStyle = style.getPropertyValue(color);
+    ctx.lineWidth = 1.5;
+    ctx.beginPath();
+    history.forEach((p, i) => {
+      const x = w - (now - p.time) / (chartSeconds * 1000) * w;
+      const y = h - 2 - p[key] / peak * (h - 18);
+      if (i === 0) ctx.moveTo(x, y); else ctx.lineTo(x, y);
+    });
+    ctx.stroke();
+  }
+}
+
+function connect() {
+  const state = document.getElementById("state");
+  const events = new EventSource("events");
+  events.addEventListener("snapshot", e => {
+    state.textContent = "live";
+    state.className = "muted";
+    render(JSON.parse(e.data));
+  });
+  // EventSource reconnects by itself
+  events.onerror = () => {
+    state.textContent = "disconnected, retrying…";
+    state.className = "offline";
+  };
+}
+
+connect();
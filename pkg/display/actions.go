@@ -0,0 +1,105 @@
+package display
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/flow"
+	"github.com/lonelysadness/OpenMonitor/pkg/nfq"
+	"github.com/lonelysadness/OpenMonitor/pkg/rules"
+	"github.com/lonelysadness/OpenMonitor/pkg/sockets"
+)
+
+// SaveRulesTo makes rules added from the user interface permanent by
+// writing the rule set to path. Without it they last until exit.
+func (m *Monitor) SaveRulesTo(path string) {
+	m.rulesFile = path
+}
+
+// execute runs a command of the user and returns what to tell about it.
+func (m *Monitor) execute(cmd command) string {
+	conn := cmd.conn
+	var rule rules.Rule
+	switch cmd.kind {
+	case commandKill:
+	case commandBlockDestination:
+		if conn.Direction == flow.Inbound {
+			return "Rules only cover outbound connections, not blocked"
+		}
+		addr := conn.Remote.Addr()
+		rule = rules.Rule{
+			Action:   rules.Block,
+			Protocol: rules.Protocol(conn.Protocol),
+			Network:  netip.PrefixFrom(addr, addr.BitLen()),
+			Port:     conn.Remote.Port(),
+		}
+	case commandBlockProcess:
+		proc, ok := m.procs.Lookup(conn.PID)
+		if !ok || proc.Comm == "" {
+			return "Owning process unknown, not blocked"
+		}
+		rule = rules.Rule{Action: rules.Block, Process: proc.Comm}
+	default:
+		return ""
+	}
+
+	// Block first, so the connection can't be set up again right away
+	message := ""
+	if rule.Action == rules.Block {
+		id, err := m.rules.Add(rule)
+		if err != nil {
+			return fmt.Sprintf("Failed to add rule: %v", err)
+		}
+		rule.ID = id
+		message = fmt.Sprintf("added rule %s", rule)
+		if m.rulesFile == "" {
+			// Nothing to save it to, say so rather than suggest it stays
+			message += " until exit"
+		} else if err := m.rules.SaveFile(m.rulesFile); err != nil {
+			log.Printf("Failed to save rules: %v", err)
+			message += " until exit (not saved)"
+		}
+	}
+
+	if err := killConnection(conn.Flow); err != nil {
+		m.term.AddActivity(Activity{
+			Direction: "KILL",
+			Message:   fmt.Sprintf("%s failed: %v", conn.Flow, err),
+			Flow:      conn.Flow,
+			Process:   conn.Process,
+		})
+		if message != "" {
+			return fmt.Sprintf("Failed to kill %s: %v, %s", conn.Flow, err, message)
+		}
+		return fmt.Sprintf("Failed to kill %s: %v", conn.Flow, err)
+	}
+	m.term.AddActivity(Activity{
+		Direction: "KILL",
+		Message:   conn.Flow.String(),
+		Flow:      conn.Flow,
+		Process:   conn.Process,
+	})
+	if message != "" {
+		return fmt.Sprintf("Killed %s, %s", conn.Flow, message)
+	}
+	return fmt.Sprintf("Killed %s", conn.Flow)
+}
+
+// killConnection terminates a connection: the conntrack entry is removed
+// so its next packet is checked again, and the local socket is closed. A
+// connection passing through this host has no socket here.
+func killConnection(f flow.Flow) error {
+	conntrackErr := nfq.DeleteFlow(f)
+	socketErr := sockets.Destroy(f)
+	switch {
+	case socketErr == nil:
+		return nil
+	case !errors.Is(socketErr, sockets.ErrSocketNotFound):
+		return socketErr
+	case conntrackErr != nil:
+		return fmt.Errorf("no socket and %w", conntrackErr)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package display
+
+import (
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/flow"
+)
+
+// How many activities are kept for scrolling back. Older ones are
+// overwritten.
+const activityHistory = 5000
+
+// Activity is something that happened to a connection: a queued packet,
+// a kernel block, a kill or an ICMP message.
+type Activity struct {
+	Direction string // IN, OUT, BLOCK, KILL or ICMP, searched as the verdict
+	Message   string
+	Timestamp time.Time
+	Flow      flow.Flow // zero if not known
+	Process   string    // name of the owning process, if known
+
+	seq uint64 // position in the history, starting at 1
+}
+
+// activityRing is a bounded history of activities. Adding never allocates
+// once it is full, the oldest entry is overwritten instead.
+type activityRing struct {
+	entries []Activity
+	next    int    // where the next activity goes
+	total   uint64 // activities ever added
+}
+
+func newActivityRing(size int) *activityRing {
+	return &activityRing{entries: make([]Activity, 0, size)}
+}
+
+// add appends an activity and returns its sequence number.
+func (r *activityRing) add(a Activity) uint64 {
+	r.total++
+	a.seq = r.total
+	if len(r.entries) < cap(r.entries) {
+		r.entries = append(r.entries, a)
+	} else {
+		r.entries[r.next] = a
+	}
+	r.next = (r.next + 1) % cap(r.entries)
+	return a.seq
+}
+
+// len returns how many activities are kept.
+func (r *activityRing) len() int {
+	return len(r.entries)
+}
+
+// newest calls fn for the kept activities, newest first, until it returns
+// false.
+func (r *activityRing) newest(fn func(*Activity) bool) {
+	n := len(r.entries)
+	for i := 1; i <= n; i++ {
+		if !fn(&r.entries[(r.next-i+n)%n]) {
+			return
+		}
+	}
+}
+
+// matchActivity returns whether an activity matches every term of query.
+// Terms are "ip:", "port:", "proc:" or "verdict:" followed by a value, or
+// plain text searched in the whole line. Case is ignored.
+func matchActivity(a *Activity, query string) bool {
+	for _, term := range strings.Fields(strings.ToLower(query)) {
+		field, value, ok := strings.Cut(term, ":")
+		if !ok || value == "" {
+			field, value = "", term
+		}
+
+		var matched bool
+		switch field {
+		case "ip":
+			matched = matchAddr(a.Flow, value)
+		case "port":
+			port, err := strconv.ParseUint(value, 10, 16)
+			matched = err == nil && a.Flow.Protocol.HasPorts() &&
+				(a.Flow.Local.Port() == uint16(port) || a.Flow.Remote.Port() == uint16(port))
+		case "proc":
+			matched = a.Process != "" && strings.Contains(strings.ToLower(a.Process), value)
+		case "verdict":
+			matched = matchVerdict(a.Direction, value)
+		default:
+			// Not a field, e.g. part of an IPv6 address
+			line := a.Timestamp.Format("15:04:05") + " " + a.Direction + " " + a.Message
+			matched = strings.Contains(strings.ToLower(line), term)
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAddr matches either end of a flow against an address, a prefix or
+// the start of an address.
+func matchAddr(f flow.Flow, value string) bool {
+	addrs := []netip.Addr{f.Local.Addr(), f.Remote.Addr()}
+	if prefix, err := netip.ParsePrefix(value); err == nil {
+		for _, addr := range addrs {
+			if addr.IsValid() && prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, addr := range addrs {
+		if addr.IsValid() && strings.HasPrefix(addr.String(), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchVerdict matches "accept" against packets let through and anything
+// else against the activity kind.
+func matchVerdict(direction, value string) bool {
+	switch value {
+	case "accept", "allow":
+		return direction == "IN" || direction == "OUT"
+	case "block", "drop", "reject":
+		return direction == "BLOCK"
+	}
+	return strings.EqualFold(direction, value)
+}
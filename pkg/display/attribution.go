@@ -0,0 +1,126 @@
+package display
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/ebpf"
+	"github.com/lonelysadness/OpenMonitor/pkg/flow"
+	"github.com/lonelysadness/OpenMonitor/pkg/policy"
+	"github.com/lonelysadness/OpenMonitor/pkg/process"
+)
+
+// How many degraded connections the bandwidth pane lists.
+const degradedCount = 3
+
+// Connections with a smoothed RTT above this are listed as degraded.
+const degradedRTT = 300 * time.Millisecond
+
+// ProcessBandwidth is the traffic and TCP health of all known sockets of a
+// process.
+type ProcessBandwidth struct {
+	Name           string
+	PID            uint32
+	RX             uint64
+	TX             uint64
+	SRTT           time.Duration // mean smoothed RTT of the TCP connections
+	RetransmitRate float64       // retransmitted segments per second, all connections
+}
+
+// describeProcess returns "name[pid]" for a known process.
+func describeProcess(procs *process.Table, pid uint32) string {
+	proc, ok := procs.Lookup(pid)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%s[%d]", proc.Name(), proc.PID)
+}
+
+// bandwidthByProcess sums the per connection bandwidth and health of every
+// attributed process, busiest first.
+func (m *Monitor) bandwidthByProcess(bw *ebpf.BandwidthInfo) []ProcessBandwidth {
+	byPID := make(map[uint32]*ProcessBandwidth)
+	entryFor := func(key policy.Key) *ProcessBandwidth {
+		pid, ok := m.owners.Lookup(key)
+		if !ok {
+			return nil
+		}
+
+		entry, ok := byPID[pid]
+		if !ok {
+			entry = &ProcessBandwidth{PID: pid, Name: "unknown"}
+			if proc, found := m.procs.Lookup(pid); found {
+				entry.Name = proc.Name()
+			}
+			byPID[pid] = entry
+		}
+		return entry
+	}
+
+	for _, conn := range bw.Connections {
+		if entry := entryFor(policy.Key{Protocol: conn.Flow.Protocol, LocalPort: conn.Flow.Local.Port()}); entry != nil {
+			entry.RX += conn.RX
+			entry.TX += conn.TX
+		}
+	}
+
+	rttSamples := make(map[uint32]int)
+	for _, health := range bw.Health {
+		entry := entryFor(policy.Key{Protocol: flow.TCP, LocalPort: health.Flow.Local.Port()})
+		if entry == nil {
+			continue
+		}
+		entry.RetransmitRate += health.RetransmitRate
+		if health.SRTT > 0 {
+			entry.SRTT += health.SRTT
+			rttSamples[entry.PID]++
+		}
+	}
+	for pid, n := range rttSamples {
+		byPID[pid].SRTT /= time.Duration(n)
+	}
+
+	procs := make([]ProcessBandwidth, 0, len(byPID))
+	for _, entry := range byPID {
+		procs = append(procs, *entry)
+	}
+	sort.Slice(procs, func(i, j int) bool {
+		return procs[i].RX+procs[i].TX > procs[j].RX+procs[j].TX
+	})
+	return procs
+}
+
+// degradedConnections returns the TCP connections that retransmit or have a
+// high RTT, worst first.
+func degradedConnections(health []ebpf.ConnectionHealth) []ebpf.ConnectionHealth {
+	var degraded []ebpf.ConnectionHealth
+	for _, conn := range health {
+		if conn.RetransmitRate > 0 || conn.SRTT >= degradedRTT {
+			degraded = append(degraded, conn)
+		}
+	}
+
+	sort.Slice(degraded, func(i, j int) bool {
+		if degraded[i].RetransmitRate != degraded[j].RetransmitRate {
+			return degraded[i].RetransmitRate > degraded[j].RetransmitRate
+		}
+		return degraded[i].SRTT > degraded[j].SRTT
+	})
+	if len(degraded) > degradedCount {
+		degraded = degraded[:degradedCount]
+	}
+	return degraded
+}
+
+// cleanOwners forgets sockets and DNS history of processes that are gone.
+func (m *Monitor) cleanOwners() {
+	m.owners.Prune(m.isAlive)
+	m.dns.Prune(m.isAlive)
+	m.term.KeepProcessDNS(m.dns.PIDs())
+}
+
+func (m *Monitor) isAlive(pid uint32) bool {
+	proc, ok := m.procs.Lookup(pid)
+	return ok && !proc.Exited()
+}
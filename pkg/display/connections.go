@@ -0,0 +1,68 @@
+package display
+
+import (
+	"net/netip"
+	"time"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/ebpf"
+	"github.com/lonelysadness/OpenMonitor/pkg/flow"
+	"github.com/lonelysadness/OpenMonitor/pkg/netutils"
+	"github.com/lonelysadness/OpenMonitor/pkg/nfq"
+)
+
+// Connection is a connection seen in a connection event or a queued inbound
+// packet, with the bandwidth counted for it.
+type Connection struct {
+	flow.Flow
+	Process   string
+	Scope     netutils.IPScope // of the remote address
+	FirstSeen time.Time
+	LastSeen  time.Time
+	RX        uint64
+	TX        uint64
+	rates     rateHistory
+}
+
+// newConnection returns the connection of a flow.
+func (m *Monitor) newConnection(f flow.Flow, now time.Time) *Connection {
+	return &Connection{
+		Flow:      f,
+		Process:   describeProcess(m.procs, f.PID),
+		Scope:     netutils.GetIPScope(f.Remote.Addr().AsSlice()),
+		FirstSeen: now,
+		LastSeen:  now,
+	}
+}
+
+// connectionFromEvent returns the connection of a connection event.
+func (m *Monitor) connectionFromEvent(conn *ebpf.ConnectionEvent, now time.Time) *Connection {
+	return m.newConnection(conn.Flow(), now)
+}
+
+// connectionFromPacket returns the connection a queued inbound packet of
+// the process pid belongs to, pid is 0 if unknown.
+func (m *Monitor) connectionFromPacket(pkt nfq.Packet, pid uint32, now time.Time) *Connection {
+	f := pkt.Flow()
+	f.PID = pid
+	return m.newConnection(f, now)
+}
+
+// directionArrow returns the arrow FormatPacketInfo uses for a direction.
+func directionArrow(direction flow.Direction) string {
+	switch direction {
+	case flow.Inbound:
+		return "↙"
+	case flow.Outbound:
+		return "↗"
+	}
+	return "?"
+}
+
+// endpoint formats an address and port, the port is left out for protocols
+// without ports.
+func endpoint(ap netip.AddrPort, protocol flow.Protocol) string {
+	if !protocol.HasPorts() {
+		return ap.Addr().String()
+	}
+	return ap.String()
+}
@@ -0,0 +1,75 @@
+package display
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/ebpf"
+	"github.com/lonelysadness/OpenMonitor/pkg/flow"
+)
+
+// Names of the ICMP message types worth telling apart.
+var icmpTypes = map[uint8]string{
+	0:  "echo-reply",
+	3:  "unreachable",
+	5:  "redirect",
+	8:  "echo-request",
+	11: "time-exceeded",
+	13: "timestamp",
+	17: "address-mask",
+}
+
+var icmpv6Types = map[uint8]string{
+	1:   "unreachable",
+	2:   "packet-too-big",
+	3:   "time-exceeded",
+	128: "echo-request",
+	129: "echo-reply",
+	133: "router-solicit",
+	134: "router-advert",
+	135: "neighbor-solicit",
+	136: "neighbor-advert",
+}
+
+// isICMPActivity returns whether the event was reported for a ping or raw
+// socket rather than for a connect.
+func isICMPActivity(conn *ebpf.ConnectionEvent) bool {
+	protocol := flow.Protocol(conn.Protocol)
+	return conn.SockType == ebpf.SockRaw || protocol == flow.ICMP || protocol == flow.ICMPv6
+}
+
+// formatICMP formats a message sent on a ping or raw socket.
+func (m *Monitor) formatICMP(conn *ebpf.ConnectionEvent, pid uint32) string {
+	owner := describeProcess(m.procs, pid)
+	if owner == "" {
+		owner = fmt.Sprintf("[%d]", pid)
+	}
+
+	kind := "raw"
+	if conn.SockType != ebpf.SockRaw {
+		kind = "ping"
+	}
+
+	f := conn.Flow()
+	var what string
+	switch f.Protocol {
+	case flow.ICMP:
+		what = icmpTypeName(icmpTypes, conn.ICMPType, conn.ICMPCode)
+	case flow.ICMPv6:
+		what = icmpTypeName(icmpv6Types, conn.ICMPType, conn.ICMPCode)
+	default:
+		what = "protocol " + f.Protocol.String()
+	}
+	return fmt.Sprintf("%s -> %s %s (%s socket)", owner, f.Remote.Addr(), what, kind)
+}
+
+func icmpTypeName(names map[uint8]string, typ, code uint8) string {
+	name, ok := names[typ]
+	if !ok {
+		name = "type " + strconv.Itoa(int(typ))
+	}
+	if code != 0 {
+		name += "/" + strconv.Itoa(int(code))
+	}
+	return name
+}
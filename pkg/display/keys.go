@@ -0,0 +1,114 @@
+package display
+
+import "unicode/utf8"
+
+// keyCode identifies a key read from the terminal. Printable characters are
+// keyRune with the character in key.r.
+type keyCode int
+
+const (
+	keyRune keyCode = iota
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyPageUp
+	keyPageDown
+	keyHome
+	keyEnd
+	keyTab
+	keyBackTab
+	keyEnter
+	keyEscape
+	keyBackspace
+	keyRedraw // Ctrl+L
+)
+
+type key struct {
+	code keyCode
+	r    rune
+}
+
+// parseKeys splits what a single read returned into keys. Escape sequences
+// are expected to arrive in one read, a lone ESC is the escape key.
+func parseKeys(buf []byte) []key {
+	var keys []key
+	for i := 0; i < len(buf); {
+		b := buf[i]
+		switch {
+		case b == 0x1b:
+			if i+1 < len(buf) && (buf[i+1] == '[' || buf[i+1] == 'O') {
+				k, n := parseEscape(buf[i+2:])
+				if k.code != keyRune {
+					keys = append(keys, k)
+				}
+				i += 2 + n
+				continue
+			}
+			keys = append(keys, key{code: keyEscape})
+		case b == '\t':
+			keys = append(keys, key{code: keyTab})
+		case b == '\r' || b == '\n':
+			keys = append(keys, key{code: keyEnter})
+		case b == 0x7f || b == 0x08:
+			keys = append(keys, key{code: keyBackspace})
+		case b == 0x0c:
+			keys = append(keys, key{code: keyRedraw})
+		case b >= 0x20:
+			r, size := utf8.DecodeRune(buf[i:])
+			if r != utf8.RuneError {
+				keys = append(keys, key{code: keyRune, r: r})
+			}
+			i += size
+			continue
+		}
+		i++
+	}
+	return keys
+}
+
+// parseEscape decodes the CSI or SS3 sequence following "ESC [" or "ESC O"
+// and returns how many bytes it took. Unknown sequences are keyRune.
+func parseEscape(buf []byte) (key, int) {
+	param := 0
+	for i, b := range buf {
+		switch {
+		case b >= '0' && b <= '9':
+			param = param*10 + int(b-'0')
+			continue
+		case b == ';':
+			continue
+		}
+
+		var code keyCode
+		switch b {
+		case 'A':
+			code = keyUp
+		case 'B':
+			code = keyDown
+		case 'C':
+			code = keyRight
+		case 'D':
+			code = keyLeft
+		case 'H':
+			code = keyHome
+		case 'F':
+			code = keyEnd
+		case 'Z':
+			code = keyBackTab
+		case '~':
+			switch param {
+			case 1, 7:
+				code = keyHome
+			case 4, 8:
+				code = keyEnd
+			case 5:
+				code = keyPageUp
+			case 6:
+				code = keyPageDown
+			}
+		}
+		return key{code: code}, i + 1
+	}
+	return key{}, len(buf)
+}
@@ -4,84 +4,302 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
+	"net/netip"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/lonelysadness/OpenMonitor/pkg/ebpf"
+	"github.com/lonelysadness/OpenMonitor/pkg/ebpf/dns"
+	"github.com/lonelysadness/OpenMonitor/pkg/flow"
 	"github.com/lonelysadness/OpenMonitor/pkg/nfq"
+	"github.com/lonelysadness/OpenMonitor/pkg/policy"
+	"github.com/lonelysadness/OpenMonitor/pkg/process"
+	"github.com/lonelysadness/OpenMonitor/pkg/rules"
+	"github.com/lonelysadness/OpenMonitor/pkg/sockets"
 )
 
 type Monitor struct {
-	term *Terminal
+	term      *Terminal
+	procs     *process.Table
+	listeners *sockets.Inventory
+	rules     *rules.Engine
+	rulesFile string
+	owners    *policy.Owners
+	counters  []*ebpf.RingBufferCounters
+	maps      []*ebpf.MapCounters
+	programs  func() []ebpf.Status
+	dns       *dns.History
+	snapshots chan chan Snapshot
+	logger    *slog.Logger // set when running without a terminal
 }
 
-func NewMonitor() *Monitor {
+// NewMonitor returns a monitor that draws frames with renderer and shows
+// the status of the eBPF components as returned by programs. It records the
+// owners of connections in owners for the packet pipeline.
+func NewMonitor(renderer Renderer, procs *process.Table, owners *policy.Owners, listeners *sockets.Inventory,
+	engine *rules.Engine, programs func() []ebpf.Status, maps []*ebpf.MapCounters, counters ...*ebpf.RingBufferCounters) *Monitor {
+	term := NewTerminal(renderer, time.Now)
+	term.UpdateFeatures(ebpf.ProbeFeatures().Degraded())
 	return &Monitor{
-		term: NewTerminal(),
+		term:      term,
+		procs:     procs,
+		listeners: listeners,
+		rules:     engine,
+		owners:    owners,
+		counters:  counters,
+		maps:      maps,
+		programs:  programs,
+		dns:       dns.NewHistory(50),
+		snapshots: make(chan chan Snapshot),
 	}
 }
 
+// Headless runs the monitor without a terminal. Activities and a periodic
+// summary are logged instead, accepted packets at debug level.
+func (m *Monitor) Headless(logger *slog.Logger) {
+	m.logger = logger
+}
+
+// Start shows the monitor until the context is done or the user quits. The
+// packets of the queues are decided by the pipeline, the monitor only shows
+// its decisions.
 func (m *Monitor) Start(ctx context.Context, connEvents chan *ebpf.ConnectionEvent,
-	bwUpdates chan *ebpf.BandwidthInfo, inPackets, outPackets <-chan nfq.Packet,
-	inQueue, outQueue *nfq.Queue) {
+	dnsMessages <-chan *dns.Message, blocked <-chan *ebpf.BlockEvent, bwUpdates chan *ebpf.BandwidthInfo,
+	decisions <-chan policy.Decision, inQueue, outQueue *nfq.Queue) {
 
 	ticker := time.NewTicker(1 * time.Second)
 	monitorTicker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 	defer monitorTicker.Stop()
 
-	// Initial clear
-	fmt.Print("\033[2J")
+	// Lay out again whenever the terminal is resized
+	var winch chan os.Signal
+	if m.logger == nil {
+		if err := m.term.Open(); err != nil {
+			log.Printf("Failed to open terminal, keys are ignored: %v", err)
+		}
+		defer m.term.Close()
+		m.term.Display()
+
+		winch = make(chan os.Signal, 1)
+		signal.Notify(winch, syscall.SIGWINCH)
+		defer signal.Stop(winch)
+	}
 
 	for {
 		select {
 		case conn := <-connEvents:
-			key := fmt.Sprintf("%v:%d -> %v:%d [%d]",
-				conn.SrcAddr, conn.SrcPort,
-				conn.DstAddr, conn.DstPort,
-				conn.Protocol)
-			m.term.UpdateConnections(key, time.Now())
-
-		case pkt := <-inPackets:
-			go func(p nfq.Packet) {
-				if err := p.Accept(); err != nil {
-					fmt.Printf("Error setting IN verdict: %v\n", err)
-				}
-			}(pkt)
-			m.term.AddActivity("IN", FormatPacketInfo(pkt, true))
-
-		case pkt := <-outPackets:
-			go func(p nfq.Packet) {
-				if err := p.Accept(); err != nil {
-					fmt.Printf("Error setting OUT verdict: %v\n", err)
-				}
-			}(pkt)
-			m.term.AddActivity("OUT", FormatPacketInfo(pkt, false))
+			c := m.connectionFromEvent(conn, time.Now())
+			m.owners.Set(policy.Key{Protocol: c.Protocol, LocalPort: c.Local.Port()}, c.PID)
+			m.term.UpdateConnections(c)
+			if isICMPActivity(conn) {
+				m.addActivity(Activity{
+					Direction: "ICMP",
+					Message:   m.formatICMP(conn, c.PID),
+					Flow:      conn.Flow(),
+					Process:   m.processName(c.PID),
+				})
+			}
+
+		case msg, ok := <-dnsMessages:
+			if !ok {
+				// Tracer closed, stop selecting on it.
+				dnsMessages = nil
+				continue
+			}
+			m.dns.Add(msg)
+			m.term.UpdateDNS(m.dns.Recent(5))
+			m.term.UpdateProcessDNS(msg.PID, m.dns.ForPID(msg.PID))
+
+		case event, ok := <-blocked:
+			if !ok {
+				blocked = nil
+				continue
+			}
+			m.addActivity(m.blockedActivity(event))
+
+		case d := <-decisions:
+			switch {
+			case d.Inbound:
+				m.term.UpdateConnections(m.connectionFromPacket(d.Packet, d.PID, time.Now()))
+				m.addActivity(m.packetActivity("IN", d))
+			case d.Blocked:
+				act := m.packetActivity("BLOCK", d)
+				act.Message = fmt.Sprintf("%s (rule %s)", act.Message, d.Rule)
+				m.addActivity(act)
+			default:
+				m.addActivity(m.packetActivity("OUT", d))
+			}
 
 		case bw := <-bwUpdates:
 			if bw != nil {
-				m.term.UpdateBandwidth(bw.RX, bw.TX)
+				now := time.Now()
+				m.term.UpdateBandwidth(bw.RX, bw.TX, now)
+				m.term.UpdateProcessBandwidth(m.bandwidthByProcess(bw), now)
+				m.term.UpdateTCPHealth(degradedConnections(bw.Health))
+				m.term.UpdateConnectionTraffic(bw.Connections, now)
 			}
 
 		case <-ticker.C:
 			m.term.CleanOldConnections(30 * time.Second)
-			m.term.UpdateQueueStats(inQueue, outQueue)
-			m.term.Display()
+			m.term.UpdateQueueStats(queueSnapshots(inQueue, outQueue))
+			m.term.UpdateEventStats(m.eventStats())
+			m.term.UpdateMapStats(m.mapStats())
+			m.term.UpdatePrograms(m.programs())
+			services, exposed := m.services()
+			m.term.UpdateServices(services, m.listeners.Count(), exposed)
+			if m.logger == nil {
+				m.term.Display()
+			}
+
+		case reply := <-m.snapshots:
+			reply <- m.snapshot(time.Now(), inQueue, outQueue)
 
 		case <-monitorTicker.C:
-			// Queue health monitoring
-			for _, q := range []*nfq.Queue{inQueue, outQueue} {
-				stats := q.GetVerdictStats()
-				if stats.Errors > 1000 {
-					select {
-					case q.Restart <- struct{}{}:
-						log.Printf("High error rate detected (%d), restarting queue %d", stats.Errors, q.ID())
-					default:
-					}
-				}
+			m.cleanOwners()
+			if m.logger != nil {
+				m.logSummary(inQueue, outQueue)
 			}
 
+		case <-winch:
+			m.term.Resize()
+			m.term.Display()
+
+		case k := <-m.term.Keys():
+			switch cmd := m.term.HandleKey(k); cmd.kind {
+			case commandQuit:
+				return
+			case commandNone:
+			default:
+				m.term.Notify(m.execute(cmd))
+			}
+			m.term.Display()
+
 		case <-ctx.Done():
 			return
 		}
 	}
 }
+
+// addActivity shows an activity, or logs it when headless.
+func (m *Monitor) addActivity(act Activity) {
+	m.term.AddActivity(act)
+	if m.logger == nil {
+		return
+	}
+
+	level := slog.LevelInfo
+	if act.Direction == "IN" || act.Direction == "OUT" {
+		level = slog.LevelDebug
+	}
+	attrs := []slog.Attr{slog.String("verdict", act.Direction)}
+	if act.Flow.Protocol != 0 {
+		attrs = append(attrs,
+			slog.String("protocol", act.Flow.Protocol.String()),
+			slog.String("local", act.Flow.Local.String()),
+			slog.String("remote", act.Flow.Remote.String()))
+	}
+	if act.Flow.PID != 0 {
+		attrs = append(attrs, slog.Any("pid", act.Flow.PID))
+	}
+	if act.Process != "" {
+		attrs = append(attrs, slog.String("process", act.Process))
+	}
+	m.logger.LogAttrs(context.Background(), level, act.Message, attrs...)
+}
+
+// logSummary logs what the terminal would show in its header and queue pane.
+func (m *Monitor) logSummary(inQueue, outQueue *nfq.Queue) {
+	s := m.snapshot(time.Now(), inQueue, outQueue)
+	attrs := []slog.Attr{
+		slog.Int("connections", len(s.Connections)),
+		slog.Float64("rx_rate", s.Bandwidth.RXRate),
+		slog.Float64("tx_rate", s.Bandwidth.TXRate),
+	}
+	for _, q := range s.Queues {
+		name := "out_queue"
+		if q.Inbound {
+			name = "in_queue"
+		}
+		attrs = append(attrs, slog.Group(name,
+			"total", q.Total, "accept", q.Accept, "block", q.Block, "drop", q.Drop, "errors", q.Errors))
+	}
+	m.logger.LogAttrs(context.Background(), slog.LevelInfo, "summary", attrs...)
+
+	for _, status := range m.programs() {
+		if status.State == ebpf.StateDegraded || status.State == ebpf.StateFailed {
+			m.logger.Warn("eBPF component not fully running", "component", status.Name,
+				"state", status.State.String(), "degraded", status.Degraded, "error", status.Err)
+		}
+	}
+}
+
+// packetActivity describes a queued packet and appends the owning process
+// if known.
+func (m *Monitor) packetActivity(direction string, d policy.Decision) Activity {
+	act := Activity{
+		Direction: direction,
+		Message:   FormatPacketInfo(d.Packet, d.Inbound),
+		Flow:      d.Packet.Flow(),
+	}
+	if d.PID != 0 {
+		if owner := describeProcess(m.procs, d.PID); owner != "" {
+			act.Message += " " + owner
+		}
+		act.Flow.PID = d.PID
+		act.Process = m.processName(d.PID)
+	}
+	return act
+}
+
+// processName returns the name of a known process, empty otherwise.
+func (m *Monitor) processName(pid uint32) string {
+	if proc, ok := m.procs.Lookup(pid); ok {
+		return proc.Name()
+	}
+	return ""
+}
+
+// blockedActivity describes a connection rejected in the kernel.
+func (m *Monitor) blockedActivity(event *ebpf.BlockEvent) Activity {
+	owner := describeProcess(m.procs, event.PID)
+	if owner == "" {
+		owner = fmt.Sprintf("%s[%d]", event.Comm, event.PID)
+	}
+	process := m.processName(event.PID)
+	if process == "" {
+		process = event.Comm
+	}
+
+	addr, _ := netip.AddrFromSlice(event.Addr)
+	f := flow.New(flow.Protocol(event.Protocol), flow.Outbound, netip.AddrPort{}, netip.AddrPortFrom(addr, event.Port))
+	f.PID = event.PID
+	return Activity{
+		Direction: "BLOCK",
+		Message: fmt.Sprintf("%s -> %s:%d [%d] (rule #%d)",
+			owner, event.Addr, event.Port, event.Protocol, event.RuleID),
+		Flow:    f,
+		Process: process,
+	}
+}
+
+// mapStats returns the current fill level of all tracked maps.
+func (m *Monitor) mapStats() []ebpf.MapStats {
+	stats := make([]ebpf.MapStats, 0, len(m.maps))
+	for _, c := range m.maps {
+		stats = append(stats, c.Stats())
+	}
+	return stats
+}
+
+// eventStats returns the current counters of all ring buffer consumers.
+func (m *Monitor) eventStats() []ebpf.RingBufferStats {
+	stats := make([]ebpf.RingBufferStats, 0, len(m.counters))
+	for _, c := range m.counters {
+		stats = append(stats, c.Stats())
+	}
+	return stats
+}
@@ -0,0 +1,161 @@
+package display
+
+import (
+	"strings"
+	"time"
+)
+
+// Windows of the bandwidth history the user can pick from.
+var rateWindows = []time.Duration{time.Minute, 5 * time.Minute, time.Hour}
+
+// A rate not updated for this long is taken as zero, nothing moved or the
+// bandwidth component is down.
+const staleRate = 15 * time.Second
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// rateSample is the rate between two readings of byte counters.
+type rateSample struct {
+	from, to time.Time
+	rx, tx   float64 // bytes per second
+}
+
+// rateHistory turns readings of cumulative byte counters into rates and
+// keeps them for the longest window.
+type rateHistory struct {
+	read    bool
+	last    time.Time
+	lastRX  uint64
+	lastTX  uint64
+	samples []rateSample // oldest first
+}
+
+// add records a reading of the counters. Counters that went down, because
+// sockets were closed or evicted, count as no traffic. After a gap the
+// reading only starts over, the traffic in between can't be placed.
+func (h *rateHistory) add(now time.Time, rx, tx uint64) {
+	if h.read && now.After(h.last) && now.Sub(h.last) <= staleRate {
+		elapsed := now.Sub(h.last).Seconds()
+		sample := rateSample{from: h.last, to: now}
+		if rx >= h.lastRX {
+			sample.rx = float64(rx-h.lastRX) / elapsed
+		}
+		if tx >= h.lastTX {
+			sample.tx = float64(tx-h.lastTX) / elapsed
+		}
+		h.samples = append(h.samples, sample)
+	}
+	h.read = true
+	h.last, h.lastRX, h.lastTX = now, rx, tx
+
+	longest := rateWindows[len(rateWindows)-1]
+	drop := 0
+	for drop < len(h.samples) && now.Sub(h.samples[drop].to) > longest {
+		drop++
+	}
+	h.samples = h.samples[drop:]
+}
+
+// current returns the latest rates.
+func (h *rateHistory) current(now time.Time) (rx, tx float64) {
+	if len(h.samples) == 0 {
+		return 0, 0
+	}
+	last := h.samples[len(h.samples)-1]
+	if now.Sub(last.to) > staleRate {
+		return 0, 0
+	}
+	return last.rx, last.tx
+}
+
+// peak returns the highest rates within the window.
+func (h *rateHistory) peak(now time.Time, window time.Duration) (rx, tx float64) {
+	for _, s := range h.samples {
+		if now.Sub(s.to) > window {
+			continue
+		}
+		rx = max(rx, s.rx)
+		tx = max(tx, s.tx)
+	}
+	return rx, tx
+}
+
+// series returns the rates within the window averaged into n buckets,
+// oldest first. pick selects what of a sample is used.
+func (h *rateHistory) series(now time.Time, window time.Duration, n int, pick func(rateSample) float64) []float64 {
+	values := make([]float64, n)
+	if n == 0 {
+		return values
+	}
+	start := now.Add(-window)
+	bucket := window / time.Duration(n)
+	for _, s := range h.samples {
+		if !s.to.After(start) {
+			continue
+		}
+		rate := pick(s)
+		// A sample covers its whole interval, spread it over the buckets
+		for i := max(int(s.from.Sub(start)/bucket), 0); i < n; i++ {
+			bucketStart := start.Add(time.Duration(i) * bucket)
+			bucketEnd := bucketStart.Add(bucket)
+			if !bucketStart.Before(s.to) {
+				break
+			}
+			overlap := minTime(bucketEnd, s.to).Sub(maxTime(bucketStart, s.from))
+			if overlap > 0 {
+				values[i] += rate * overlap.Seconds() / bucket.Seconds()
+			}
+		}
+	}
+	return values
+}
+
+func rxRate(s rateSample) float64    { return s.rx }
+func txRate(s rateSample) float64    { return s.tx }
+func totalRate(s rateSample) float64 { return s.rx + s.tx }
+
+// sparkline draws values as bars scaled to the largest one. Zero is blank.
+func sparkline(values []float64) string {
+	top := 0.0
+	for _, v := range values {
+		top = max(top, v)
+	}
+
+	var b strings.Builder
+	for _, v := range values {
+		if v <= 0 || top == 0 {
+			b.WriteRune(' ')
+			continue
+		}
+		level := int(v / top * float64(len(sparkBlocks)-1))
+		b.WriteRune(sparkBlocks[level])
+	}
+	return b.String()
+}
+
+// formatRate formats bytes per second.
+func formatRate(rate float64) string {
+	return formatBytes(uint64(rate)) + "/s"
+}
+
+// formatWindow formats a history window as "1m" or "1h".
+func formatWindow(window time.Duration) string {
+	if window >= time.Hour {
+		return strings.TrimSuffix(strings.TrimSuffix(window.String(), "0s"), "0m")
+	}
+	return strings.TrimSuffix(window.String(), "0s")
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
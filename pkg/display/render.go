@@ -0,0 +1,299 @@
+package display
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Frame is everything the terminal shows at one point in time. Lines are
+// made of styled spans, every renderer shows the styles its output can.
+type Frame struct {
+	Time     time.Time
+	Pane     string
+	Width    int
+	Height   int
+	Sections []Section // top to bottom
+}
+
+// Section is a part of the screen: the title, the tabs, the table of the
+// active pane and so on. Sections showing a table also carry it as data.
+type Section struct {
+	Name  string
+	Lines []Line
+	Table *TableData
+}
+
+// TableData is a table as data: the titles of all columns and the rows that
+// pass the filter, in the order shown.
+type TableData struct {
+	Columns  []string
+	Rows     [][]string
+	Selected int // index into Rows
+}
+
+// Line is a line of the screen.
+type Line []Span
+
+// String returns the text of the line without styles.
+func (l Line) String() string {
+	var b strings.Builder
+	for _, s := range l {
+		b.WriteString(s.Text)
+	}
+	return b.String()
+}
+
+// Span is a piece of a line drawn in one style.
+type Span struct {
+	Text  string
+	Style Style
+}
+
+// Style is how a span is drawn.
+type Style struct {
+	Color   Color
+	Bold    bool
+	Dim     bool
+	Reverse bool
+	Border  bool // box drawing, only there for the looks
+}
+
+// Color is the foreground color of a span.
+type Color uint8
+
+const (
+	ColorDefault Color = iota
+	ColorRed
+	ColorGreen
+	ColorBlue
+	ColorCyan
+	ColorGray
+	ColorYellow
+)
+
+// lines returns the lines of all sections in order.
+func (f *Frame) lines() []Line {
+	var lines []Line
+	for _, s := range f.Sections {
+		lines = append(lines, s.Lines...)
+	}
+	return lines
+}
+
+// Renderer writes frames to an output.
+type Renderer interface {
+	// Open prepares the output for frames, Close gives it back.
+	Open() error
+	Close() error
+	Render(f *Frame) error
+	// Invalidate makes the next Render write the whole frame, for when
+	// something else wrote to the output.
+	Invalidate()
+}
+
+// NewRenderer returns a renderer by name: "ansi", "plain", "json" or
+// "auto", which picks ANSI for terminals and plain text otherwise. ANSI
+// output has no colors if NO_COLOR is set, bold and reverse video stay so
+// the selection is still visible.
+func NewRenderer(name string, out *os.File) (Renderer, error) {
+	color := os.Getenv("NO_COLOR") == ""
+	switch name {
+	case "auto":
+		if _, err := unix.IoctlGetTermios(int(out.Fd()), unix.TCGETS); err != nil {
+			return NewPlainRenderer(out), nil
+		}
+		return NewANSIRenderer(out, color), nil
+	case "ansi":
+		return NewANSIRenderer(out, color), nil
+	case "plain":
+		return NewPlainRenderer(out), nil
+	case "json":
+		return NewJSONRenderer(out), nil
+	}
+	return nil, fmt.Errorf("unknown output %q, expected auto, ansi, plain or json", name)
+}
+
+// ansiRenderer draws frames in place on the alternate screen of a
+// terminal. Only lines that changed since the last frame are written.
+type ansiRenderer struct {
+	out    io.Writer
+	screen *screen
+	color  bool
+}
+
+func NewANSIRenderer(out io.Writer, color bool) Renderer {
+	return &ansiRenderer{out: out, screen: &screen{out: out}, color: color}
+}
+
+func (r *ansiRenderer) Open() error {
+	// Alternate screen, hide the cursor
+	_, err := io.WriteString(r.out, "\033[?1049h\033[?25l")
+	r.screen.invalidate()
+	return err
+}
+
+func (r *ansiRenderer) Close() error {
+	_, err := io.WriteString(r.out, "\033[?25h\033[?1049l")
+	return err
+}
+
+func (r *ansiRenderer) Render(f *Frame) error {
+	lines := make([]string, 0, f.Height)
+	for _, line := range f.lines() {
+		lines = append(lines, r.encode(truncate(line, f.Width)))
+	}
+	return r.screen.draw(lines)
+}
+
+// SGR parameters of the colors
+var ansiColors = [...]string{
+	ColorRed:    "31",
+	ColorGreen:  "32",
+	ColorBlue:   "34",
+	ColorCyan:   "36",
+	ColorGray:   "90",
+	ColorYellow: "33",
+}
+
+// ansiReset ends the style of a span.
+const ansiReset = "\033[0m"
+
+// encode returns a line with its styles as escape sequences. Without colors
+// bold, dim and reverse video are still shown, so the selection stays
+// visible.
+func (r *ansiRenderer) encode(l Line) string {
+	var b strings.Builder
+	for _, s := range l {
+		var params []string
+		if s.Style.Bold {
+			params = append(params, "1")
+		}
+		if s.Style.Dim {
+			params = append(params, "2")
+		}
+		if s.Style.Reverse {
+			params = append(params, "7")
+		}
+		if r.color && s.Style.Color != ColorDefault {
+			params = append(params, ansiColors[s.Style.Color])
+		}
+		if len(params) == 0 {
+			b.WriteString(s.Text)
+			continue
+		}
+		fmt.Fprintf(&b, "\033[%sm%s%s", strings.Join(params, ";"), s.Text, ansiReset)
+	}
+	return b.String()
+}
+
+func (r *ansiRenderer) Invalidate() {
+	r.screen.invalidate()
+}
+
+// plainRenderer writes frames one after the other as plain text, for logs
+// and pipes. Frames equal to the last one are skipped.
+type plainRenderer struct {
+	out  io.Writer
+	prev string
+}
+
+func NewPlainRenderer(out io.Writer) Renderer {
+	return &plainRenderer{out: out}
+}
+
+func (r *plainRenderer) Open() error  { return nil }
+func (r *plainRenderer) Close() error { return nil }
+
+func (r *plainRenderer) Render(f *Frame) error {
+	var b strings.Builder
+	for _, line := range f.lines() {
+		b.WriteString(strings.TrimRight(truncate(line, f.Width).String(), " "))
+		b.WriteByte('\n')
+	}
+	frame := b.String()
+	if frame == r.prev {
+		return nil
+	}
+	r.prev = frame
+
+	// A blank line between frames
+	_, err := io.WriteString(r.out, frame+"\n")
+	return err
+}
+
+func (r *plainRenderer) Invalidate() {
+	r.prev = ""
+}
+
+// jsonRenderer writes every frame as one line of JSON. Lines are plain text
+// without box drawing and blank lines, tables are given as data.
+type jsonRenderer struct {
+	enc *json.Encoder
+}
+
+func NewJSONRenderer(out io.Writer) Renderer {
+	return &jsonRenderer{enc: json.NewEncoder(out)}
+}
+
+type jsonFrame struct {
+	Time     time.Time     `json:"time"`
+	Pane     string        `json:"pane"`
+	Width    int           `json:"width"`
+	Height   int           `json:"height"`
+	Sections []jsonSection `json:"sections"`
+}
+
+type jsonSection struct {
+	Name  string     `json:"name"`
+	Lines []string   `json:"lines,omitempty"`
+	Table *jsonTable `json:"table,omitempty"`
+}
+
+type jsonTable struct {
+	Columns  []string   `json:"columns"`
+	Rows     [][]string `json:"rows"`
+	Selected int        `json:"selected"`
+}
+
+func (r *jsonRenderer) Open() error  { return nil }
+func (r *jsonRenderer) Close() error { return nil }
+
+func (r *jsonRenderer) Render(f *Frame) error {
+	out := jsonFrame{Time: f.Time, Pane: f.Pane, Width: f.Width, Height: f.Height}
+	for _, s := range f.Sections {
+		section := jsonSection{Name: s.Name}
+		if s.Table != nil {
+			// The lines only draw the table
+			section.Table = &jsonTable{Columns: s.Table.Columns, Rows: s.Table.Rows, Selected: s.Table.Selected}
+			if section.Table.Rows == nil {
+				section.Table.Rows = [][]string{}
+			}
+			out.Sections = append(out.Sections, section)
+			continue
+		}
+		for _, line := range s.Lines {
+			var b strings.Builder
+			for _, span := range line {
+				if !span.Style.Border {
+					b.WriteString(span.Text)
+				}
+			}
+			if text := strings.TrimSpace(b.String()); text != "" {
+				section.Lines = append(section.Lines, text)
+			}
+		}
+		out.Sections = append(out.Sections, section)
+	}
+	if err := r.enc.Encode(out); err != nil {
+		return fmt.Errorf("failed to encode frame: %w", err)
+	}
+	return nil
+}
+
+func (r *jsonRenderer) Invalidate() {}
@@ -0,0 +1,207 @@
+package display
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/ebpf"
+	"github.com/lonelysadness/OpenMonitor/pkg/ebpf/dns"
+	"github.com/lonelysadness/OpenMonitor/pkg/flow"
+	"github.com/lonelysadness/OpenMonitor/pkg/netutils"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// testStart is when the sample terminal starts, in UTC so times are
+// formatted the same in every time zone.
+var testStart = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// sampleTerminal returns a terminal of a fixed size with a bit of
+// everything, drawing with renderer.
+func sampleTerminal(renderer Renderer) *Terminal {
+	now := testStart
+	term := NewTerminal(renderer, func() time.Time { return now })
+	term.width, term.height = 100, 30
+
+	outbound := flow.New(flow.TCP, flow.Outbound,
+		netip.MustParseAddrPort("192.168.1.10:51000"), netip.MustParseAddrPort("93.184.216.34:443"))
+	outbound.PID = 4242
+	inbound := flow.New(flow.TCP, flow.Inbound,
+		netip.MustParseAddrPort("192.168.1.10:22"), netip.MustParseAddrPort("192.168.1.20:60000"))
+	resolver := flow.New(flow.UDP, flow.Outbound,
+		netip.MustParseAddrPort("[2001:db8::10]:40000"), netip.MustParseAddrPort("[2001:db8::53]:53"))
+	resolver.PID = 900
+	for i, conn := range []*Connection{
+		{Flow: outbound, Process: "curl[4242]", Scope: netutils.Global},
+		{Flow: inbound, Process: "sshd[1]", Scope: netutils.SiteLocal},
+		{Flow: resolver, Process: "systemd-resolve[900]", Scope: netutils.Global},
+	} {
+		conn.FirstSeen = now.Add(-time.Duration(i+1) * time.Minute)
+		conn.LastSeen = now
+		term.UpdateConnections(conn)
+	}
+
+	// Two samples five seconds apart give every rate a value
+	for i, at := range []time.Time{now.Add(-5 * time.Second), now} {
+		n := uint64(i + 1)
+		term.UpdateBandwidth(n*1<<20, n*256<<10, at)
+		term.UpdateConnectionTraffic([]ebpf.ConnectionBandwidth{
+			{Flow: outbound, RX: n * 800 << 10, TX: n * 64 << 10},
+			{Flow: inbound, RX: n * 8 << 10, TX: n * 16 << 10},
+		}, at)
+		term.UpdateProcessBandwidth([]ProcessBandwidth{
+			{Name: "curl", PID: 4242, RX: n * 800 << 10, TX: n * 64 << 10, SRTT: 42 * time.Millisecond},
+			{Name: "sshd", PID: 1, RX: n * 8 << 10, TX: n * 16 << 10, SRTT: 3 * time.Millisecond},
+		}, at)
+	}
+	term.UpdateTCPHealth([]ebpf.ConnectionHealth{
+		{Flow: outbound, SRTT: 420 * time.Millisecond, Retransmits: 12, RetransmitRate: 0.5, State: 1},
+	})
+
+	term.UpdateServices([]Service{
+		{Protocol: "TCP", Addr: net.IPv4zero, Port: 22, Owner: "sshd[1]", Exposed: true},
+		{Protocol: "UDP", Addr: net.IPv4(127, 0, 0, 53), Port: 53, Owner: "systemd-resolve[900]"},
+	}, 2, 1)
+	term.UpdateQueueStats([]QueueSnapshot{
+		{ID: 17041, Inbound: true, Total: 120, Accept: 118, Block: 2},
+		{ID: 17040, Total: 300, Accept: 290, Block: 9, Drop: 1, Errors: 3},
+	})
+	term.UpdateEventStats([]ebpf.RingBufferStats{
+		{Name: "connections", Received: 1500},
+		{Name: "dns", Received: 80, ReserveFailed: 4},
+	})
+	term.UpdateMapStats([]ebpf.MapStats{{Name: "om_bandwidth_map", Entries: 37, Capacity: 4096}})
+	term.UpdateFeatures([]string{"no BTF, TCP health is read without CO-RE"})
+	term.UpdatePrograms([]ebpf.Status{
+		{Name: "bandwidth", State: ebpf.StateAttached, Since: now.Add(-time.Hour)},
+		{Name: "exec", State: ebpf.StateFailed, Since: now.Add(-time.Minute),
+			Err: errors.New("permission denied"), Failures: 2},
+	})
+
+	for i, act := range []Activity{
+		{Direction: "OUT", Message: "TCP 192.168.1.10:51000 -> 93.184.216.34:443 curl[4242]", Flow: outbound, Process: "curl"},
+		{Direction: "IN", Message: "TCP 192.168.1.20:60000 -> 192.168.1.10:22 sshd[1]", Flow: inbound, Process: "sshd"},
+		{Direction: "BLOCK", Message: "curl[4242] -> 203.0.113.9:80 [6] (rule #3)", Process: "curl"},
+	} {
+		act.Timestamp = now.Add(-time.Duration(3-i) * time.Second)
+		term.AddActivity(act)
+	}
+	lookup := &dns.Message{Time: now.Add(-2 * time.Second), PID: 4242, Comm: "curl", Server: net.ParseIP("2001:db8::53"),
+		Response: true, Name: "example.com", Type: "A", RCode: "Success", Answers: []string{"93.184.216.34"}}
+	term.UpdateDNS([]*dns.Message{lookup})
+	term.UpdateProcessDNS(lookup.PID, []*dns.Message{lookup})
+	return term
+}
+
+// golden compares got with a file in testdata, or rewrites the file with
+// -update.
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file, run with -update to create it: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file:\n--- got\n%s\n--- want\n%s", name, got, want)
+	}
+}
+
+func TestPlainRendererPanes(t *testing.T) {
+	for p := pane(0); p < paneCount; p++ {
+		t.Run(paneTitles[p], func(t *testing.T) {
+			var out bytes.Buffer
+			term := sampleTerminal(NewPlainRenderer(&out))
+			term.active = p
+			term.Display()
+			golden(t, "pane_"+paneTitles[p]+".txt", out.Bytes())
+		})
+	}
+}
+
+func TestPlainRendererSkipsUnchangedFrames(t *testing.T) {
+	var out bytes.Buffer
+	term := sampleTerminal(NewPlainRenderer(&out))
+	term.Display()
+	first := out.Len()
+	term.Display()
+	if out.Len() != first {
+		t.Errorf("unchanged frame written again, %d bytes after %d", out.Len(), first)
+	}
+}
+
+func TestANSIRenderer(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		color bool
+	}{
+		{"color", true},
+		{"nocolor", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			term := sampleTerminal(NewANSIRenderer(&out, tc.color))
+			term.Display()
+			golden(t, "ansi_"+tc.name+".txt", out.Bytes())
+		})
+	}
+}
+
+func TestANSIRendererRedrawsChangedLines(t *testing.T) {
+	var out bytes.Buffer
+	term := sampleTerminal(NewANSIRenderer(&out, true))
+	term.Display()
+	out.Reset()
+
+	// Only the footer shows the notice
+	term.Notify("Rules saved")
+	term.Display()
+	golden(t, "ansi_update.txt", out.Bytes())
+}
+
+func TestJSONRenderer(t *testing.T) {
+	for _, p := range []pane{paneQueues, paneProcesses} {
+		t.Run(paneTitles[p], func(t *testing.T) {
+			var out bytes.Buffer
+			term := sampleTerminal(NewJSONRenderer(&out))
+			term.active = p
+			term.Display()
+			golden(t, "json_"+strings.ToLower(paneTitles[p])+".json", out.Bytes())
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	line := Line{{Text: " "}, {"RX: 1 KB/s", cyan}, {Text: "  "}, {"(total)", gray}}
+	for _, tc := range []struct {
+		width int
+		want  Line
+	}{
+		{22, line},
+		{20, line},
+		{8, Line{{Text: " "}, {"RX: 1 …", cyan}}},
+		{12, Line{{Text: " "}, {"RX: 1 KB/s", cyan}, {"…", Style{}}}},
+		{0, nil},
+	} {
+		got := truncate(line, tc.width)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("truncate(%q, %d) = %q, want %q", line, tc.width, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package display
+
+import (
+	"fmt"
+	"net"
+)
+
+// How many listening sockets are listed in the services section.
+const serviceCount = 8
+
+// Service is a listening socket with its owner resolved for display.
+type Service struct {
+	Protocol string
+	Addr     net.IP
+	Port     uint16
+	Owner    string
+	Exposed  bool // bound to a non-loopback address
+}
+
+// services returns the listening sockets, exposed ones first, and how many
+// of all sockets are exposed.
+func (m *Monitor) services() ([]Service, int) {
+	listeners := m.listeners.Snapshot()
+
+	exposed := 0
+	services := make([]Service, 0, min(len(listeners), serviceCount))
+	for _, l := range listeners {
+		if l.Exposed() {
+			exposed++
+		}
+		if len(services) == serviceCount {
+			continue
+		}
+
+		owner := describeProcess(m.procs, l.PID)
+		if owner == "" && l.Comm != "" {
+			owner = fmt.Sprintf("%s[%d]", l.Comm, l.PID)
+		}
+		services = append(services, Service{
+			Protocol: l.ProtocolName(),
+			Addr:     l.Addr,
+			Port:     l.Port,
+			Owner:    owner,
+			Exposed:  l.Exposed(),
+		})
+	}
+	return services, exposed
+}
@@ -0,0 +1,220 @@
+package display
+
+import (
+	"context"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/ebpf"
+	"github.com/lonelysadness/OpenMonitor/pkg/nfq"
+)
+
+// How many activities a snapshot carries, newest first.
+const snapshotActivities = 200
+
+// Snapshot is what the monitor sees at one point in time, for clients that
+// don't share its terminal.
+type Snapshot struct {
+	Time        time.Time            `json:"time"`
+	Bandwidth   BandwidthSnapshot    `json:"bandwidth"`
+	Connections []ConnectionSnapshot `json:"connections"`
+	Processes   []ProcessSnapshot    `json:"processes"`
+	Queues      []QueueSnapshot      `json:"queues"`
+	Programs    []ProgramSnapshot    `json:"programs"`
+	Activities  []ActivitySnapshot   `json:"activities"`
+}
+
+// BandwidthSnapshot is the traffic of all sockets, rates in bytes per
+// second.
+type BandwidthSnapshot struct {
+	RX     uint64  `json:"rx"`
+	TX     uint64  `json:"tx"`
+	RXRate float64 `json:"rx_rate"`
+	TXRate float64 `json:"tx_rate"`
+}
+
+type ConnectionSnapshot struct {
+	Protocol  string         `json:"protocol"`
+	Direction string         `json:"direction"`
+	Local     netip.AddrPort `json:"local"`
+	Remote    netip.AddrPort `json:"remote"`
+	PID       uint32         `json:"pid,omitempty"`
+	Process   string         `json:"process,omitempty"`
+	Scope     string         `json:"scope"`
+	FirstSeen time.Time      `json:"first_seen"`
+	LastSeen  time.Time      `json:"last_seen"`
+	RX        uint64         `json:"rx"`
+	TX        uint64         `json:"tx"`
+	RXRate    float64        `json:"rx_rate"`
+	TXRate    float64        `json:"tx_rate"`
+}
+
+type ProcessSnapshot struct {
+	Name   string  `json:"name"`
+	PID    uint32  `json:"pid"`
+	RX     uint64  `json:"rx"`
+	TX     uint64  `json:"tx"`
+	RXRate float64 `json:"rx_rate"`
+	TXRate float64 `json:"tx_rate"`
+}
+
+type QueueSnapshot struct {
+	ID         uint16 `json:"id"`
+	Inbound    bool   `json:"inbound"`
+	Total      uint64 `json:"total"`
+	Accept     uint64 `json:"accept"`
+	Block      uint64 `json:"block"`
+	Drop       uint64 `json:"drop"`
+	AcceptPerm uint64 `json:"accept_permanent"`
+	BlockPerm  uint64 `json:"block_permanent"`
+	DropPerm   uint64 `json:"drop_permanent"`
+	Errors     uint64 `json:"errors"`
+}
+
+type ProgramSnapshot struct {
+	Name     string    `json:"name"`
+	State    string    `json:"state"`
+	Since    time.Time `json:"since"`
+	Degraded []string  `json:"degraded,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Failures int       `json:"failures,omitempty"`
+}
+
+type ActivitySnapshot struct {
+	Time     time.Time      `json:"time"`
+	Verdict  string         `json:"verdict"` // IN, OUT, BLOCK, KILL or ICMP
+	Message  string         `json:"message"`
+	Protocol string         `json:"protocol,omitempty"`
+	Local    netip.AddrPort `json:"local"`
+	Remote   netip.AddrPort `json:"remote"`
+	PID      uint32         `json:"pid,omitempty"`
+	Process  string         `json:"process,omitempty"`
+}
+
+// Snapshot returns what the monitor currently sees. It is answered by the
+// loop of Start, so it blocks until Start runs or ctx is done.
+func (m *Monitor) Snapshot(ctx context.Context) (Snapshot, error) {
+	reply := make(chan Snapshot, 1)
+	select {
+	case m.snapshots <- reply:
+	case <-ctx.Done():
+		return Snapshot{}, ctx.Err()
+	}
+	select {
+	case s := <-reply:
+		return s, nil
+	case <-ctx.Done():
+		return Snapshot{}, ctx.Err()
+	}
+}
+
+// snapshot collects the state of the terminal, the queues and the eBPF
+// components.
+func (m *Monitor) snapshot(now time.Time, inQueue, outQueue *nfq.Queue) Snapshot {
+	s := m.term.snapshot(now)
+	s.Queues = queueSnapshots(inQueue, outQueue)
+	for _, status := range m.programs() {
+		s.Programs = append(s.Programs, programSnapshot(status))
+	}
+	return s
+}
+
+// queueSnapshots returns the verdict counters of the packet queues.
+func queueSnapshots(inQueue, outQueue *nfq.Queue) []QueueSnapshot {
+	queues := make([]QueueSnapshot, 0, 2)
+	for _, q := range []struct {
+		queue   *nfq.Queue
+		inbound bool
+	}{{inQueue, true}, {outQueue, false}} {
+		stats := q.queue.GetVerdictStats()
+		queues = append(queues, QueueSnapshot{
+			ID:         q.queue.ID(),
+			Inbound:    q.inbound,
+			Total:      stats.Total,
+			Accept:     stats.Accept,
+			Block:      stats.Block,
+			Drop:       stats.Drop,
+			AcceptPerm: stats.AcceptPerm,
+			BlockPerm:  stats.BlockPerm,
+			DropPerm:   stats.DropPerm,
+			Errors:     stats.Errors,
+		})
+	}
+	return queues
+}
+
+func programSnapshot(status ebpf.Status) ProgramSnapshot {
+	p := ProgramSnapshot{
+		Name:     status.Name,
+		State:    status.State.String(),
+		Since:    status.Since,
+		Degraded: status.Degraded,
+		Failures: status.Failures,
+	}
+	if status.Err != nil {
+		p.Error = status.Err.Error()
+	}
+	return p
+}
+
+// snapshot collects connections, processes, bandwidth and the newest
+// activities.
+func (t *Terminal) snapshot(now time.Time) Snapshot {
+	s := Snapshot{
+		Time:        now,
+		Connections: make([]ConnectionSnapshot, 0, len(t.connections)),
+		Processes:   make([]ProcessSnapshot, 0, len(t.processes)),
+	}
+	s.Bandwidth.RX, s.Bandwidth.TX = t.rx, t.tx
+	s.Bandwidth.RXRate, s.Bandwidth.TXRate = t.rates.current(now)
+
+	for _, conn := range t.connections {
+		c := ConnectionSnapshot{
+			Protocol:  conn.Protocol.String(),
+			Direction: conn.Direction.String(),
+			Local:     conn.Local,
+			Remote:    conn.Remote,
+			PID:       conn.PID,
+			Process:   conn.Process,
+			Scope:     conn.Scope.String(),
+			FirstSeen: conn.FirstSeen,
+			LastSeen:  conn.LastSeen,
+			RX:        conn.RX,
+			TX:        conn.TX,
+		}
+		c.RXRate, c.TXRate = conn.rates.current(now)
+		s.Connections = append(s.Connections, c)
+	}
+	// Newest first, like the connection pane
+	sort.Slice(s.Connections, func(i, j int) bool {
+		return s.Connections[i].FirstSeen.After(s.Connections[j].FirstSeen)
+	})
+
+	for _, proc := range t.processes {
+		p := ProcessSnapshot{Name: proc.Name, PID: proc.PID, RX: proc.RX, TX: proc.TX}
+		if rates, ok := t.processRates[proc.PID]; ok {
+			p.RXRate, p.TXRate = rates.current(now)
+		}
+		s.Processes = append(s.Processes, p)
+	}
+
+	s.Activities = make([]ActivitySnapshot, 0, min(t.activities.len(), snapshotActivities))
+	t.activities.newest(func(act *Activity) bool {
+		a := ActivitySnapshot{
+			Time:    act.Timestamp,
+			Verdict: act.Direction,
+			Message: act.Message,
+			Local:   act.Flow.Local,
+			Remote:  act.Flow.Remote,
+			PID:     act.Flow.PID,
+			Process: act.Process,
+		}
+		if act.Flow.Protocol != 0 {
+			a.Protocol = act.Flow.Protocol.String()
+		}
+		s.Activities = append(s.Activities, a)
+		return len(s.Activities) < snapshotActivities
+	})
+	return s
+}
@@ -0,0 +1,261 @@
+package display
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// column describes a table column. Numeric columns sort by cell.value and
+// are right aligned.
+type column struct {
+	title   string
+	width   int // the flexible column grows beyond it, the others are fixed
+	numeric bool
+	flex    bool // takes the width left over by the other columns
+
+	// Columns are dropped from the highest priority down when the screen is
+	// too narrow, priority 0 is always shown.
+	priority int
+}
+
+// Narrowest a flexible column gets before other columns are dropped.
+const minFlexWidth = 12
+
+type cell struct {
+	text  string
+	value float64
+}
+
+type row struct {
+	cells []cell
+	color Color
+	ref   any // what the row shows, for acting on the selection
+}
+
+// table is the content of a pane that can be sorted, filtered and scrolled.
+type table struct {
+	columns []column
+	rows    []row
+	match   func(ref any, query string) bool // replaces the text search if set
+}
+
+// paneState is what the user selected in a pane.
+type paneState struct {
+	sortColumn int
+	descending bool
+	selected   int
+	offset     int
+}
+
+// filter keeps the rows containing query in any cell, ignoring case, or
+// the ones accepted by match.
+func (t *table) filter(query string) {
+	if query == "" {
+		return
+	}
+	if t.match != nil {
+		kept := t.rows[:0]
+		for _, r := range t.rows {
+			if t.match(r.ref, query) {
+				kept = append(kept, r)
+			}
+		}
+		t.rows = kept
+		return
+	}
+	query = strings.ToLower(query)
+
+	kept := t.rows[:0]
+	for _, r := range t.rows {
+		for _, c := range r.cells {
+			if strings.Contains(strings.ToLower(c.text), query) {
+				kept = append(kept, r)
+				break
+			}
+		}
+	}
+	t.rows = kept
+}
+
+// sortBy sorts the rows by a column. Equal rows keep their order.
+func (t *table) sortBy(col int, descending bool) {
+	if col < 0 || col >= len(t.columns) {
+		return
+	}
+	numeric := t.columns[col].numeric
+	sort.SliceStable(t.rows, func(i, j int) bool {
+		a, b := t.rows[i].cells[col], t.rows[j].cells[col]
+		if descending {
+			a, b = b, a
+		}
+		if numeric {
+			return a.value < b.value
+		}
+		return a.text < b.text
+	})
+}
+
+// layout returns the columns shown in width characters and their widths.
+func (t *table) layout(width int) ([]int, []int) {
+	shown := make([]int, len(t.columns))
+	for i := range shown {
+		shown[i] = i
+	}
+
+	// One space in front of every column
+	needed := func() int {
+		total := 0
+		for _, i := range shown {
+			col := t.columns[i]
+			if col.flex {
+				total += minFlexWidth + 1
+			} else {
+				total += col.width + 1
+			}
+		}
+		return total
+	}
+	for needed() > width {
+		drop := -1
+		for j, i := range shown {
+			if p := t.columns[i].priority; p > 0 && (drop < 0 || p > t.columns[shown[drop]].priority) {
+				drop = j
+			}
+		}
+		if drop < 0 {
+			break
+		}
+		shown = append(shown[:drop], shown[drop+1:]...)
+	}
+
+	widths := make([]int, len(shown))
+	used := 0
+	flex := -1
+	for j, i := range shown {
+		widths[j] = t.columns[i].width
+		if t.columns[i].flex {
+			flex = j
+			widths[j] = minFlexWidth
+		}
+		used += widths[j] + 1
+	}
+	if flex >= 0 {
+		widths[flex] = max(width-used+minFlexWidth, 1)
+	}
+	return shown, widths
+}
+
+// render returns the header and as many rows as fit in height lines of
+// width characters, scrolled so the selected row is visible.
+func (t *table) render(state *paneState, width, height int) []Line {
+	if height < 2 {
+		return nil
+	}
+	visible := height - 1
+
+	if state.selected >= len(t.rows) {
+		state.selected = len(t.rows) - 1
+	}
+	if state.selected < 0 {
+		state.selected = 0
+	}
+	if state.selected < state.offset {
+		state.offset = state.selected
+	}
+	if state.selected >= state.offset+visible {
+		state.offset = state.selected - visible + 1
+	}
+	if state.offset > len(t.rows)-visible {
+		state.offset = max(len(t.rows)-visible, 0)
+	}
+
+	shown, widths := t.layout(width)
+	header := make([]string, len(shown))
+	for j, i := range shown {
+		col := t.columns[i]
+		title := col.title
+		if i == state.sortColumn {
+			if state.descending {
+				title += "↓"
+			} else {
+				title += "↑"
+			}
+		}
+		header[j] = fit(title, widths[j], col.numeric)
+	}
+	lines := []Line{{{" " + strings.Join(header, " "), strong}}}
+
+	if len(t.rows) == 0 {
+		return append(lines, Line{{"   nothing to show", faint}})
+	}
+	end := min(state.offset+visible, len(t.rows))
+	for i := state.offset; i < end; i++ {
+		r := t.rows[i]
+		cells := make([]string, len(shown))
+		for j, i := range shown {
+			cells[j] = fit(r.cells[i].text, widths[j], t.columns[i].numeric)
+		}
+		style := Style{Color: r.color, Reverse: i == state.selected}
+		lines = append(lines, Line{{" " + strings.Join(cells, " "), style}})
+	}
+	return lines
+}
+
+// data returns the titles of all columns and the text of all rows.
+func (t *table) data(state *paneState) *TableData {
+	d := &TableData{Columns: make([]string, len(t.columns)), Selected: state.selected}
+	for i, col := range t.columns {
+		d.Columns[i] = col.title
+	}
+	for _, r := range t.rows {
+		cells := make([]string, len(r.cells))
+		for i, c := range r.cells {
+			cells[i] = c.text
+		}
+		d.Rows = append(d.Rows, cells)
+	}
+	return d
+}
+
+// fit pads s to width characters, or cuts it and marks the cut with an
+// ellipsis.
+func fit(s string, width int, right bool) string {
+	if width <= 0 {
+		return ""
+	}
+	if n := utf8.RuneCountInString(s); n > width {
+		s = string([]rune(s)[:width-1]) + ellipsis
+	} else if right {
+		s = strings.Repeat(" ", width-n) + s
+	} else {
+		s += strings.Repeat(" ", width-n)
+	}
+	return s
+}
+
+const ellipsis = "…"
+
+// truncate cuts a line to width characters and marks the cut with an
+// ellipsis in the style of the span it falls in.
+func truncate(l Line, width int) Line {
+	if utf8.RuneCountInString(l.String()) <= width {
+		return l
+	}
+	if width <= 0 {
+		return nil
+	}
+	cut := make(Line, 0, len(l))
+	left := width - 1
+	for _, s := range l {
+		n := utf8.RuneCountInString(s.Text)
+		if n <= left {
+			cut = append(cut, s)
+			left -= n
+			continue
+		}
+		s.Text = string([]rune(s.Text)[:left]) + ellipsis
+		return append(cut, s)
+	}
+	return cut
+}
@@ -2,25 +2,43 @@ package display
 
 import (
 	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"golang.org/x/sys/unix"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/ebpf"
+	"github.com/lonelysadness/OpenMonitor/pkg/ebpf/dns"
+	"github.com/lonelysadness/OpenMonitor/pkg/flow"
 	"github.com/lonelysadness/OpenMonitor/pkg/netutils"
 	"github.com/lonelysadness/OpenMonitor/pkg/nfq"
 )
 
-// ANSI color and style constants
+// Styles of the lines the terminal builds
+var (
+	red     = Style{Color: ColorRed}
+	green   = Style{Color: ColorGreen}
+	blue    = Style{Color: ColorBlue}
+	cyan    = Style{Color: ColorCyan}
+	gray    = Style{Color: ColorGray}
+	yellow  = Style{Color: ColorYellow}
+	strong  = Style{Bold: true}
+	faint   = Style{Dim: true}
+	heading = Style{Bold: true, Color: ColorYellow}
+)
+
+// Size of the screen if the terminal doesn't tell, and the smallest one the
+// layout works with.
 const (
-	colorReset   = "\033[0m"
-	colorRed     = "\033[31m"
-	colorGreen   = "\033[32m"
-	colorBlue    = "\033[34m"
-	colorCyan    = "\033[36m"
-	colorGray    = "\033[90m"
-	colorYellow  = "\033[33m"
-	colorMagenta = "\033[35m"
-	bold         = "\033[1m"
-	dim          = "\033[2m"
+	defaultWidth  = 80
+	defaultHeight = 24
+	minWidth      = 20
+	minHeight     = 10
 )
 
 // Box drawing characters
@@ -33,55 +51,397 @@ const (
 	vertical    = "│"
 )
 
-type Activity struct {
-	Direction string
-	Message   string
-	Timestamp time.Time
-}
+// pane is one of the tabs of the terminal.
+type pane int
+
+const (
+	paneConnections pane = iota
+	paneProcesses
+	paneBandwidth
+	paneQueues
+	paneActivity
+	paneCount
+)
+
+var paneTitles = [paneCount]string{"Connections", "Processes", "Bandwidth", "Queues", "Activity"}
 
+// Terminal is the interactive user interface. It is not safe for concurrent
+// use, the monitor updates it and feeds it keys from one goroutine.
 type Terminal struct {
-	connections map[string]time.Time // Add this field back
-	activities  []Activity
-	bandwidth   string
-	queueStats  string
+	connections  map[flow.Key]*Connection
+	activities   *activityRing
+	rx, tx       uint64 // totals of all sockets
+	rates        rateHistory
+	processes    []ProcessBandwidth
+	processRates map[uint32]*rateHistory
+	queues       []QueueSnapshot
+	eventStats   []ebpf.RingBufferStats
+	mapStats     []ebpf.MapStats
+	missing      []string // kernel features worked around or unavailable
+	programs     []ebpf.Status
+	dnsMessages  []*dns.Message
+	processDNS   map[uint32][]*dns.Message
+	degraded     []ebpf.ConnectionHealth
+	services     []Service
+	listening    int
+	exposed      int
+
+	tty       *tty
+	renderer  Renderer
+	now       func() time.Time
+	width     int
+	height    int
+	active    pane
+	panes     [paneCount]paneState
+	filter    string
+	filtering bool        // keys go to the filter box
+	confirm   *Connection // asking whether to kill it
+	notice    string
+	window    int    // index into rateWindows
+	paused    uint64 // last activity shown while paused, 0 if live
 }
 
-func NewTerminal() *Terminal {
+// NewTerminal returns a terminal drawing with renderer. Ages and rates are
+// taken at the time now returns.
+func NewTerminal(renderer Renderer, now func() time.Time) *Terminal {
 	return &Terminal{
-		connections: make(map[string]time.Time), // Initialize connections
-		activities:  make([]Activity, 0, 5),
-		bandwidth:   "No data",
+		connections:  make(map[flow.Key]*Connection),
+		activities:   newActivityRing(activityHistory),
+		processRates: make(map[uint32]*rateHistory),
+		processDNS:   make(map[uint32][]*dns.Message),
+		renderer:     renderer,
+		now:          now,
+		width:        defaultWidth,
+		height:       defaultHeight,
+		panes: [paneCount]paneState{
+			paneConnections: {sortColumn: 9},
+			paneProcesses:   {sortColumn: 2, descending: true},
+			paneBandwidth:   {sortColumn: 3, descending: true},
+			paneActivity:    {sortColumn: 0, descending: true},
+		},
+	}
+}
+
+// Open takes over the terminal: raw mode for reading keys and the alternate
+// screen for drawing. Close gives it back.
+func (t *Terminal) Open() error {
+	tty, err := openTTY(os.Stdin)
+	if err != nil {
+		return err
 	}
+	t.tty = tty
+	if err := t.renderer.Open(); err != nil {
+		return fmt.Errorf("failed to prepare output: %w", err)
+	}
+	t.Resize()
+	return nil
+}
+
+// Resize picks up the size of the terminal, call it on SIGWINCH. The next
+// Display repaints everything.
+func (t *Terminal) Resize() {
+	t.width, t.height = defaultWidth, defaultHeight
+	if ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ); err == nil && ws.Col > 0 && ws.Row > 0 {
+		t.width = max(int(ws.Col), minWidth)
+		t.height = max(int(ws.Row), minHeight)
+	}
+	t.renderer.Invalidate()
+}
+
+// Close restores the terminal as it was before Open.
+func (t *Terminal) Close() error {
+	err := t.renderer.Close()
+	if t.tty == nil {
+		return err
+	}
+	if restoreErr := t.tty.restore(); restoreErr != nil {
+		err = restoreErr
+	}
+	t.tty = nil
+	return err
 }
 
-// Add these two missing methods
-func (t *Terminal) UpdateConnections(key string, timestamp time.Time) {
-	t.connections[key] = timestamp
+// Keys returns the keys typed by the user, nil without a terminal.
+func (t *Terminal) Keys() <-chan key {
+	if t.tty == nil {
+		return nil
+	}
+	return t.tty.keys
 }
 
+// commandKind is an action the user asked the monitor to take.
+type commandKind int
+
+const (
+	commandNone commandKind = iota
+	commandQuit
+	commandKill             // terminate the connection
+	commandBlockDestination // terminate it and block its destination
+	commandBlockProcess     // terminate it and block its process
+)
+
+type command struct {
+	kind commandKind
+	conn *Connection
+}
+
+// HandleKey applies a key and returns what the monitor has to do about it.
+func (t *Terminal) HandleKey(k key) command {
+	t.notice = ""
+	if conn := t.confirm; conn != nil {
+		t.confirm = nil
+		if k.code != keyRune {
+			return command{}
+		}
+		switch k.r {
+		case 'y':
+			return command{kind: commandKill, conn: conn}
+		case 'd':
+			return command{kind: commandBlockDestination, conn: conn}
+		case 'p':
+			return command{kind: commandBlockProcess, conn: conn}
+		}
+		return command{}
+	}
+
+	if t.filtering {
+		switch k.code {
+		case keyRune:
+			t.filter += string(k.r)
+		case keyBackspace:
+			if r := []rune(t.filter); len(r) > 0 {
+				t.filter = string(r[:len(r)-1])
+			}
+		case keyEnter:
+			t.filtering = false
+		case keyEscape:
+			t.filter = ""
+			t.filtering = false
+		}
+		t.panes[t.active].selected = 0
+		return command{}
+	}
+
+	state := &t.panes[t.active]
+	page := t.height / 2
+	switch k.code {
+	case keyTab, keyRight:
+		t.active = (t.active + 1) % paneCount
+	case keyBackTab, keyLeft:
+		t.active = (t.active + paneCount - 1) % paneCount
+	case keyUp:
+		state.selected--
+	case keyDown:
+		state.selected++
+	case keyPageUp:
+		state.selected -= page
+	case keyPageDown:
+		state.selected += page
+	case keyHome:
+		state.selected = 0
+	case keyEnd:
+		state.selected = math.MaxInt32
+	case keyEscape:
+		t.filter = ""
+	case keyRedraw:
+		t.renderer.Invalidate()
+	case keyRune:
+		switch r := k.r; {
+		case r >= '1' && r < '1'+rune(paneCount):
+			t.active = pane(r - '1')
+		case r == 'k':
+			state.selected--
+		case r == 'j':
+			state.selected++
+		case r == 'g':
+			state.selected = 0
+		case r == 'G':
+			state.selected = math.MaxInt32
+		case r == 's':
+			if columns := len(t.table(t.active).columns); columns > 0 {
+				state.sortColumn = (state.sortColumn + 1) % columns
+			}
+		case r == 'r':
+			state.descending = !state.descending
+		case r == '/':
+			t.filtering = true
+		case r == 'w':
+			t.window = (t.window + 1) % len(rateWindows)
+		case r == 'p' && t.active == paneActivity:
+			if t.paused == 0 {
+				t.paused = t.activities.total
+			} else {
+				t.paused = 0
+				state.selected = 0
+			}
+		case r == 'x':
+			if conn := t.selectedConnection(); conn != nil {
+				t.confirm = conn
+			}
+		case r == 'q':
+			return command{kind: commandQuit}
+		}
+	}
+	return command{}
+}
+
+// Notify shows a message until the next key.
+func (t *Terminal) Notify(message string) {
+	t.notice = message
+}
+
+// selectedConnection returns the connection selected in the connection
+// pane, nil in other panes.
+func (t *Terminal) selectedConnection() *Connection {
+	if t.active != paneConnections {
+		return nil
+	}
+	conn, _ := t.selectedRow(t.active).ref.(*Connection)
+	return conn
+}
+
+// selectedRow returns the row selected in the table of a pane, an empty one
+// if there is none.
+func (t *Terminal) selectedRow(p pane) row {
+	tbl := t.sortedTable(p)
+	state := t.panes[p]
+	if state.selected < 0 || state.selected >= len(tbl.rows) {
+		return row{}
+	}
+	return tbl.rows[state.selected]
+}
+
+// UpdateConnections adds a connection or marks a known one as seen again.
+func (t *Terminal) UpdateConnections(conn *Connection) {
+	key := conn.Key()
+	known, ok := t.connections[key]
+	if !ok {
+		t.connections[key] = conn
+		return
+	}
+	known.LastSeen = conn.LastSeen
+	if known.PID == 0 {
+		known.PID, known.Process = conn.PID, conn.Process
+	}
+}
+
+// UpdateConnectionTraffic sets the bandwidth of the known connections.
+// Connections that moved data count as seen.
+func (t *Terminal) UpdateConnectionTraffic(traffic []ebpf.ConnectionBandwidth, now time.Time) {
+	for _, bw := range traffic {
+		conn, ok := t.connections[bw.Flow.Key()]
+		if !ok {
+			continue
+		}
+		if bw.RX != conn.RX || bw.TX != conn.TX {
+			conn.LastSeen = now
+		}
+		conn.RX, conn.TX = bw.RX, bw.TX
+		conn.rates.add(now, bw.RX, bw.TX)
+	}
+}
+
+// CleanOldConnections forgets connections not seen for age.
 func (t *Terminal) CleanOldConnections(age time.Duration) {
-	now := time.Now()
-	for k, v := range t.connections {
-		if now.Sub(v) > age {
+	now := t.now()
+	for k, conn := range t.connections {
+		if now.Sub(conn.LastSeen) > age {
 			delete(t.connections, k)
 		}
 	}
 }
 
-func (t *Terminal) AddActivity(direction string, message string) {
-	t.activities = append([]Activity{{
-		Direction: direction,
-		Message:   message,
-		Timestamp: time.Now(),
-	}}, t.activities...)
-	if len(t.activities) > 5 {
-		t.activities = t.activities[:5]
+// AddActivity records an activity. It is kept while the activity pane is
+// paused and shows up once it is resumed.
+func (t *Terminal) AddActivity(a Activity) {
+	if a.Timestamp.IsZero() {
+		a.Timestamp = t.now()
+	}
+	t.activities.add(a)
+}
+
+// UpdateBandwidth sets the traffic of all sockets so far, rates are taken
+// from consecutive calls.
+func (t *Terminal) UpdateBandwidth(rx, tx uint64, now time.Time) {
+	t.rx, t.tx = rx, tx
+	t.rates.add(now, rx, tx)
+}
+
+// UpdateProcessBandwidth sets the traffic of all processes with sockets.
+// Rates are kept for processes that drop out of the list until the longest
+// window has passed.
+func (t *Terminal) UpdateProcessBandwidth(procs []ProcessBandwidth, now time.Time) {
+	t.processes = procs
+	for _, proc := range procs {
+		rates, ok := t.processRates[proc.PID]
+		if !ok {
+			rates = new(rateHistory)
+			t.processRates[proc.PID] = rates
+		}
+		rates.add(now, proc.RX, proc.TX)
+	}
+	for pid, rates := range t.processRates {
+		if now.Sub(rates.last) > rateWindows[len(rateWindows)-1] {
+			delete(t.processRates, pid)
+		}
+	}
+}
+
+func (t *Terminal) UpdateEventStats(stats []ebpf.RingBufferStats) {
+	t.eventStats = stats
+}
+
+func (t *Terminal) UpdateMapStats(stats []ebpf.MapStats) {
+	t.mapStats = stats
+}
+
+// UpdateFeatures sets the descriptions of missing kernel features.
+func (t *Terminal) UpdateFeatures(missing []string) {
+	t.missing = missing
+}
+
+// UpdatePrograms sets the status of the eBPF components.
+func (t *Terminal) UpdatePrograms(status []ebpf.Status) {
+	t.programs = status
+}
+
+func (t *Terminal) UpdateDNS(recent []*dns.Message) {
+	t.dnsMessages = recent
+}
+
+// UpdateProcessDNS sets the newest DNS messages of a process, shown when it
+// is selected in the process pane.
+func (t *Terminal) UpdateProcessDNS(pid uint32, messages []*dns.Message) {
+	if len(messages) == 0 {
+		delete(t.processDNS, pid)
+		return
+	}
+	t.processDNS[pid] = messages
+}
+
+// KeepProcessDNS forgets the DNS messages of processes not in pids.
+func (t *Terminal) KeepProcessDNS(pids []uint32) {
+	keep := make(map[uint32]bool, len(pids))
+	for _, pid := range pids {
+		keep[pid] = true
+	}
+	for pid := range t.processDNS {
+		if !keep[pid] {
+			delete(t.processDNS, pid)
+		}
 	}
 }
 
-func (t *Terminal) UpdateBandwidth(rx, tx uint64) {
-	t.bandwidth = fmt.Sprintf("RX: %s  TX: %s",
-		formatBytes(rx), formatBytes(tx))
+func (t *Terminal) UpdateTCPHealth(degraded []ebpf.ConnectionHealth) {
+	t.degraded = degraded
+}
+
+// UpdateServices sets the listed services and the number of all listening
+// and exposed sockets.
+func (t *Terminal) UpdateServices(services []Service, listening, exposed int) {
+	t.services = services
+	t.listening = listening
+	t.exposed = exposed
 }
 
 type QueueStats struct {
@@ -95,83 +455,531 @@ type QueueStats struct {
 	Errors     uint64
 }
 
-func (t *Terminal) UpdateQueueStats(inQueue, outQueue *nfq.Queue) {
-	inStats := inQueue.GetVerdictStats()
-	outStats := outQueue.GetVerdictStats()
-
-	t.queueStats = fmt.Sprintf(
-		"IN Queue (#%d):\n"+
-			"  Total: %d packets\n"+
-			"  Accept: %d (Permanent: %d)\n"+
-			"  Block: %d (Permanent: %d)\n"+
-			"  Drop: %d (Permanent: %d)\n"+
-			"  Errors: %d\n\n"+
-			"OUT Queue (#%d):\n"+
-			"  Total: %d packets\n"+
-			"  Accept: %d (Permanent: %d)\n"+
-			"  Block: %d (Permanent: %d)\n"+
-			"  Drop: %d (Permanent: %d)\n"+
-			"  Errors: %d",
-		inQueue.ID(), inStats.Total,
-		inStats.Accept, inStats.AcceptPerm,
-		inStats.Block, inStats.BlockPerm,
-		inStats.Drop, inStats.DropPerm,
-		inStats.Errors,
-		outQueue.ID(), outStats.Total,
-		outStats.Accept, outStats.AcceptPerm,
-		outStats.Block, outStats.BlockPerm,
-		outStats.Drop, outStats.DropPerm,
-		outStats.Errors,
-	)
+// UpdateQueueStats sets the verdict counters of the packet queues.
+func (t *Terminal) UpdateQueueStats(queues []QueueSnapshot) {
+	t.queues = queues
 }
 
+// Display draws the active pane. How is up to the renderer, the ANSI one
+// only writes lines that changed since the last call.
 func (t *Terminal) Display() {
-	// Clear screen
-	fmt.Print("\033[H\033[2J")
+	if err := t.renderer.Render(t.frame()); err != nil {
+		log.Printf("Failed to draw: %v", err)
+	}
+}
 
-	width := 80 // Assumed terminal width
+// frame lays out the active pane and everything around it.
+func (t *Terminal) frame() *Frame {
+	now := t.now()
+	f := &Frame{Time: now, Pane: paneTitles[t.active], Width: t.width, Height: t.height}
 
-	// Draw title box
+	// Title and tabs
 	title := " Network Monitor "
-	padding := (width - len(title)) / 2
-	fmt.Printf("\n%s%s%s%s%s%s\n",
-		colorCyan,
-		topLeft+strings.Repeat(horizontal, padding-1),
-		bold+title+colorReset+colorCyan,
-		strings.Repeat(horizontal, padding-1),
-		topRight,
-		colorReset)
+	padding := max(t.width-len(title)-2, 0)
+	border := Style{Color: ColorCyan, Border: true}
+	f.Sections = append(f.Sections, Section{Name: "title", Lines: []Line{{
+		{topLeft + strings.Repeat(horizontal, padding/2), border},
+		{title, Style{Color: ColorCyan, Bold: true}},
+		{strings.Repeat(horizontal, padding-padding/2) + topRight, border},
+	}}})
 
-	// Bandwidth section
-	fmt.Printf("\n%s%s Bandwidth Monitor %s\n", bold, colorYellow, colorReset)
-	fmt.Printf("   %s%s%s\n\n", colorCyan, t.bandwidth, colorReset)
+	var tabs Line
+	for i, name := range paneTitles {
+		style := gray
+		if pane(i) == t.active {
+			style = Style{Bold: true, Reverse: true}
+		}
+		tabs = append(tabs, Span{Text: " "}, Span{fmt.Sprintf(" %d %s ", i+1, name), style})
+	}
+	f.Sections = append(f.Sections,
+		Section{Name: "tabs", Lines: []Line{tabs}},
+		Section{Name: "bandwidth", Lines: []Line{t.bandwidthLine(now), nil}})
 
-	// Add queue stats section after bandwidth
-	fmt.Printf("\n%s%s Queue Statistics %s\n", bold, colorYellow, colorReset)
-	fmt.Printf("%s%s%s\n\n", colorCyan, t.queueStats, colorReset)
+	// The pane gets everything between the header and the two footer lines
+	height := t.height - 2
+	for _, s := range f.Sections {
+		height -= len(s.Lines)
+	}
+	body := t.body(height)
+	for _, s := range body {
+		height -= len(s.Lines)
+	}
+	last := &body[len(body)-1]
+	for ; height > 0; height-- {
+		last.Lines = append(last.Lines, nil)
+	}
+	f.Sections = append(f.Sections, body...)
 
-	// Activity section
-	fmt.Printf("%s%s Recent Activity %s\n", bold, colorYellow, colorReset)
-	fmt.Printf("%s%s%s\n", colorCyan, strings.Repeat(horizontal, width-2), colorReset)
+	// Filter box and key help
+	var status Line
+	switch {
+	case t.confirm != nil:
+		status = Line{
+			{Text: " "},
+			{fmt.Sprintf("Kill %s?", t.confirm.Flow), Style{Bold: true, Color: ColorRed}},
+			{Text: " y kill  d kill and block destination  p kill and block process  Esc cancel"},
+		}
+	case t.notice != "":
+		status = Line{{Text: " "}, {t.notice, yellow}}
+	case t.filtering:
+		status = Line{{Text: " "}, {"Filter:", strong}, {Text: " " + t.filter + "█"}}
+	case t.filter != "":
+		status = Line{{Text: " "}, {"Filter:", strong}, {Text: " " + t.filter + " "}, {"(Esc clears)", gray}}
+	}
+	help := " Tab/1-5 pane  ↑↓ select  s sort  r reverse  / filter  w window  x kill  q quit"
+	if t.active == paneActivity {
+		help = " Tab/1-5 pane  ↑↓ scroll  p pause  / search (ip: port: proc: verdict:)  q quit"
+	}
+	f.Sections = append(f.Sections,
+		Section{Name: "status", Lines: []Line{status}},
+		Section{Name: "help", Lines: []Line{{{help, faint}}}})
+	return f
+}
+
+// body returns the sections of the active pane, at most height lines. Panes
+// with a table have it in a section of its own, with the lines above and
+// below it around.
+func (t *Terminal) body(height int) []Section {
+	extra := t.details(t.active)
+	tbl := t.sortedTable(t.active)
+	state := &t.panes[t.active]
+	if len(tbl.columns) == 0 {
+		// Without a table the selection scrolls the text
+		lines := filterLines(extra, t.filter)
+		state.selected = max(min(state.selected, len(lines)-height), 0)
+		return []Section{{Name: "pane", Lines: lines[state.selected:min(state.selected+height, len(lines))]}}
+	}
+
+	// Keep room for a few rows, details are cut first
+	if room := max(height-6, 0); len(extra) > room {
+		extra = extra[:room]
+	}
+	var sections []Section
+	head := t.headline(t.active)
+	if len(head) > 0 {
+		sections = append(sections, Section{Name: "headline", Lines: head})
+	}
+	rows := tbl.render(state, t.width, height-len(head)-len(extra))
+	sections = append(sections, Section{Name: "table", Lines: rows, Table: tbl.data(state)})
+	if len(extra) > 0 {
+		sections = append(sections, Section{Name: "details", Lines: extra})
+	}
+	return sections
+}
+
+// bandwidthLine sums up the traffic of all sockets.
+func (t *Terminal) bandwidthLine(now time.Time) Line {
+	if !t.rates.read {
+		return Line{{Text: " "}, {"No data", cyan}}
+	}
+	rx, tx := t.rates.current(now)
+	return Line{
+		{Text: " "},
+		{fmt.Sprintf("RX: %s  TX: %s", formatRate(rx), formatRate(tx)), cyan},
+		{Text: "  "},
+		{fmt.Sprintf("(total RX: %s  TX: %s)", formatBytes(t.rx), formatBytes(t.tx)), gray},
+	}
+}
+
+// headline returns the lines shown above the table of a pane.
+func (t *Terminal) headline(p pane) []Line {
+	if p == paneActivity {
+		return []Line{t.activityStatus()}
+	}
+	if p != paneBandwidth {
+		return nil
+	}
+
+	now := t.now()
+	window := rateWindows[t.window]
+	width := max(t.width-48, 10)
+	rx, tx := t.rates.current(now)
+	peakRX, peakTX := t.rates.peak(now, window)
+	lines := []Line{{
+		{" Rates ", heading},
+		{Text: " "},
+		{fmt.Sprintf("(last %s, w to change)", formatWindow(window)), gray},
+	}}
+	for _, r := range []struct {
+		name      string
+		pick      func(rateSample) float64
+		now, peak float64
+		style     Style
+	}{
+		{"RX", rxRate, rx, peakRX, blue},
+		{"TX", txRate, tx, peakTX, green},
+	} {
+		lines = append(lines, Line{
+			{Text: " " + r.name + " "},
+			{sparkline(t.rates.series(now, window, width, r.pick)), r.style},
+			{Text: fmt.Sprintf(" %12s  peak %12s", formatRate(r.now), formatRate(r.peak))},
+		})
+	}
+	lines = append(lines, nil, Line{{" Degraded Connections ", heading}})
+	return lines
+}
+
+// sortedTable returns the rows of a pane as shown, filtered and sorted.
+func (t *Terminal) sortedTable(p pane) *table {
+	tbl := t.table(p)
+	tbl.filter(t.filter)
+	tbl.sortBy(t.panes[p].sortColumn, t.panes[p].descending)
+	return tbl
+}
+
+// table returns the rows of a pane, an empty table for panes without one.
+func (t *Terminal) table(p pane) *table {
+	switch p {
+	case paneConnections:
+		return t.connectionTable()
+	case paneProcesses:
+		return t.processTable()
+	case paneBandwidth:
+		return t.healthTable()
+	case paneActivity:
+		return t.activityTable()
+	}
+	return &table{}
+}
+
+// details returns the lines shown below the table of a pane, or instead of
+// it.
+func (t *Terminal) details(p pane) []Line {
+	switch p {
+	case paneConnections:
+		return t.serviceLines()
+	case paneProcesses:
+		return t.processDNSLines()
+	case paneQueues:
+		return t.queueLines()
+	case paneActivity:
+		return t.dnsLines()
+	}
+	return nil
+}
+
+func (t *Terminal) connectionTable() *table {
+	tbl := &table{columns: []column{
+		{title: "Proto", width: 6},
+		{title: "Dir", width: 3, priority: 1},
+		{title: "Local", width: 21, priority: 6},
+		{title: "Remote", width: 21, flex: true},
+		{title: "Scope", width: 14, priority: 5},
+		{title: "Process", width: 18},
+		{title: "Rate", width: 11, numeric: true, priority: 2},
+		{title: "Traffic", width: 9, numeric: true, priority: 3},
+		{title: t.historyTitle(), width: historyWidth, numeric: true, priority: 4},
+		{title: "Age", width: 7, numeric: true},
+	}}
+	now := t.now()
+	window := rateWindows[t.window]
+	for _, conn := range t.connections {
+		age := now.Sub(conn.FirstSeen)
+		process := conn.Process
+		if process == "" && conn.PID != 0 {
+			process = fmt.Sprintf("[%d]", conn.PID)
+		}
+		color := ColorDefault
+		if conn.Direction == flow.Inbound {
+			color = ColorBlue
+		}
+		tbl.rows = append(tbl.rows, row{color: color, ref: conn, cells: []cell{
+			{text: conn.Protocol.String()},
+			{text: directionArrow(conn.Direction)},
+			{text: endpoint(conn.Local, conn.Protocol)},
+			{text: endpoint(conn.Remote, conn.Protocol)},
+			{text: conn.Scope.String()},
+			{text: process},
+			rateCell(&conn.rates, now),
+			{text: formatBytes(conn.RX + conn.TX), value: float64(conn.RX + conn.TX)},
+			historyCell(&conn.rates, now, window),
+			{text: age.Round(time.Second).String(), value: age.Seconds()},
+		}})
+	}
+	return tbl
+}
+
+func (t *Terminal) processTable() *table {
+	tbl := &table{columns: []column{
+		{title: "Process", width: 20, flex: true},
+		{title: "PID", width: 7, numeric: true, priority: 4},
+		{title: "Rate", width: 11, numeric: true},
+		{title: "Peak", width: 11, numeric: true, priority: 6},
+		{title: t.historyTitle(), width: historyWidth, numeric: true},
+		{title: "RX", width: 9, numeric: true, priority: 1},
+		{title: "TX", width: 9, numeric: true, priority: 2},
+		{title: "RTT", width: 7, numeric: true, priority: 5},
+		{title: "Retrans/s", width: 9, numeric: true, priority: 3},
+	}}
+	now := t.now()
+	window := rateWindows[t.window]
+	for _, proc := range t.processes {
+		rates := t.processRates[proc.PID]
+		if rates == nil {
+			rates = new(rateHistory)
+		}
+		peakRX, peakTX := rates.peak(now, window)
+		tbl.rows = append(tbl.rows, row{ref: proc.PID, cells: []cell{
+			{text: proc.Name},
+			{text: strconv.Itoa(int(proc.PID)), value: float64(proc.PID)},
+			rateCell(rates, now),
+			{text: formatRate(peakRX + peakTX), value: peakRX + peakTX},
+			historyCell(rates, now, window),
+			{text: formatBytes(proc.RX), value: float64(proc.RX)},
+			{text: formatBytes(proc.TX), value: float64(proc.TX)},
+			{text: proc.SRTT.Round(time.Millisecond).String(), value: float64(proc.SRTT)},
+			{text: fmt.Sprintf("%.1f", proc.RetransmitRate), value: proc.RetransmitRate},
+		}})
+	}
+	return tbl
+}
+
+// Width of the sparklines in tables.
+const historyWidth = 16
+
+func (t *Terminal) historyTitle() string {
+	return "History " + formatWindow(rateWindows[t.window])
+}
+
+// rateCell shows the current rate, RX and TX together.
+func rateCell(rates *rateHistory, now time.Time) cell {
+	rx, tx := rates.current(now)
+	return cell{text: formatRate(rx + tx), value: rx + tx}
+}
+
+// historyCell draws the rates within the window, sorted by their mean.
+func historyCell(rates *rateHistory, now time.Time, window time.Duration) cell {
+	series := rates.series(now, window, historyWidth, totalRate)
+	sum := 0.0
+	for _, v := range series {
+		sum += v
+	}
+	return cell{text: sparkline(series), value: sum / float64(len(series))}
+}
+
+// healthTable lists the degraded TCP connections.
+func (t *Terminal) healthTable() *table {
+	tbl := &table{columns: []column{
+		{title: "Local", width: 7, priority: 2},
+		{title: "Remote", width: 22, flex: true},
+		{title: "RTT", width: 8, numeric: true},
+		{title: "Retrans/s", width: 10, numeric: true},
+		{title: "Total", width: 7, numeric: true, priority: 3},
+		{title: "Resets", width: 7, numeric: true, priority: 1},
+		{title: "State", width: 11, priority: 4},
+	}}
+	for _, conn := range t.degraded {
+		tbl.rows = append(tbl.rows, row{color: ColorRed, cells: []cell{
+			{text: fmt.Sprintf("*:%d", conn.Flow.Local.Port())},
+			{text: conn.Flow.Remote.String()},
+			{text: conn.SRTT.Round(time.Millisecond).String(), value: float64(conn.SRTT)},
+			{text: fmt.Sprintf("%.1f", conn.RetransmitRate), value: conn.RetransmitRate},
+			{text: strconv.FormatUint(conn.Retransmits, 10), value: float64(conn.Retransmits)},
+			{text: strconv.FormatUint(conn.Resets, 10), value: float64(conn.Resets)},
+			{text: conn.State.String()},
+		}})
+	}
+	return tbl
+}
 
-	for _, act := range t.activities {
-		timestamp := act.Timestamp.Format("15:04:05")
-		color := colorGreen
-		if act.Direction == "IN" {
-			color = colorBlue
+func (t *Terminal) activityTable() *table {
+	tbl := &table{columns: []column{
+		{title: "Time", width: 8, numeric: true, priority: 1},
+		{title: "Dir", width: 5},
+		{title: "Message", width: 62, flex: true},
+	}}
+	tbl.match = func(ref any, query string) bool {
+		act, _ := ref.(*Activity)
+		return act != nil && matchActivity(act, query)
+	}
+	t.activities.newest(func(act *Activity) bool {
+		if t.paused != 0 && act.seq > t.paused {
+			return true
+		}
+		color := ColorGreen
+		switch act.Direction {
+		case "IN":
+			color = ColorBlue
+		case "BLOCK", "KILL":
+			color = ColorRed
+		case "ICMP":
+			color = ColorYellow
+		}
+		tbl.rows = append(tbl.rows, row{color: color, ref: act, cells: []cell{
+			{text: act.Timestamp.Format("15:04:05"), value: float64(act.Timestamp.UnixNano())},
+			{text: act.Direction},
+			{text: act.Message},
+		}})
+		return true
+	})
+	return tbl
+}
+
+// activityStatus tells whether the activity pane is live or paused and how
+// much of the history is kept.
+func (t *Terminal) activityStatus() Line {
+	kept := Span{fmt.Sprintf("%d of the last %d kept", t.activities.len(), activityHistory), gray}
+	if t.paused == 0 {
+		return Line{
+			{Text: " "}, {"LIVE", Style{Bold: true, Color: ColorGreen}},
+			{Text: "  "}, kept,
+			{Text: "  "}, {"(p to pause)", gray},
+		}
+	}
+
+	// Once the history is full new activities overwrite the paused ones
+	shown := uint64(0)
+	if oldest := t.activities.total - uint64(t.activities.len()); t.paused > oldest {
+		shown = t.paused - oldest
+	}
+	return Line{
+		{Text: " "}, {"PAUSED", Style{Bold: true, Color: ColorYellow}},
+		{Text: fmt.Sprintf("  %d shown, %d new", shown, t.activities.total-t.paused)},
+		{Text: "  "}, kept,
+		{Text: "  "}, {"(p to resume)", gray},
+	}
+}
+
+// serviceLines lists the listening sockets, anything reachable from other
+// hosts is flagged.
+func (t *Terminal) serviceLines() []Line {
+	lines := []Line{nil, {
+		{" Exposed Services ", heading},
+		{Text: " "},
+		{fmt.Sprintf("(%d listening, %d exposed)", t.listening, t.exposed), gray},
+	}}
+	for _, svc := range t.services {
+		style, flag := gray, "local"
+		if svc.Exposed {
+			style, flag = red, "EXPOSED"
+		}
+		lines = append(lines, Line{
+			{Text: "   "},
+			{fmt.Sprintf("%-7s", flag), style},
+			{Text: fmt.Sprintf(" %s %-30s %s", svc.Protocol,
+				net.JoinHostPort(svc.Addr.String(), strconv.Itoa(int(svc.Port))), svc.Owner)},
+		})
+	}
+	return lines
+}
+
+// queueLines shows the packet queues and the eBPF pipeline, the monitor is
+// blind to whatever is lost there.
+func (t *Terminal) queueLines() []Line {
+	lines := []Line{{{" Queue Statistics ", heading}}}
+	for i, q := range t.queues {
+		name := "OUT"
+		if q.Inbound {
+			name = "IN"
+		}
+		if i > 0 {
+			lines = append(lines, nil)
+		}
+		for _, line := range []string{
+			fmt.Sprintf("%s Queue (#%d):", name, q.ID),
+			fmt.Sprintf("  Total: %d packets", q.Total),
+			fmt.Sprintf("  Accept: %d (Permanent: %d)", q.Accept, q.AcceptPerm),
+			fmt.Sprintf("  Block: %d (Permanent: %d)", q.Block, q.BlockPerm),
+			fmt.Sprintf("  Drop: %d (Permanent: %d)", q.Drop, q.DropPerm),
+			fmt.Sprintf("  Errors: %d", q.Errors),
+		} {
+			lines = append(lines, Line{{line, cyan}})
+		}
+	}
+
+	lines = append(lines, nil, Line{{" eBPF Events ", heading}})
+	for _, stats := range t.eventStats {
+		style := cyan
+		if stats.Lost() > 0 {
+			style = red
+		}
+		lines = append(lines, Line{{Text: "   "}, {fmt.Sprintf("%-12s received: %d  lost: %d (ring full: %d, read: %d, decode: %d)",
+			stats.Name, stats.Received, stats.Lost(),
+			stats.ReserveFailed, stats.ReadErrors, stats.DecodeErrors), style}})
+	}
+	for _, stats := range t.mapStats {
+		style := cyan
+		if stats.Evicted > 0 {
+			style = red
 		}
+		lines = append(lines, Line{{Text: "   "}, {fmt.Sprintf("%-18s entries: %d/%d  evicted: %d",
+			stats.Name, stats.Entries, stats.Capacity, stats.Evicted), style}})
+	}
+	for _, missing := range t.missing {
+		lines = append(lines, Line{{Text: "   "}, {"degraded: " + missing, yellow}})
+	}
 
-		fmt.Printf(" %s%s%s %s%s%s %s%s%s\n",
-			colorGray, timestamp, colorReset,
-			bold, act.Direction, colorReset,
-			color, act.Message, colorReset)
+	// Anything not attached means missing data
+	lines = append(lines, nil, Line{{" eBPF Programs ", heading}})
+	for _, status := range t.programs {
+		style := green
+		switch status.State {
+		case ebpf.StateDisabled:
+			style = gray
+		case ebpf.StateDegraded, ebpf.StateStarting, ebpf.StateLoaded:
+			style = yellow
+		case ebpf.StateFailed:
+			style = red
+		}
+		lines = append(lines, Line{
+			{Text: fmt.Sprintf("   %-12s ", status.Name)},
+			{fmt.Sprintf("%-9s", status.State), style},
+			{Text: " "},
+			{"since " + status.Since.Format("15:04:05"), gray},
+		})
+		if status.Err != nil {
+			lines = append(lines, Line{{Text: "      "}, {fmt.Sprintf("%v (%d failed starts)", status.Err, status.Failures), red}})
+		}
+		for _, degraded := range status.Degraded {
+			lines = append(lines, Line{{Text: "      "}, {degraded, yellow}})
+		}
 	}
+	return lines
+}
 
-	// Footer
-	fmt.Printf("\n%s%s%s\n",
-		dim,
-		"Press Ctrl+C to exit",
-		colorReset)
+func (t *Terminal) dnsLines() []Line {
+	lines := []Line{nil, {{" Recent DNS ", heading}}}
+	for _, msg := range t.dnsMessages {
+		line := Line{
+			{Text: " "}, {msg.Time.Format("15:04:05"), gray},
+			{Text: " "}, {fmt.Sprintf("%s[%d]", msg.Comm, msg.PID), strong},
+			{Text: " "},
+		}
+		lines = append(lines, append(line, formatDNSMessage(msg)...))
+	}
+	return lines
+}
+
+// processDNSLines lists the DNS messages of the process selected in the
+// process pane.
+func (t *Terminal) processDNSLines() []Line {
+	selected := t.selectedRow(paneProcesses)
+	pid, ok := selected.ref.(uint32)
+	if !ok {
+		return nil
+	}
+	lines := []Line{nil, {{fmt.Sprintf(" DNS of %s[%d] ", selected.cells[0].text, pid), heading}}}
+	messages := t.processDNS[pid]
+	if len(messages) == 0 {
+		return append(lines, Line{{Text: " "}, {"none seen", gray}})
+	}
+	for _, msg := range messages {
+		line := Line{{Text: " "}, {msg.Time.Format("15:04:05"), gray}, {Text: " "}}
+		lines = append(lines, append(line, formatDNSMessage(msg)...))
+	}
+	return lines
+}
+
+// filterLines keeps the lines containing query, ignoring case.
+func filterLines(lines []Line, query string) []Line {
+	query = strings.ToLower(query)
+	var kept []Line
+	for _, line := range lines {
+		if query == "" || strings.Contains(strings.ToLower(line.String()), query) {
+			kept = append(kept, line)
+		}
+	}
+	return kept
 }
 
 func FormatPacketInfo(pkt nfq.Packet, isInbound bool) string {
@@ -189,6 +997,22 @@ func FormatPacketInfo(pkt nfq.Packet, isInbound bool) string {
 		directionArrow)
 }
 
+func formatDNSMessage(msg *dns.Message) Line {
+	if !msg.Response {
+		return Line{
+			{fmt.Sprintf("%s %s?", msg.Type, msg.Name), green},
+			{Text: " "},
+			{fmt.Sprintf("(via %s)", msg.Server), gray},
+		}
+	}
+
+	question := Span{fmt.Sprintf("%s %s -> ", msg.Type, msg.Name), blue}
+	if msg.RCode != "Success" {
+		return Line{question, {msg.RCode, red}}
+	}
+	return Line{question, {strings.Join(msg.Answers, ", "), blue}}
+}
+
 // Helper function to format bytes
 func formatBytes(bytes uint64) string {
 	const unit = 1024
@@ -0,0 +1,106 @@
+package display
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// tty puts the controlling terminal into raw mode and reads keys from it.
+// Signals stay enabled so Ctrl+C still interrupts the program.
+type tty struct {
+	in    *os.File
+	saved *unix.Termios
+	keys  chan key
+	stop  chan struct{}
+}
+
+// openTTY switches in to raw mode and starts reading keys. A nil tty is
+// returned if in is not a terminal, the monitor then only draws.
+func openTTY(in *os.File) (*tty, error) {
+	fd := int(in.Fd())
+	saved, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, nil
+	}
+
+	raw := *saved
+	raw.Iflag &^= unix.ICRNL | unix.IXON
+	raw.Lflag &^= unix.ICANON | unix.ECHO | unix.IEXTEN
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return nil, fmt.Errorf("failed to enter raw mode: %w", err)
+	}
+
+	t := &tty{
+		in:    in,
+		saved: saved,
+		keys:  make(chan key, 16),
+		stop:  make(chan struct{}),
+	}
+	go t.readKeys()
+	return t, nil
+}
+
+func (t *tty) readKeys() {
+	buf := make([]byte, 64)
+	for {
+		n, err := t.in.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, k := range parseKeys(buf[:n]) {
+			select {
+			case t.keys <- k:
+			case <-t.stop:
+				return
+			}
+		}
+	}
+}
+
+// restore leaves raw mode. The key reader stays blocked in read until the
+// process exits.
+func (t *tty) restore() error {
+	close(t.stop)
+	return unix.IoctlSetTermios(int(t.in.Fd()), unix.TCSETS, t.saved)
+}
+
+// screen draws frames of lines, writing only the lines that changed since
+// the previous frame so redraws don't flicker on slow links.
+type screen struct {
+	out  io.Writer
+	prev []string
+	buf  bytes.Buffer
+}
+
+// draw replaces the screen content with lines.
+func (s *screen) draw(lines []string) error {
+	s.buf.Reset()
+	if s.prev == nil {
+		s.buf.WriteString("\033[H\033[2J")
+	}
+	for i, line := range lines {
+		if i < len(s.prev) && s.prev[i] == line {
+			continue
+		}
+		fmt.Fprintf(&s.buf, "\033[%d;1H%s%s\033[K", i+1, line, ansiReset)
+	}
+	for i := len(lines); i < len(s.prev); i++ {
+		fmt.Fprintf(&s.buf, "\033[%d;1H\033[K", i+1)
+	}
+	s.prev = append(s.prev[:0], lines...)
+
+	_, err := s.out.Write(s.buf.Bytes())
+	return err
+}
+
+// invalidate makes the next draw repaint the whole screen, for when
+// something else wrote to the terminal.
+func (s *screen) invalidate() {
+	s.prev = nil
+}
@@ -1,5 +1,5 @@
 // Code generated by bpf2go; DO NOT EDIT.
-//go:build 386 || amd64 || amd64p32 || arm || arm64 || loong64 || mips64le || mips64p32le || mipsle || ppc64le || riscv64
+//go:build (386 || amd64 || amd64p32 || arm || arm64 || loong64 || mips64le || mips64p32le || mipsle || ppc64le || riscv64) && !386 && !amd64 && !arm64
 
 package bandwidth
 
@@ -12,6 +12,17 @@ import (
 	"github.com/cilium/ebpf"
 )
 
+type bpfListenEvent struct {
+	Addr      [4]uint32
+	Pid       uint32
+	Comm      [16]uint8
+	Port      uint16
+	IpVersion uint8
+	Protocol  uint8
+	Type      uint8
+	Pad       [3]uint8
+}
+
 type bpfSkInfo struct {
 	Rx       uint64
 	Tx       uint64
@@ -28,6 +39,19 @@ type bpfSkKey struct {
 	_        [2]byte
 }
 
+type bpfTcpHealth struct {
+	SrttUs       uint32
+	TotalRetrans uint32
+	Retransmits  uint32
+	Resets       uint32
+	State        uint32
+}
+
+type bpfUdpRecvArgs struct {
+	Sk  uint64
+	Msg uint64
+}
+
 // loadBpf returns the embedded CollectionSpec for bpf.
 func loadBpf() (*ebpf.CollectionSpec, error) {
 	reader := bytes.NewReader(_BpfBytes)
@@ -69,18 +93,32 @@ type bpfSpecs struct {
 //
 // It can be passed ebpf.CollectionSpec.Assign.
 type bpfProgramSpecs struct {
-	SocketOperations *ebpf.ProgramSpec `ebpf:"socket_operations"`
-	UdpRecvmsg       *ebpf.ProgramSpec `ebpf:"udp_recvmsg"`
-	UdpSendmsg       *ebpf.ProgramSpec `ebpf:"udp_sendmsg"`
-	Udpv6Recvmsg     *ebpf.ProgramSpec `ebpf:"udpv6_recvmsg"`
-	Udpv6Sendmsg     *ebpf.ProgramSpec `ebpf:"udpv6_sendmsg"`
+	Inet6Bind           *ebpf.ProgramSpec `ebpf:"inet6_bind"`
+	InetBind            *ebpf.ProgramSpec `ebpf:"inet_bind"`
+	SocketOperations    *ebpf.ProgramSpec `ebpf:"socket_operations"`
+	TcpRetransmitSkb    *ebpf.ProgramSpec `ebpf:"tcp_retransmit_skb"`
+	TcpSendReset        *ebpf.ProgramSpec `ebpf:"tcp_send_reset"`
+	UdpLibUnhash        *ebpf.ProgramSpec `ebpf:"udp_lib_unhash"`
+	UdpRecvmsg          *ebpf.ProgramSpec `ebpf:"udp_recvmsg"`
+	UdpRecvmsgNoblock   *ebpf.ProgramSpec `ebpf:"udp_recvmsg_noblock"`
+	UdpSendmsg          *ebpf.ProgramSpec `ebpf:"udp_sendmsg"`
+	Udpv6Recvmsg        *ebpf.ProgramSpec `ebpf:"udpv6_recvmsg"`
+	Udpv6RecvmsgNoblock *ebpf.ProgramSpec `ebpf:"udpv6_recvmsg_noblock"`
+	Udpv6Sendmsg        *ebpf.ProgramSpec `ebpf:"udpv6_sendmsg"`
 }
 
 // bpfMapSpecs contains maps before they are loaded into the kernel.
 //
 // It can be passed ebpf.CollectionSpec.Assign.
 type bpfMapSpecs struct {
-	OmBandwidthMap *ebpf.MapSpec `ebpf:"om_bandwidth_map"`
+	OmBandwidthMap     *ebpf.MapSpec `ebpf:"om_bandwidth_map"`
+	OmBindArgs         *ebpf.MapSpec `ebpf:"om_bind_args"`
+	OmListenDrops      *ebpf.MapSpec `ebpf:"om_listen_drops"`
+	OmListenEvents     *ebpf.MapSpec `ebpf:"om_listen_events"`
+	OmListenEventsPerf *ebpf.MapSpec `ebpf:"om_listen_events_perf"`
+	OmListenScratch    *ebpf.MapSpec `ebpf:"om_listen_scratch"`
+	OmTcpHealthMap     *ebpf.MapSpec `ebpf:"om_tcp_health_map"`
+	OmUdpRecvArgs      *ebpf.MapSpec `ebpf:"om_udp_recv_args"`
 }
 
 // bpfObjects contains all objects after they have been loaded into the kernel.
@@ -102,12 +140,26 @@ func (o *bpfObjects) Close() error {
 //
 // It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
 type bpfMaps struct {
-	OmBandwidthMap *ebpf.Map `ebpf:"om_bandwidth_map"`
+	OmBandwidthMap     *ebpf.Map `ebpf:"om_bandwidth_map"`
+	OmBindArgs         *ebpf.Map `ebpf:"om_bind_args"`
+	OmListenDrops      *ebpf.Map `ebpf:"om_listen_drops"`
+	OmListenEvents     *ebpf.Map `ebpf:"om_listen_events"`
+	OmListenEventsPerf *ebpf.Map `ebpf:"om_listen_events_perf"`
+	OmListenScratch    *ebpf.Map `ebpf:"om_listen_scratch"`
+	OmTcpHealthMap     *ebpf.Map `ebpf:"om_tcp_health_map"`
+	OmUdpRecvArgs      *ebpf.Map `ebpf:"om_udp_recv_args"`
 }
 
 func (m *bpfMaps) Close() error {
 	return _BpfClose(
 		m.OmBandwidthMap,
+		m.OmBindArgs,
+		m.OmListenDrops,
+		m.OmListenEvents,
+		m.OmListenEventsPerf,
+		m.OmListenScratch,
+		m.OmTcpHealthMap,
+		m.OmUdpRecvArgs,
 	)
 }
 
@@ -115,19 +167,33 @@ func (m *bpfMaps) Close() error {
 //
 // It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
 type bpfPrograms struct {
-	SocketOperations *ebpf.Program `ebpf:"socket_operations"`
-	UdpRecvmsg       *ebpf.Program `ebpf:"udp_recvmsg"`
-	UdpSendmsg       *ebpf.Program `ebpf:"udp_sendmsg"`
-	Udpv6Recvmsg     *ebpf.Program `ebpf:"udpv6_recvmsg"`
-	Udpv6Sendmsg     *ebpf.Program `ebpf:"udpv6_sendmsg"`
+	Inet6Bind           *ebpf.Program `ebpf:"inet6_bind"`
+	InetBind            *ebpf.Program `ebpf:"inet_bind"`
+	SocketOperations    *ebpf.Program `ebpf:"socket_operations"`
+	TcpRetransmitSkb    *ebpf.Program `ebpf:"tcp_retransmit_skb"`
+	TcpSendReset        *ebpf.Program `ebpf:"tcp_send_reset"`
+	UdpLibUnhash        *ebpf.Program `ebpf:"udp_lib_unhash"`
+	UdpRecvmsg          *ebpf.Program `ebpf:"udp_recvmsg"`
+	UdpRecvmsgNoblock   *ebpf.Program `ebpf:"udp_recvmsg_noblock"`
+	UdpSendmsg          *ebpf.Program `ebpf:"udp_sendmsg"`
+	Udpv6Recvmsg        *ebpf.Program `ebpf:"udpv6_recvmsg"`
+	Udpv6RecvmsgNoblock *ebpf.Program `ebpf:"udpv6_recvmsg_noblock"`
+	Udpv6Sendmsg        *ebpf.Program `ebpf:"udpv6_sendmsg"`
 }
 
 func (p *bpfPrograms) Close() error {
 	return _BpfClose(
+		p.Inet6Bind,
+		p.InetBind,
 		p.SocketOperations,
+		p.TcpRetransmitSkb,
+		p.TcpSendReset,
+		p.UdpLibUnhash,
 		p.UdpRecvmsg,
+		p.UdpRecvmsgNoblock,
 		p.UdpSendmsg,
 		p.Udpv6Recvmsg,
+		p.Udpv6RecvmsgNoblock,
 		p.Udpv6Sendmsg,
 	)
 }
@@ -0,0 +1,246 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build 386 || amd64
+
+package bandwidth
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type bpfListenEvent struct {
+	Addr      [4]uint32
+	Pid       uint32
+	Comm      [16]uint8
+	Port      uint16
+	IpVersion uint8
+	Protocol  uint8
+	Type      uint8
+	Pad       [3]uint8
+}
+
+type bpfSkInfo struct {
+	Rx       uint64
+	Tx       uint64
+	Reported uint64
+}
+
+type bpfSkKey struct {
+	SrcIp    [4]uint32
+	DstIp    [4]uint32
+	SrcPort  uint16
+	DstPort  uint16
+	Protocol uint8
+	Ipv6     uint8
+	_        [2]byte
+}
+
+type bpfTcpHealth struct {
+	SrttUs       uint32
+	TotalRetrans uint32
+	Retransmits  uint32
+	Resets       uint32
+	State        uint32
+}
+
+type bpfUdpRecvArgs struct {
+	Sk  uint64
+	Msg uint64
+}
+
+// loadBpf returns the embedded CollectionSpec for bpf.
+func loadBpf() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_BpfBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load bpf: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadBpfObjects loads bpf and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*bpfObjects
+//	*bpfPrograms
+//	*bpfMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadBpfObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadBpf()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// bpfSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type bpfSpecs struct {
+	bpfProgramSpecs
+	bpfMapSpecs
+}
+
+// bpfSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type bpfProgramSpecs struct {
+	Inet6Bind             *ebpf.ProgramSpec `ebpf:"inet6_bind"`
+	Inet6BindKprobe       *ebpf.ProgramSpec `ebpf:"inet6_bind_kprobe"`
+	Inet6BindKretprobe    *ebpf.ProgramSpec `ebpf:"inet6_bind_kretprobe"`
+	InetBind              *ebpf.ProgramSpec `ebpf:"inet_bind"`
+	InetBindKprobe        *ebpf.ProgramSpec `ebpf:"inet_bind_kprobe"`
+	InetBindKretprobe     *ebpf.ProgramSpec `ebpf:"inet_bind_kretprobe"`
+	SocketOperations      *ebpf.ProgramSpec `ebpf:"socket_operations"`
+	TcpRetransmitSkb      *ebpf.ProgramSpec `ebpf:"tcp_retransmit_skb"`
+	TcpSendReset          *ebpf.ProgramSpec `ebpf:"tcp_send_reset"`
+	UdpLibUnhash          *ebpf.ProgramSpec `ebpf:"udp_lib_unhash"`
+	UdpLibUnhashKprobe    *ebpf.ProgramSpec `ebpf:"udp_lib_unhash_kprobe"`
+	UdpRecvmsg            *ebpf.ProgramSpec `ebpf:"udp_recvmsg"`
+	UdpRecvmsgKprobe      *ebpf.ProgramSpec `ebpf:"udp_recvmsg_kprobe"`
+	UdpRecvmsgKretprobe   *ebpf.ProgramSpec `ebpf:"udp_recvmsg_kretprobe"`
+	UdpRecvmsgNoblock     *ebpf.ProgramSpec `ebpf:"udp_recvmsg_noblock"`
+	UdpSendmsg            *ebpf.ProgramSpec `ebpf:"udp_sendmsg"`
+	UdpSendmsgKprobe      *ebpf.ProgramSpec `ebpf:"udp_sendmsg_kprobe"`
+	Udpv6Recvmsg          *ebpf.ProgramSpec `ebpf:"udpv6_recvmsg"`
+	Udpv6RecvmsgKprobe    *ebpf.ProgramSpec `ebpf:"udpv6_recvmsg_kprobe"`
+	Udpv6RecvmsgKretprobe *ebpf.ProgramSpec `ebpf:"udpv6_recvmsg_kretprobe"`
+	Udpv6RecvmsgNoblock   *ebpf.ProgramSpec `ebpf:"udpv6_recvmsg_noblock"`
+	Udpv6Sendmsg          *ebpf.ProgramSpec `ebpf:"udpv6_sendmsg"`
+	Udpv6SendmsgKprobe    *ebpf.ProgramSpec `ebpf:"udpv6_sendmsg_kprobe"`
+}
+
+// bpfMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type bpfMapSpecs struct {
+	OmBandwidthMap     *ebpf.MapSpec `ebpf:"om_bandwidth_map"`
+	OmBindArgs         *ebpf.MapSpec `ebpf:"om_bind_args"`
+	OmListenDrops      *ebpf.MapSpec `ebpf:"om_listen_drops"`
+	OmListenEvents     *ebpf.MapSpec `ebpf:"om_listen_events"`
+	OmListenEventsPerf *ebpf.MapSpec `ebpf:"om_listen_events_perf"`
+	OmListenScratch    *ebpf.MapSpec `ebpf:"om_listen_scratch"`
+	OmTcpHealthMap     *ebpf.MapSpec `ebpf:"om_tcp_health_map"`
+	OmUdpRecvArgs      *ebpf.MapSpec `ebpf:"om_udp_recv_args"`
+}
+
+// bpfObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
+type bpfObjects struct {
+	bpfPrograms
+	bpfMaps
+}
+
+func (o *bpfObjects) Close() error {
+	return _BpfClose(
+		&o.bpfPrograms,
+		&o.bpfMaps,
+	)
+}
+
+// bpfMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
+type bpfMaps struct {
+	OmBandwidthMap     *ebpf.Map `ebpf:"om_bandwidth_map"`
+	OmBindArgs         *ebpf.Map `ebpf:"om_bind_args"`
+	OmListenDrops      *ebpf.Map `ebpf:"om_listen_drops"`
+	OmListenEvents     *ebpf.Map `ebpf:"om_listen_events"`
+	OmListenEventsPerf *ebpf.Map `ebpf:"om_listen_events_perf"`
+	OmListenScratch    *ebpf.Map `ebpf:"om_listen_scratch"`
+	OmTcpHealthMap     *ebpf.Map `ebpf:"om_tcp_health_map"`
+	OmUdpRecvArgs      *ebpf.Map `ebpf:"om_udp_recv_args"`
+}
+
+func (m *bpfMaps) Close() error {
+	return _BpfClose(
+		m.OmBandwidthMap,
+		m.OmBindArgs,
+		m.OmListenDrops,
+		m.OmListenEvents,
+		m.OmListenEventsPerf,
+		m.OmListenScratch,
+		m.OmTcpHealthMap,
+		m.OmUdpRecvArgs,
+	)
+}
+
+// bpfPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
+type bpfPrograms struct {
+	Inet6Bind             *ebpf.Program `ebpf:"inet6_bind"`
+	Inet6BindKprobe       *ebpf.Program `ebpf:"inet6_bind_kprobe"`
+	Inet6BindKretprobe    *ebpf.Program `ebpf:"inet6_bind_kretprobe"`
+	InetBind              *ebpf.Program `ebpf:"inet_bind"`
+	InetBindKprobe        *ebpf.Program `ebpf:"inet_bind_kprobe"`
+	InetBindKretprobe     *ebpf.Program `ebpf:"inet_bind_kretprobe"`
+	SocketOperations      *ebpf.Program `ebpf:"socket_operations"`
+	TcpRetransmitSkb      *ebpf.Program `ebpf:"tcp_retransmit_skb"`
+	TcpSendReset          *ebpf.Program `ebpf:"tcp_send_reset"`
+	UdpLibUnhash          *ebpf.Program `ebpf:"udp_lib_unhash"`
+	UdpLibUnhashKprobe    *ebpf.Program `ebpf:"udp_lib_unhash_kprobe"`
+	UdpRecvmsg            *ebpf.Program `ebpf:"udp_recvmsg"`
+	UdpRecvmsgKprobe      *ebpf.Program `ebpf:"udp_recvmsg_kprobe"`
+	UdpRecvmsgKretprobe   *ebpf.Program `ebpf:"udp_recvmsg_kretprobe"`
+	UdpRecvmsgNoblock     *ebpf.Program `ebpf:"udp_recvmsg_noblock"`
+	UdpSendmsg            *ebpf.Program `ebpf:"udp_sendmsg"`
+	UdpSendmsgKprobe      *ebpf.Program `ebpf:"udp_sendmsg_kprobe"`
+	Udpv6Recvmsg          *ebpf.Program `ebpf:"udpv6_recvmsg"`
+	Udpv6RecvmsgKprobe    *ebpf.Program `ebpf:"udpv6_recvmsg_kprobe"`
+	Udpv6RecvmsgKretprobe *ebpf.Program `ebpf:"udpv6_recvmsg_kretprobe"`
+	Udpv6RecvmsgNoblock   *ebpf.Program `ebpf:"udpv6_recvmsg_noblock"`
+	Udpv6Sendmsg          *ebpf.Program `ebpf:"udpv6_sendmsg"`
+	Udpv6SendmsgKprobe    *ebpf.Program `ebpf:"udpv6_sendmsg_kprobe"`
+}
+
+func (p *bpfPrograms) Close() error {
+	return _BpfClose(
+		p.Inet6Bind,
+		p.Inet6BindKprobe,
+		p.Inet6BindKretprobe,
+		p.InetBind,
+		p.InetBindKprobe,
+		p.InetBindKretprobe,
+		p.SocketOperations,
+		p.TcpRetransmitSkb,
+		p.TcpSendReset,
+		p.UdpLibUnhash,
+		p.UdpLibUnhashKprobe,
+		p.UdpRecvmsg,
+		p.UdpRecvmsgKprobe,
+		p.UdpRecvmsgKretprobe,
+		p.UdpRecvmsgNoblock,
+		p.UdpSendmsg,
+		p.UdpSendmsgKprobe,
+		p.Udpv6Recvmsg,
+		p.Udpv6RecvmsgKprobe,
+		p.Udpv6RecvmsgKretprobe,
+		p.Udpv6RecvmsgNoblock,
+		p.Udpv6Sendmsg,
+		p.Udpv6SendmsgKprobe,
+	)
+}
+
+func _BpfClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed bpf_bpfel_x86.o
+var _BpfBytes []byte
@@ -0,0 +1,98 @@
+package bandwidth
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"time"
+
+	"github.com/cilium/ebpf"
+
+	ebpfapi "github.com/lonelysadness/OpenMonitor/pkg/ebpf"
+	"github.com/lonelysadness/OpenMonitor/pkg/flow"
+)
+
+// healthTracker turns the cumulative counters of om_tcp_health_map into
+// per connection rates.
+type healthTracker struct {
+	prevRetransmits map[bpfSkKey]uint64
+	prevTime        time.Time
+	keys            *ebpfapi.KeyTracker[bpfSkKey]
+	stats           *ebpfapi.MapCounters
+}
+
+func newHealthTracker(stats *ebpfapi.MapCounters) *healthTracker {
+	return &healthTracker{
+		prevRetransmits: make(map[bpfSkKey]uint64),
+		keys:            ebpfapi.NewKeyTracker[bpfSkKey](),
+		stats:           stats,
+	}
+}
+
+// collect reads the health of all connections.
+func (h *healthTracker) collect(m *ebpf.Map) []ebpfapi.ConnectionHealth {
+	now := time.Now()
+	elapsed := now.Sub(h.prevTime).Seconds()
+	retransmits := make(map[bpfSkKey]uint64)
+
+	var key bpfSkKey
+	var info bpfTcpHealth
+	var result []ebpfapi.ConnectionHealth
+
+	iter := m.Iterate()
+	for iter.Next(&key, &info) {
+		h.keys.Seen(key)
+
+		// Sockops only reports sockets it saw being set up, the tracepoint
+		// sees all of them. Take whichever counted more.
+		total := uint64(info.TotalRetrans)
+		if uint64(info.Retransmits) > total {
+			total = uint64(info.Retransmits)
+		}
+		retransmits[key] = total
+
+		health := ebpfapi.ConnectionHealth{
+			Flow:        keyFlow(key),
+			SRTT:        time.Duration(info.SrttUs>>3) * time.Microsecond,
+			Retransmits: total,
+			Resets:      uint64(info.Resets),
+			State:       ebpfapi.TCPState(info.State),
+		}
+		if prev, ok := h.prevRetransmits[key]; ok && elapsed > 0 && total >= prev {
+			health.RetransmitRate = float64(total-prev) / elapsed
+		}
+		result = append(result, health)
+	}
+
+	if iter.Err() == nil {
+		entries, evicted := h.keys.Done()
+		h.stats.Observe(uint32(entries), m.MaxEntries(), uint64(evicted))
+	} else {
+		h.keys.Abort()
+	}
+
+	h.prevRetransmits = retransmits
+	h.prevTime = now
+	return result
+}
+
+// keyFlow returns the connection of a key. Ports are in host byte order,
+// addresses in network byte order, so they are written back the way they
+// were read.
+func keyFlow(key bpfSkKey) flow.Flow {
+	return flow.New(flow.Protocol(key.Protocol), flow.Unknown,
+		netip.AddrPortFrom(keyAddr(key.SrcIp, key.Ipv6 != 0), key.SrcPort),
+		netip.AddrPortFrom(keyAddr(key.DstIp, key.Ipv6 != 0), key.DstPort))
+}
+
+func keyAddr(words [4]uint32, ipv6 bool) netip.Addr {
+	if !ipv6 {
+		var ip [4]byte
+		binary.NativeEndian.PutUint32(ip[:], words[0])
+		return netip.AddrFrom4(ip)
+	}
+	var ip [16]byte
+	for i, word := range words {
+		binary.NativeEndian.PutUint32(ip[i*4:], word)
+	}
+	return netip.AddrFrom16(ip)
+}
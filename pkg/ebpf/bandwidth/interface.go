@@ -2,20 +2,23 @@ package bandwidth
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"path/filepath"
-	"syscall"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/link"
-	"github.com/cilium/ebpf/rlimit"
-	"golang.org/x/sys/unix"
 
 	ebpfapi "github.com/lonelysadness/OpenMonitor/pkg/ebpf"
 )
 
-//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cc clang -cflags "-O2 -g -Wall -Werror" bpf ../programs/bandwidth.c
+// The kprobe fallbacks read registers, they are built for each architecture
+// with an object of its own. The others share an object without them, see
+// programs/kprobe.h.
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cc clang -cflags "-O2 -g -Wall -Werror" -target amd64,arm64 bpf ../programs/bandwidth.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cc clang -cflags "-O2 -g -Wall -Werror" -tags "!386,!amd64,!arm64" bpf ../programs/bandwidth.c
 
 // Add totalBandwidth struct to track cumulative bandwidth
 type totalBandwidth struct {
@@ -23,60 +26,149 @@ type totalBandwidth struct {
 	tx uint64
 }
 
-func BandwidthStatsWorker(ctx context.Context, interval time.Duration, updates chan *ebpfapi.BandwidthInfo) error {
-	if err := rlimit.RemoveMemlock(); err != nil {
-		return fmt.Errorf("failed to remove memlock: %w", err)
-	}
+// Monitor sends the bandwidth of all sockets every interval and the listen
+// events of the sockops program as they happen. With pinning the counters of
+// the previous run are picked up. The fill level and evictions of the
+// bandwidth and health maps go to bandwidthStats and healthStats. It is a
+// component of the eBPF manager.
+type Monitor struct {
+	interval       time.Duration
+	updates        chan *ebpfapi.BandwidthInfo
+	listens        chan *ebpfapi.ListenEvent
+	counters       *ebpfapi.RingBufferCounters
+	bandwidthStats *ebpfapi.MapCounters
+	healthStats    *ebpfapi.MapCounters
+
+	coll   *ebpfapi.Collection
+	reader *ebpfapi.EventReader
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
 
-	objs := bpfObjects{}
-	if err := loadBpfObjects(&objs, nil); err != nil {
-		return fmt.Errorf("failed to load BPF objects: %w", err)
+// New returns a monitor that sends to updates and listens.
+func New(interval time.Duration, updates chan *ebpfapi.BandwidthInfo, listens chan *ebpfapi.ListenEvent,
+	counters *ebpfapi.RingBufferCounters, bandwidthStats, healthStats *ebpfapi.MapCounters) *Monitor {
+	return &Monitor{
+		interval:       interval,
+		updates:        updates,
+		listens:        listens,
+		counters:       counters,
+		bandwidthStats: bandwidthStats,
+		healthStats:    healthStats,
 	}
-	defer objs.Close()
+}
+
+// Spec returns the compiled object of the monitor.
+func (m *Monitor) Spec() (*ebpf.CollectionSpec, error) {
+	return ebpfapi.LoadObject(loadBpf)
+}
 
-	// Find and attach to cgroup
-	cgroupPath, err := findCgroupPath()
+// Start loads and attaches the monitor.
+func (m *Monitor) Start(env *ebpfapi.Env) ([]string, error) {
+	spec, err := m.Spec()
 	if err != nil {
-		return fmt.Errorf("failed to find cgroup path: %w", err)
+		return nil, err
 	}
+	if m.coll, err = env.LoadCollection(spec); err != nil {
+		return nil, err
+	}
+	m.counters.SetDropMap(m.coll.Maps["om_listen_drops"])
 
-	sockOptsLink, err := link.AttachCgroup(link.CgroupOptions{
-		Path:    cgroupPath,
-		Attach:  ebpf.AttachCGroupSockOps,
-		Program: objs.SocketOperations,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to attach sockops: %w", err)
+	// TCP is counted by the sockops program, without it only UDP is seen.
+	var degraded []string
+	if m.coll.Programs["socket_operations"] != nil {
+		cgroupPath, err := ebpfapi.FindCgroupPath()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find cgroup path: %w", err)
+		}
+
+		err = m.coll.Attach("socket_operations", func(prog *ebpf.Program) (link.Link, error) {
+			return link.AttachCgroup(link.CgroupOptions{
+				Path:    cgroupPath,
+				Attach:  ebpf.AttachCGroupSockOps,
+				Program: prog,
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		degraded = append(degraded, "sockops unavailable, TCP bandwidth and listeners are unseen")
 	}
 
 	// Attach UDP tracers
-	links := []link.Link{}
-	programs := []*ebpf.Program{
-		objs.UdpSendmsg,
-		objs.UdpRecvmsg,
-		objs.Udpv6Sendmsg,
-		objs.Udpv6Recvmsg,
+	programs := []string{
+		"udp_sendmsg",
+		"udp_recvmsg",
+		"udpv6_sendmsg",
+		"udpv6_recvmsg",
+		"inet_bind",
+		"inet6_bind",
+		"udp_lib_unhash",
+	}
+	for _, name := range programs {
+		if err := m.coll.AttachTracing(name); err != nil {
+			return nil, fmt.Errorf("failed to attach UDP tracer: %w", err)
+		}
 	}
 
-	for _, prog := range programs {
-		l, err := link.AttachTracing(link.TracingOptions{Program: prog})
+	// Attach TCP health tracepoints
+	for _, name := range []string{"tcp_retransmit_skb", "tcp_send_reset"} {
+		err := m.coll.Attach(name, func(prog *ebpf.Program) (link.Link, error) {
+			return link.Tracepoint("tcp", name, prog, nil)
+		})
 		if err != nil {
-			return fmt.Errorf("failed to attach UDP tracer: %w", err)
+			return nil, err
 		}
-		links = append(links, l)
 	}
-	defer func() {
-		for _, l := range links {
-			l.Close()
-		}
-		sockOptsLink.Close()
+
+	if m.reader, err = ebpfapi.NewEventReader(m.coll, "om_listen_events"); err != nil {
+		return nil, err
+	}
+
+	var ctx context.Context
+	ctx, m.cancel = context.WithCancel(context.Background())
+	m.wg.Add(2)
+	go func() {
+		defer m.wg.Done()
+		readListenEvents(ctx, m.reader, m.listens, m.counters)
 	}()
+	go func() {
+		defer m.wg.Done()
+		m.run(ctx)
+	}()
+	return degraded, nil
+}
 
-	ticker := time.NewTicker(interval)
+// Stop detaches the monitor. The channels stay open.
+func (m *Monitor) Stop() error {
+	if m.cancel != nil {
+		m.cancel()
+		m.cancel = nil
+	}
+	if m.reader != nil {
+		m.reader.Close()
+		m.reader = nil
+	}
+	m.wg.Wait()
+	if m.coll != nil {
+		m.counters.SetDropMap(nil)
+		m.coll.Close()
+		m.coll = nil
+	}
+	return nil
+}
+
+// run sends bandwidth updates until the context is done.
+func (m *Monitor) run(ctx context.Context) {
+	bandwidthMap := m.coll.Maps["om_bandwidth_map"]
+	healthMap := m.coll.Maps["om_tcp_health_map"]
+
+	ticker := time.NewTicker(m.interval)
 	defer ticker.Stop()
 
-	// Add total bandwidth tracker
-	var total totalBandwidth
+	health := newHealthTracker(m.healthStats)
+	bandwidthKeys := ebpfapi.NewKeyTracker[bpfSkKey]()
 
 	for {
 		select {
@@ -84,42 +176,73 @@ func BandwidthStatsWorker(ctx context.Context, interval time.Duration, updates c
 			var key bpfSkKey
 			var info bpfSkInfo
 			currentTotal := totalBandwidth{}
+			var connections []ebpfapi.ConnectionBandwidth
 
 			// Sum up all bandwidth entries
-			iter := objs.OmBandwidthMap.Iterate()
+			iter := bandwidthMap.Iterate()
 			for iter.Next(&key, &info) {
+				bandwidthKeys.Seen(key)
 				currentTotal.rx += info.Rx
 				currentTotal.tx += info.Tx
+				connections = append(connections, ebpfapi.ConnectionBandwidth{
+					Flow: keyFlow(key),
+					RX:   info.Rx,
+					TX:   info.Tx,
+				})
+			}
+			// Nothing deletes entries, missing ones were evicted.
+			if iter.Err() == nil {
+				entries, evicted := bandwidthKeys.Done()
+				m.bandwidthStats.Observe(uint32(entries), bandwidthMap.MaxEntries(), uint64(evicted))
+			} else {
+				bandwidthKeys.Abort()
 			}
 
-			// Only send updates when bandwidth changes
-			if currentTotal.rx != total.rx || currentTotal.tx != total.tx {
-				total = currentTotal
-				updates <- &ebpfapi.BandwidthInfo{
-					RX:       total.rx,
-					TX:       total.tx,
-					Reported: 0,
-				}
+			connHealth := health.collect(healthMap)
+
+			// Sent every interval, even without traffic, so consumers get
+			// evenly spaced readings to compute rates from.
+			update := &ebpfapi.BandwidthInfo{
+				RX:          currentTotal.rx,
+				TX:          currentTotal.tx,
+				Reported:    0,
+				Connections: connections,
+				Health:      connHealth,
+			}
+			select {
+			case m.updates <- update:
+			case <-ctx.Done():
+				return
 			}
 		case <-ctx.Done():
-			return nil
+			return
 		}
 	}
 }
 
-func findCgroupPath() (string, error) {
-	cgroupPath := "/sys/fs/cgroup"
+// readListenEvents forwards listen events until the reader is closed.
+func readListenEvents(ctx context.Context, rd *ebpfapi.EventReader, listens chan *ebpfapi.ListenEvent, counters *ebpfapi.RingBufferCounters) {
+	for {
+		sample, err := rd.Read()
+		if err != nil {
+			if errors.Is(err, os.ErrClosed) {
+				return
+			}
+			counters.ReadError()
+			continue
+		}
 
-	var st syscall.Statfs_t
-	err := syscall.Statfs(cgroupPath, &st)
-	if err != nil {
-		return "", err
-	}
+		var event ebpfapi.ListenEvent
+		if err := event.UnmarshalBinary(sample); err != nil {
+			counters.DecodeError()
+			continue
+		}
+		counters.Received()
 
-	isCgroupV2 := st.Type == unix.CGROUP2_SUPER_MAGIC
-	if !isCgroupV2 {
-		cgroupPath = filepath.Join(cgroupPath, "unified")
+		select {
+		case listens <- &event:
+		case <-ctx.Done():
+			return
+		}
 	}
-
-	return cgroupPath, nil
 }
@@ -0,0 +1,69 @@
+package ebpf
+
+import "sync"
+
+// Broadcaster hands events read from a ring buffer to any number of
+// subscribers. It never blocks: events are dropped for subscribers that
+// don't keep up, so a slow consumer never stalls the ring buffer.
+type Broadcaster[T any] struct {
+	lock        sync.Mutex
+	subscribers map[chan T]struct{}
+	closed      bool
+}
+
+func NewBroadcaster[T any]() *Broadcaster[T] {
+	return &Broadcaster[T]{
+		subscribers: make(map[chan T]struct{}),
+	}
+}
+
+// Subscribe returns a channel that receives every event published after the
+// call and a function that cancels the subscription and closes the channel.
+func (b *Broadcaster[T]) Subscribe(size int) (<-chan T, func()) {
+	ch := make(chan T, size)
+
+	b.lock.Lock()
+	if b.closed {
+		close(ch)
+	} else {
+		b.subscribers[ch] = struct{}{}
+	}
+	b.lock.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.lock.Lock()
+			defer b.lock.Unlock()
+			if _, ok := b.subscribers[ch]; ok {
+				delete(b.subscribers, ch)
+				close(ch)
+			}
+		})
+	}
+}
+
+// Publish hands the event to all subscribers.
+func (b *Broadcaster[T]) Publish(event T) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Close closes the channels of all subscribers.
+func (b *Broadcaster[T]) Close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
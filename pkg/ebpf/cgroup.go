@@ -0,0 +1,27 @@
+package ebpf
+
+import (
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// FindCgroupPath returns the root of the cgroup v2 hierarchy, where cgroup
+// programs are attached to see every process.
+func FindCgroupPath() (string, error) {
+	cgroupPath := "/sys/fs/cgroup"
+
+	var st syscall.Statfs_t
+	err := syscall.Statfs(cgroupPath, &st)
+	if err != nil {
+		return "", err
+	}
+
+	isCgroupV2 := st.Type == unix.CGROUP2_SUPER_MAGIC
+	if !isCgroupV2 {
+		cgroupPath = filepath.Join(cgroupPath, "unified")
+	}
+
+	return cgroupPath, nil
+}
@@ -1,5 +1,5 @@
 // Code generated by bpf2go; DO NOT EDIT.
-//go:build arm64be || armbe || mips || mips64 || mips64p32 || ppc64 || s390 || s390x || sparc || sparc64
+//go:build (arm64be || armbe || mips || mips64 || mips64p32 || ppc64 || s390 || s390x || sparc || sparc64) && !386 && !amd64 && !arm64
 
 package connection_listener
 
@@ -12,6 +12,29 @@ import (
 	"github.com/cilium/ebpf"
 )
 
+type bpfEvent struct {
+	Saddr     [4]uint32
+	Daddr     [4]uint32
+	Sport     uint16
+	Dport     uint16
+	Pid       uint32
+	IpVersion uint8
+	Protocol  uint8
+	Direction uint8
+	IcmpType  uint8
+	IcmpCode  uint8
+	SockType  uint8
+	_         [2]byte
+}
+
+type bpfIcmpKey struct {
+	Pid      uint32
+	Daddr    [4]uint32
+	Protocol uint8
+	IcmpType uint8
+	Pad      [2]uint8
+}
+
 // loadBpf returns the embedded CollectionSpec for bpf.
 func loadBpf() (*ebpf.CollectionSpec, error) {
 	reader := bytes.NewReader(_BpfBytes)
@@ -53,16 +76,25 @@ type bpfSpecs struct {
 //
 // It can be passed ebpf.CollectionSpec.Assign.
 type bpfProgramSpecs struct {
-	TcpConnect   *ebpf.ProgramSpec `ebpf:"tcp_connect"`
-	UdpV4Connect *ebpf.ProgramSpec `ebpf:"udp_v4_connect"`
-	UdpV6Connect *ebpf.ProgramSpec `ebpf:"udp_v6_connect"`
+	PingV4Sendmsg *ebpf.ProgramSpec `ebpf:"ping_v4_sendmsg"`
+	PingV6Sendmsg *ebpf.ProgramSpec `ebpf:"ping_v6_sendmsg"`
+	RawSendmsg    *ebpf.ProgramSpec `ebpf:"raw_sendmsg"`
+	Rawv6Sendmsg  *ebpf.ProgramSpec `ebpf:"rawv6_sendmsg"`
+	TcpConnect    *ebpf.ProgramSpec `ebpf:"tcp_connect"`
+	UdpV4Connect  *ebpf.ProgramSpec `ebpf:"udp_v4_connect"`
+	UdpV6Connect  *ebpf.ProgramSpec `ebpf:"udp_v6_connect"`
 }
 
 // bpfMapSpecs contains maps before they are loaded into the kernel.
 //
 // It can be passed ebpf.CollectionSpec.Assign.
 type bpfMapSpecs struct {
-	OmConnectionEvents *ebpf.MapSpec `ebpf:"om_connection_events"`
+	OmConnectArgs          *ebpf.MapSpec `ebpf:"om_connect_args"`
+	OmConnectionDrops      *ebpf.MapSpec `ebpf:"om_connection_drops"`
+	OmConnectionEvents     *ebpf.MapSpec `ebpf:"om_connection_events"`
+	OmConnectionEventsPerf *ebpf.MapSpec `ebpf:"om_connection_events_perf"`
+	OmConnectionScratch    *ebpf.MapSpec `ebpf:"om_connection_scratch"`
+	OmIcmpSeen             *ebpf.MapSpec `ebpf:"om_icmp_seen"`
 }
 
 // bpfObjects contains all objects after they have been loaded into the kernel.
@@ -84,12 +116,22 @@ func (o *bpfObjects) Close() error {
 //
 // It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
 type bpfMaps struct {
-	OmConnectionEvents *ebpf.Map `ebpf:"om_connection_events"`
+	OmConnectArgs          *ebpf.Map `ebpf:"om_connect_args"`
+	OmConnectionDrops      *ebpf.Map `ebpf:"om_connection_drops"`
+	OmConnectionEvents     *ebpf.Map `ebpf:"om_connection_events"`
+	OmConnectionEventsPerf *ebpf.Map `ebpf:"om_connection_events_perf"`
+	OmConnectionScratch    *ebpf.Map `ebpf:"om_connection_scratch"`
+	OmIcmpSeen             *ebpf.Map `ebpf:"om_icmp_seen"`
 }
 
 func (m *bpfMaps) Close() error {
 	return _BpfClose(
+		m.OmConnectArgs,
+		m.OmConnectionDrops,
 		m.OmConnectionEvents,
+		m.OmConnectionEventsPerf,
+		m.OmConnectionScratch,
+		m.OmIcmpSeen,
 	)
 }
 
@@ -97,13 +139,21 @@ func (m *bpfMaps) Close() error {
 //
 // It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
 type bpfPrograms struct {
-	TcpConnect   *ebpf.Program `ebpf:"tcp_connect"`
-	UdpV4Connect *ebpf.Program `ebpf:"udp_v4_connect"`
-	UdpV6Connect *ebpf.Program `ebpf:"udp_v6_connect"`
+	PingV4Sendmsg *ebpf.Program `ebpf:"ping_v4_sendmsg"`
+	PingV6Sendmsg *ebpf.Program `ebpf:"ping_v6_sendmsg"`
+	RawSendmsg    *ebpf.Program `ebpf:"raw_sendmsg"`
+	Rawv6Sendmsg  *ebpf.Program `ebpf:"rawv6_sendmsg"`
+	TcpConnect    *ebpf.Program `ebpf:"tcp_connect"`
+	UdpV4Connect  *ebpf.Program `ebpf:"udp_v4_connect"`
+	UdpV6Connect  *ebpf.Program `ebpf:"udp_v6_connect"`
 }
 
 func (p *bpfPrograms) Close() error {
 	return _BpfClose(
+		p.PingV4Sendmsg,
+		p.PingV6Sendmsg,
+		p.RawSendmsg,
+		p.Rawv6Sendmsg,
 		p.TcpConnect,
 		p.UdpV4Connect,
 		p.UdpV6Connect,
@@ -0,0 +1,202 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build arm64
+
+package connection_listener
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type bpfEvent struct {
+	Saddr     [4]uint32
+	Daddr     [4]uint32
+	Sport     uint16
+	Dport     uint16
+	Pid       uint32
+	IpVersion uint8
+	Protocol  uint8
+	Direction uint8
+	IcmpType  uint8
+	IcmpCode  uint8
+	SockType  uint8
+	_         [2]byte
+}
+
+type bpfIcmpKey struct {
+	Pid      uint32
+	Daddr    [4]uint32
+	Protocol uint8
+	IcmpType uint8
+	Pad      [2]uint8
+}
+
+// loadBpf returns the embedded CollectionSpec for bpf.
+func loadBpf() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_BpfBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load bpf: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadBpfObjects loads bpf and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*bpfObjects
+//	*bpfPrograms
+//	*bpfMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadBpfObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadBpf()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// bpfSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type bpfSpecs struct {
+	bpfProgramSpecs
+	bpfMapSpecs
+}
+
+// bpfSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type bpfProgramSpecs struct {
+	PingV4Sendmsg         *ebpf.ProgramSpec `ebpf:"ping_v4_sendmsg"`
+	PingV4SendmsgKprobe   *ebpf.ProgramSpec `ebpf:"ping_v4_sendmsg_kprobe"`
+	PingV6Sendmsg         *ebpf.ProgramSpec `ebpf:"ping_v6_sendmsg"`
+	PingV6SendmsgKprobe   *ebpf.ProgramSpec `ebpf:"ping_v6_sendmsg_kprobe"`
+	RawSendmsg            *ebpf.ProgramSpec `ebpf:"raw_sendmsg"`
+	RawSendmsgKprobe      *ebpf.ProgramSpec `ebpf:"raw_sendmsg_kprobe"`
+	Rawv6Sendmsg          *ebpf.ProgramSpec `ebpf:"rawv6_sendmsg"`
+	Rawv6SendmsgKprobe    *ebpf.ProgramSpec `ebpf:"rawv6_sendmsg_kprobe"`
+	TcpConnect            *ebpf.ProgramSpec `ebpf:"tcp_connect"`
+	TcpConnectKprobe      *ebpf.ProgramSpec `ebpf:"tcp_connect_kprobe"`
+	UdpV4Connect          *ebpf.ProgramSpec `ebpf:"udp_v4_connect"`
+	UdpV4ConnectKprobe    *ebpf.ProgramSpec `ebpf:"udp_v4_connect_kprobe"`
+	UdpV4ConnectKretprobe *ebpf.ProgramSpec `ebpf:"udp_v4_connect_kretprobe"`
+	UdpV6Connect          *ebpf.ProgramSpec `ebpf:"udp_v6_connect"`
+	UdpV6ConnectKprobe    *ebpf.ProgramSpec `ebpf:"udp_v6_connect_kprobe"`
+	UdpV6ConnectKretprobe *ebpf.ProgramSpec `ebpf:"udp_v6_connect_kretprobe"`
+}
+
+// bpfMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type bpfMapSpecs struct {
+	OmConnectArgs          *ebpf.MapSpec `ebpf:"om_connect_args"`
+	OmConnectionDrops      *ebpf.MapSpec `ebpf:"om_connection_drops"`
+	OmConnectionEvents     *ebpf.MapSpec `ebpf:"om_connection_events"`
+	OmConnectionEventsPerf *ebpf.MapSpec `ebpf:"om_connection_events_perf"`
+	OmConnectionScratch    *ebpf.MapSpec `ebpf:"om_connection_scratch"`
+	OmIcmpSeen             *ebpf.MapSpec `ebpf:"om_icmp_seen"`
+}
+
+// bpfObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
+type bpfObjects struct {
+	bpfPrograms
+	bpfMaps
+}
+
+func (o *bpfObjects) Close() error {
+	return _BpfClose(
+		&o.bpfPrograms,
+		&o.bpfMaps,
+	)
+}
+
+// bpfMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
+type bpfMaps struct {
+	OmConnectArgs          *ebpf.Map `ebpf:"om_connect_args"`
+	OmConnectionDrops      *ebpf.Map `ebpf:"om_connection_drops"`
+	OmConnectionEvents     *ebpf.Map `ebpf:"om_connection_events"`
+	OmConnectionEventsPerf *ebpf.Map `ebpf:"om_connection_events_perf"`
+	OmConnectionScratch    *ebpf.Map `ebpf:"om_connection_scratch"`
+	OmIcmpSeen             *ebpf.Map `ebpf:"om_icmp_seen"`
+}
+
+func (m *bpfMaps) Close() error {
+	return _BpfClose(
+		m.OmConnectArgs,
+		m.OmConnectionDrops,
+		m.OmConnectionEvents,
+		m.OmConnectionEventsPerf,
+		m.OmConnectionScratch,
+		m.OmIcmpSeen,
+	)
+}
+
+// bpfPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
+type bpfPrograms struct {
+	PingV4Sendmsg         *ebpf.Program `ebpf:"ping_v4_sendmsg"`
+	PingV4SendmsgKprobe   *ebpf.Program `ebpf:"ping_v4_sendmsg_kprobe"`
+	PingV6Sendmsg         *ebpf.Program `ebpf:"ping_v6_sendmsg"`
+	PingV6SendmsgKprobe   *ebpf.Program `ebpf:"ping_v6_sendmsg_kprobe"`
+	RawSendmsg            *ebpf.Program `ebpf:"raw_sendmsg"`
+	RawSendmsgKprobe      *ebpf.Program `ebpf:"raw_sendmsg_kprobe"`
+	Rawv6Sendmsg          *ebpf.Program `ebpf:"rawv6_sendmsg"`
+	Rawv6SendmsgKprobe    *ebpf.Program `ebpf:"rawv6_sendmsg_kprobe"`
+	TcpConnect            *ebpf.Program `ebpf:"tcp_connect"`
+	TcpConnectKprobe      *ebpf.Program `ebpf:"tcp_connect_kprobe"`
+	UdpV4Connect          *ebpf.Program `ebpf:"udp_v4_connect"`
+	UdpV4ConnectKprobe    *ebpf.Program `ebpf:"udp_v4_connect_kprobe"`
+	UdpV4ConnectKretprobe *ebpf.Program `ebpf:"udp_v4_connect_kretprobe"`
+	UdpV6Connect          *ebpf.Program `ebpf:"udp_v6_connect"`
+	UdpV6ConnectKprobe    *ebpf.Program `ebpf:"udp_v6_connect_kprobe"`
+	UdpV6ConnectKretprobe *ebpf.Program `ebpf:"udp_v6_connect_kretprobe"`
+}
+
+func (p *bpfPrograms) Close() error {
+	return _BpfClose(
+		p.PingV4Sendmsg,
+		p.PingV4SendmsgKprobe,
+		p.PingV6Sendmsg,
+		p.PingV6SendmsgKprobe,
+		p.RawSendmsg,
+		p.RawSendmsgKprobe,
+		p.Rawv6Sendmsg,
+		p.Rawv6SendmsgKprobe,
+		p.TcpConnect,
+		p.TcpConnectKprobe,
+		p.UdpV4Connect,
+		p.UdpV4ConnectKprobe,
+		p.UdpV4ConnectKretprobe,
+		p.UdpV6Connect,
+		p.UdpV6ConnectKprobe,
+		p.UdpV6ConnectKretprobe,
+	)
+}
+
+func _BpfClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed bpf_bpfel_arm64.o
+var _BpfBytes []byte
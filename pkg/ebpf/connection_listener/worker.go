@@ -1,62 +1,119 @@
 package connection_listener
 
 import (
-	"bytes"
-	"context"
-	"encoding/binary"
 	"errors"
 	"fmt"
+	"os"
+
+	cilium "github.com/cilium/ebpf"
 
-	"github.com/cilium/ebpf/ringbuf"
-	"github.com/cilium/ebpf/rlimit"
 	"github.com/lonelysadness/OpenMonitor/pkg/ebpf"
 )
 
-//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cc clang -cflags "-O2 -g -Wall -Werror" bpf ../programs/monitor.c
+// The kprobe fallbacks read registers, they are built for each architecture
+// with an object of its own. The others share an object without them, see
+// programs/kprobe.h.
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cc clang -cflags "-O2 -g -Wall -Werror" -target amd64,arm64 bpf ../programs/monitor.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cc clang -cflags "-O2 -g -Wall -Werror" -tags "!386,!amd64,!arm64" bpf ../programs/monitor.c
 
-func ConnectionListenerWorker(ctx context.Context, events chan *ebpf.ConnectionEvent) error {
-	// Allow the current process to lock memory for eBPF resources
-	if err := rlimit.RemoveMemlock(); err != nil {
-		return fmt.Errorf("failed to remove memory lock: %w", err)
-	}
+// Listener sends an event for every new connection. It is a component of
+// the eBPF manager.
+type Listener struct {
+	events   chan *ebpf.ConnectionEvent
+	counters *ebpf.RingBufferCounters
+
+	coll   *ebpf.Collection
+	reader *ebpf.EventReader
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// New returns a listener that sends its events to events.
+func New(events chan *ebpf.ConnectionEvent, counters *ebpf.RingBufferCounters) *Listener {
+	return &Listener{events: events, counters: counters}
+}
+
+// Spec returns the compiled object of the listener.
+func (l *Listener) Spec() (*cilium.CollectionSpec, error) {
+	return ebpf.LoadObject(loadBpf)
+}
 
+// Start loads and attaches the listener.
+func (l *Listener) Start(env *ebpf.Env) ([]string, error) {
 	// Load pre-compiled programs into the kernel
-	objs := bpfObjects{}
-	if err := loadBpfObjects(&objs, nil); err != nil {
-		return fmt.Errorf("failed to load BPF objects: %w", err)
+	spec, err := l.Spec()
+	if err != nil {
+		return nil, err
+	}
+	if l.coll, err = env.LoadCollection(spec); err != nil {
+		return nil, err
 	}
-	defer objs.Close()
+	l.counters.SetDropMap(l.coll.Maps["om_connection_drops"])
 
-	rd, err := ringbuf.NewReader(objs.OmConnectionEvents)
-	if err != nil {
-		return fmt.Errorf("failed to create ring buffer reader: %w", err)
-	}
-	defer rd.Close()
-
-	// Read events from ring buffer
-	go func() {
-		for {
-			record, err := rd.Read()
-			if err != nil {
-				if errors.Is(err, ringbuf.ErrClosed) {
-					return
-				}
-				continue
-			}
+	for _, name := range []string{"tcp_connect", "udp_v4_connect", "udp_v6_connect"} {
+		if err := l.coll.AttachTracing(name); err != nil {
+			return nil, err
+		}
+	}
 
-			var event ebpf.ConnectionEvent
-			if err := binary.Read(bytes.NewBuffer(record.RawSample), binary.LittleEndian, &event); err != nil {
-				continue
-			}
+	// The IPv6 functions live in a module on some systems, without them only
+	// connects are seen.
+	var degraded []string
+	for _, name := range []string{"ping_v4_sendmsg", "ping_v6_sendmsg", "raw_sendmsg", "rawv6_sendmsg"} {
+		if err := l.coll.AttachTracing(name); err != nil {
+			degraded = append(degraded, fmt.Sprintf("%s not traced: %v", name, err))
+		}
+	}
+
+	if l.reader, err = ebpf.NewEventReader(l.coll, "om_connection_events"); err != nil {
+		return nil, err
+	}
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	go l.readEvents()
+	return degraded, nil
+}
 
-			select {
-			case events <- &event:
-			case <-ctx.Done():
+// Stop detaches the listener. The event channel stays open.
+func (l *Listener) Stop() error {
+	if l.reader != nil {
+		close(l.stop)
+		l.reader.Close()
+		<-l.done
+		l.reader = nil
+	}
+	if l.coll != nil {
+		l.counters.SetDropMap(nil)
+		l.coll.Close()
+		l.coll = nil
+	}
+	return nil
+}
+
+// readEvents forwards events until the listener is stopped.
+func (l *Listener) readEvents() {
+	defer close(l.done)
+	for {
+		sample, err := l.reader.Read()
+		if err != nil {
+			if errors.Is(err, os.ErrClosed) {
 				return
 			}
+			l.counters.ReadError()
+			continue
 		}
-	}()
 
-	<-ctx.Done()
-	return nil
+		var event ebpf.ConnectionEvent
+		if err := event.UnmarshalBinary(sample); err != nil {
+			l.counters.DecodeError()
+			continue
+		}
+		l.counters.Received()
+
+		select {
+		case l.events <- &event:
+		case <-l.stop:
+			return
+		}
+	}
 }
@@ -0,0 +1,195 @@
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// The decoders below read ring buffer samples at fixed offsets instead of
+// going through binary.Read, which relies on reflection and allocates for
+// every event. Offsets must be kept in sync with the C structs.
+
+// Size of the Event struct in monitor.c, without trailing padding.
+const connectionEventSize = 46
+
+// Size of the event_hdr_t struct in exec.c.
+const execEventHeaderSize = 16
+
+// Size of the fixed part of the event_t struct in exec.c.
+const execEventFixedSize = 48
+
+// Size of the dns_event struct in dns.c without the payload.
+const dnsEventFixedSize = 48
+
+// Size of the listen_event struct in bandwidth.c.
+const listenEventSize = 44
+
+// Size of the block_event struct in enforce.c.
+const blockEventSize = 44
+
+// UnmarshalBinary decodes an Event of monitor.c.
+func (e *ConnectionEvent) UnmarshalBinary(data []byte) error {
+	if len(data) < connectionEventSize {
+		return fmt.Errorf("connection event too short: %d bytes", len(data))
+	}
+
+	for i := 0; i < 4; i++ {
+		e.SrcAddr[i] = binary.LittleEndian.Uint32(data[i*4:])
+		e.DstAddr[i] = binary.LittleEndian.Uint32(data[16+i*4:])
+	}
+	e.SrcPort = binary.LittleEndian.Uint16(data[32:])
+	e.DstPort = binary.LittleEndian.Uint16(data[34:])
+	e.PID = binary.LittleEndian.Uint32(data[36:])
+	e.IPVersion = data[40]
+	e.Protocol = data[41]
+	e.Direction = data[42]
+	e.ICMPType = data[43]
+	e.ICMPCode = data[44]
+	e.SockType = data[45]
+	return nil
+}
+
+// UnmarshalBinary decodes an event_hdr_t of exec.c.
+func (h *ExecEventHeader) UnmarshalBinary(data []byte) error {
+	if len(data) < execEventHeaderSize {
+		return fmt.Errorf("exec event header too short: %d bytes", len(data))
+	}
+
+	h.Type = binary.LittleEndian.Uint32(data[0:])
+	h.PID = binary.LittleEndian.Uint32(data[4:])
+	h.PPID = binary.LittleEndian.Uint32(data[8:])
+	h.Retval = int32(binary.LittleEndian.Uint32(data[12:]))
+	return nil
+}
+
+// UnmarshalBinary decodes an event of exec.c. Exit and exec return events
+// only carry the header, exec events are followed by the packed filename
+// and arguments.
+func (e *ExecEvent) UnmarshalBinary(data []byte) error {
+	*e = ExecEvent{}
+	if err := e.ExecEventHeader.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	if e.Type != ExecEventExec {
+		return nil
+	}
+
+	if len(data) < execEventFixedSize {
+		return fmt.Errorf("exec event too short: %d bytes", len(data))
+	}
+	e.UID = binary.LittleEndian.Uint32(data[16:])
+	e.GID = binary.LittleEndian.Uint32(data[20:])
+	e.Comm = cString(data[24:40])
+	argc := int(binary.LittleEndian.Uint16(data[40:]))
+	dataLen := int(binary.LittleEndian.Uint16(data[42:]))
+	e.Truncated = ExecTruncation(data[44])
+
+	packed := data[execEventFixedSize:]
+	if dataLen > len(packed) {
+		return fmt.Errorf("exec event data length %d exceeds sample size %d", dataLen, len(packed))
+	}
+	packed = packed[:dataLen]
+
+	// The filename comes first, followed by the arguments.
+	e.Filename, packed = nextString(packed)
+	if argc > 0 {
+		e.Argv = make([]string, 0, argc)
+	}
+	for len(packed) > 0 && len(e.Argv) < argc {
+		var arg string
+		arg, packed = nextString(packed)
+		e.Argv = append(e.Argv, arg)
+	}
+	return nil
+}
+
+// UnmarshalBinary decodes a dns_event of dns.c. The payload is copied.
+func (e *DNSEvent) UnmarshalBinary(data []byte) error {
+	if len(data) < dnsEventFixedSize {
+		return fmt.Errorf("dns event too short: %d bytes", len(data))
+	}
+
+	e.PID = binary.LittleEndian.Uint32(data[0:])
+	e.Comm = cString(data[4:20])
+	e.ServerPort = binary.LittleEndian.Uint16(data[36:])
+	e.Length = binary.LittleEndian.Uint16(data[38:])
+	captured := int(binary.LittleEndian.Uint16(data[40:]))
+	e.IPVersion = data[42]
+	e.Protocol = data[43]
+	e.Response = data[44] == 1
+
+	// The address is in network byte order.
+	if e.IPVersion == 6 {
+		e.Server = net.IP(bytes.Clone(data[20:36]))
+	} else {
+		e.Server = net.IP(bytes.Clone(data[20:24]))
+	}
+
+	payload := data[dnsEventFixedSize:]
+	if captured > len(payload) {
+		return fmt.Errorf("dns event payload length %d exceeds sample size %d", captured, len(payload))
+	}
+	e.Payload = bytes.Clone(payload[:captured])
+	return nil
+}
+
+// UnmarshalBinary decodes a listen_event of bandwidth.c.
+func (e *ListenEvent) UnmarshalBinary(data []byte) error {
+	if len(data) < listenEventSize {
+		return fmt.Errorf("listen event too short: %d bytes", len(data))
+	}
+
+	e.PID = binary.LittleEndian.Uint32(data[16:])
+	e.Comm = cString(data[20:36])
+	e.Port = binary.LittleEndian.Uint16(data[36:])
+	e.IPVersion = data[38]
+	e.Protocol = data[39]
+	e.Type = data[40]
+
+	// The address is in network byte order.
+	if e.IPVersion == 6 {
+		e.Addr = net.IP(bytes.Clone(data[0:16]))
+	} else {
+		e.Addr = net.IP(bytes.Clone(data[0:4]))
+	}
+	return nil
+}
+
+// UnmarshalBinary decodes a block_event of enforce.c.
+func (e *BlockEvent) UnmarshalBinary(data []byte) error {
+	if len(data) < blockEventSize {
+		return fmt.Errorf("block event too short: %d bytes", len(data))
+	}
+
+	e.PID = binary.LittleEndian.Uint32(data[16:])
+	e.RuleID = binary.LittleEndian.Uint32(data[20:])
+	e.Comm = cString(data[24:40])
+	e.Port = binary.LittleEndian.Uint16(data[40:])
+	e.IPVersion = data[42]
+	e.Protocol = data[43]
+
+	// The address is in network byte order.
+	if e.IPVersion == 6 {
+		e.Addr = net.IP(bytes.Clone(data[0:16]))
+	} else {
+		e.Addr = net.IP(bytes.Clone(data[0:4]))
+	}
+	return nil
+}
+
+// nextString returns the first NUL terminated string and the remainder.
+func nextString(data []byte) (string, []byte) {
+	end := bytes.IndexByte(data, 0)
+	if end < 0 {
+		return string(data), nil
+	}
+	return string(data[:end]), data[end+1:]
+}
+
+// cString converts a NUL terminated buffer to a string.
+func cString(b []byte) string {
+	s, _ := nextString(b)
+	return s
+}
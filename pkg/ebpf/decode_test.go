@@ -0,0 +1,172 @@
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/flow"
+)
+
+func sampleConnectionEvent(t testing.TB) []byte {
+	event := ConnectionEvent{
+		SrcAddr:   [4]uint32{0xc0a80001},
+		DstAddr:   [4]uint32{0x01010101},
+		SrcPort:   0x3412,
+		DstPort:   0xbb01,
+		PID:       0x39300000,
+		IPVersion: 4,
+		Protocol:  6,
+		Direction: 0,
+		SockType:  SockStream,
+	}
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, &event); err != nil {
+		t.Fatal(err)
+	}
+	// Trailing padding of the C struct.
+	buf.Write([]byte{0, 0})
+	return buf.Bytes()
+}
+
+func sampleExecEvent(argv ...string) []byte {
+	packed := []byte("/usr/bin/curl\x00")
+	for _, arg := range argv {
+		packed = append(packed, arg...)
+		packed = append(packed, 0)
+	}
+
+	raw := make([]byte, execEventFixedSize, execEventFixedSize+len(packed))
+	binary.LittleEndian.PutUint32(raw[0:], ExecEventExec)
+	binary.LittleEndian.PutUint32(raw[4:], 1234)
+	binary.LittleEndian.PutUint32(raw[8:], 1)
+	binary.LittleEndian.PutUint32(raw[16:], 1000)
+	binary.LittleEndian.PutUint32(raw[20:], 1000)
+	copy(raw[24:40], "bash")
+	binary.LittleEndian.PutUint16(raw[40:], uint16(len(argv)))
+	binary.LittleEndian.PutUint16(raw[42:], uint16(len(packed)))
+	raw[44] = uint8(TruncatedArg)
+	return append(raw, packed...)
+}
+
+func TestConnectionEventMatchesBinaryRead(t *testing.T) {
+	raw := sampleConnectionEvent(t)
+
+	var want ConnectionEvent
+	if err := binary.Read(bytes.NewReader(raw), binary.LittleEndian, &want); err != nil {
+		t.Fatal(err)
+	}
+
+	var got ConnectionEvent
+	if err := got.UnmarshalBinary(raw); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestConnectionEventFlow(t *testing.T) {
+	var event ConnectionEvent
+	if err := event.UnmarshalBinary(sampleConnectionEvent(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := flow.Flow{
+		Protocol:  flow.TCP,
+		Direction: flow.Outbound,
+		Local:     netip.MustParseAddrPort("192.168.0.1:4660"),
+		Remote:    netip.MustParseAddrPort("1.1.1.1:443"),
+		PID:       12345,
+	}
+	if got := event.Flow(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestExecEvent(t *testing.T) {
+	var got ExecEvent
+	if err := got.UnmarshalBinary(sampleExecEvent("curl", "-s", "https://example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := ExecEvent{
+		ExecEventHeader: ExecEventHeader{Type: ExecEventExec, PID: 1234, PPID: 1},
+		UID:             1000,
+		GID:             1000,
+		Comm:            "bash",
+		Filename:        "/usr/bin/curl",
+		Argv:            []string{"curl", "-s", "https://example.com"},
+		Truncated:       TruncatedArg,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Exit events are only a header.
+	raw := make([]byte, execEventHeaderSize)
+	binary.LittleEndian.PutUint32(raw[0:], ExecEventExit)
+	binary.LittleEndian.PutUint32(raw[4:], 1234)
+	if err := got.UnmarshalBinary(raw); err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != ExecEventExit || got.PID != 1234 || got.Argv != nil {
+		t.Errorf("unexpected exit event %+v", got)
+	}
+}
+
+func reportRate(b *testing.B, start time.Time) {
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "events/s")
+}
+
+func BenchmarkConnectionEventUnmarshal(b *testing.B) {
+	raw := sampleConnectionEvent(b)
+	b.ReportAllocs()
+	start := time.Now()
+	b.RunParallel(func(pb *testing.PB) {
+		var event ConnectionEvent
+		for pb.Next() {
+			if err := event.UnmarshalBinary(raw); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	reportRate(b, start)
+}
+
+func BenchmarkConnectionEventBinaryRead(b *testing.B) {
+	raw := sampleConnectionEvent(b)
+	b.ReportAllocs()
+	start := time.Now()
+	b.RunParallel(func(pb *testing.PB) {
+		var event ConnectionEvent
+		for pb.Next() {
+			if err := binary.Read(bytes.NewReader(raw), binary.LittleEndian, &event); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	reportRate(b, start)
+}
+
+func BenchmarkExecEventUnmarshal(b *testing.B) {
+	raw := sampleExecEvent("curl", "-s", "-o", "/dev/null", "https://example.com")
+	b.ReportAllocs()
+	start := time.Now()
+	b.RunParallel(func(pb *testing.PB) {
+		var event ExecEvent
+		for pb.Next() {
+			if err := event.UnmarshalBinary(raw); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	reportRate(b, start)
+}
@@ -0,0 +1,189 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build (386 || amd64 || amd64p32 || arm || arm64 || loong64 || mips64le || mips64p32le || mipsle || ppc64le || riscv64) && !386 && !amd64 && !arm64
+
+package dns
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type bpfDnsEvent struct {
+	Pid        uint32
+	Comm       [16]uint8
+	Server     [4]uint32
+	ServerPort uint16
+	Len        uint16
+	Captured   uint16
+	IpVersion  uint8
+	Protocol   uint8
+	Direction  uint8
+	Pad        [3]uint8
+	Payload    [1024]uint8
+}
+
+type bpfRecvArgs struct {
+	Segs [4]struct {
+		Base uint64
+		Len  uint64
+	}
+	Sk    uint64
+	Msg   uint64
+	Nsegs uint32
+	_     [4]byte
+}
+
+// loadBpf returns the embedded CollectionSpec for bpf.
+func loadBpf() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_BpfBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load bpf: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadBpfObjects loads bpf and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*bpfObjects
+//	*bpfPrograms
+//	*bpfMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadBpfObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadBpf()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// bpfSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type bpfSpecs struct {
+	bpfProgramSpecs
+	bpfMapSpecs
+}
+
+// bpfSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type bpfProgramSpecs struct {
+	DnsTcpRecvmsg              *ebpf.ProgramSpec `ebpf:"dns_tcp_recvmsg"`
+	DnsTcpRecvmsgExit          *ebpf.ProgramSpec `ebpf:"dns_tcp_recvmsg_exit"`
+	DnsTcpRecvmsgExitNoblock   *ebpf.ProgramSpec `ebpf:"dns_tcp_recvmsg_exit_noblock"`
+	DnsTcpSendmsg              *ebpf.ProgramSpec `ebpf:"dns_tcp_sendmsg"`
+	DnsUdpRecvmsg              *ebpf.ProgramSpec `ebpf:"dns_udp_recvmsg"`
+	DnsUdpRecvmsgExit          *ebpf.ProgramSpec `ebpf:"dns_udp_recvmsg_exit"`
+	DnsUdpRecvmsgExitNoblock   *ebpf.ProgramSpec `ebpf:"dns_udp_recvmsg_exit_noblock"`
+	DnsUdpSendmsg              *ebpf.ProgramSpec `ebpf:"dns_udp_sendmsg"`
+	DnsUdpv6Recvmsg            *ebpf.ProgramSpec `ebpf:"dns_udpv6_recvmsg"`
+	DnsUdpv6RecvmsgExit        *ebpf.ProgramSpec `ebpf:"dns_udpv6_recvmsg_exit"`
+	DnsUdpv6RecvmsgExitNoblock *ebpf.ProgramSpec `ebpf:"dns_udpv6_recvmsg_exit_noblock"`
+	DnsUdpv6Sendmsg            *ebpf.ProgramSpec `ebpf:"dns_udpv6_sendmsg"`
+}
+
+// bpfMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type bpfMapSpecs struct {
+	OmDnsDrops      *ebpf.MapSpec `ebpf:"om_dns_drops"`
+	OmDnsEvents     *ebpf.MapSpec `ebpf:"om_dns_events"`
+	OmDnsEventsPerf *ebpf.MapSpec `ebpf:"om_dns_events_perf"`
+	OmDnsRecvBuf    *ebpf.MapSpec `ebpf:"om_dns_recv_buf"`
+	OmDnsScratch    *ebpf.MapSpec `ebpf:"om_dns_scratch"`
+}
+
+// bpfObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
+type bpfObjects struct {
+	bpfPrograms
+	bpfMaps
+}
+
+func (o *bpfObjects) Close() error {
+	return _BpfClose(
+		&o.bpfPrograms,
+		&o.bpfMaps,
+	)
+}
+
+// bpfMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
+type bpfMaps struct {
+	OmDnsDrops      *ebpf.Map `ebpf:"om_dns_drops"`
+	OmDnsEvents     *ebpf.Map `ebpf:"om_dns_events"`
+	OmDnsEventsPerf *ebpf.Map `ebpf:"om_dns_events_perf"`
+	OmDnsRecvBuf    *ebpf.Map `ebpf:"om_dns_recv_buf"`
+	OmDnsScratch    *ebpf.Map `ebpf:"om_dns_scratch"`
+}
+
+func (m *bpfMaps) Close() error {
+	return _BpfClose(
+		m.OmDnsDrops,
+		m.OmDnsEvents,
+		m.OmDnsEventsPerf,
+		m.OmDnsRecvBuf,
+		m.OmDnsScratch,
+	)
+}
+
+// bpfPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
+type bpfPrograms struct {
+	DnsTcpRecvmsg              *ebpf.Program `ebpf:"dns_tcp_recvmsg"`
+	DnsTcpRecvmsgExit          *ebpf.Program `ebpf:"dns_tcp_recvmsg_exit"`
+	DnsTcpRecvmsgExitNoblock   *ebpf.Program `ebpf:"dns_tcp_recvmsg_exit_noblock"`
+	DnsTcpSendmsg              *ebpf.Program `ebpf:"dns_tcp_sendmsg"`
+	DnsUdpRecvmsg              *ebpf.Program `ebpf:"dns_udp_recvmsg"`
+	DnsUdpRecvmsgExit          *ebpf.Program `ebpf:"dns_udp_recvmsg_exit"`
+	DnsUdpRecvmsgExitNoblock   *ebpf.Program `ebpf:"dns_udp_recvmsg_exit_noblock"`
+	DnsUdpSendmsg              *ebpf.Program `ebpf:"dns_udp_sendmsg"`
+	DnsUdpv6Recvmsg            *ebpf.Program `ebpf:"dns_udpv6_recvmsg"`
+	DnsUdpv6RecvmsgExit        *ebpf.Program `ebpf:"dns_udpv6_recvmsg_exit"`
+	DnsUdpv6RecvmsgExitNoblock *ebpf.Program `ebpf:"dns_udpv6_recvmsg_exit_noblock"`
+	DnsUdpv6Sendmsg            *ebpf.Program `ebpf:"dns_udpv6_sendmsg"`
+}
+
+func (p *bpfPrograms) Close() error {
+	return _BpfClose(
+		p.DnsTcpRecvmsg,
+		p.DnsTcpRecvmsgExit,
+		p.DnsTcpRecvmsgExitNoblock,
+		p.DnsTcpSendmsg,
+		p.DnsUdpRecvmsg,
+		p.DnsUdpRecvmsgExit,
+		p.DnsUdpRecvmsgExitNoblock,
+		p.DnsUdpSendmsg,
+		p.DnsUdpv6Recvmsg,
+		p.DnsUdpv6RecvmsgExit,
+		p.DnsUdpv6RecvmsgExitNoblock,
+		p.DnsUdpv6Sendmsg,
+	)
+}
+
+func _BpfClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed bpf_bpfel.o
+var _BpfBytes []byte
@@ -0,0 +1,216 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build 386 || amd64
+
+package dns
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type bpfDnsEvent struct {
+	Pid        uint32
+	Comm       [16]uint8
+	Server     [4]uint32
+	ServerPort uint16
+	Len        uint16
+	Captured   uint16
+	IpVersion  uint8
+	Protocol   uint8
+	Direction  uint8
+	Pad        [3]uint8
+	Payload    [1024]uint8
+}
+
+type bpfRecvArgs struct {
+	Segs [4]struct {
+		Base uint64
+		Len  uint64
+	}
+	Sk    uint64
+	Msg   uint64
+	Nsegs uint32
+	_     [4]byte
+}
+
+// loadBpf returns the embedded CollectionSpec for bpf.
+func loadBpf() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_BpfBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load bpf: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadBpfObjects loads bpf and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*bpfObjects
+//	*bpfPrograms
+//	*bpfMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadBpfObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadBpf()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// bpfSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type bpfSpecs struct {
+	bpfProgramSpecs
+	bpfMapSpecs
+}
+
+// bpfSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type bpfProgramSpecs struct {
+	DnsTcpRecvmsg                *ebpf.ProgramSpec `ebpf:"dns_tcp_recvmsg"`
+	DnsTcpRecvmsgExit            *ebpf.ProgramSpec `ebpf:"dns_tcp_recvmsg_exit"`
+	DnsTcpRecvmsgExitKretprobe   *ebpf.ProgramSpec `ebpf:"dns_tcp_recvmsg_exit_kretprobe"`
+	DnsTcpRecvmsgExitNoblock     *ebpf.ProgramSpec `ebpf:"dns_tcp_recvmsg_exit_noblock"`
+	DnsTcpRecvmsgKprobe          *ebpf.ProgramSpec `ebpf:"dns_tcp_recvmsg_kprobe"`
+	DnsTcpSendmsg                *ebpf.ProgramSpec `ebpf:"dns_tcp_sendmsg"`
+	DnsTcpSendmsgKprobe          *ebpf.ProgramSpec `ebpf:"dns_tcp_sendmsg_kprobe"`
+	DnsUdpRecvmsg                *ebpf.ProgramSpec `ebpf:"dns_udp_recvmsg"`
+	DnsUdpRecvmsgExit            *ebpf.ProgramSpec `ebpf:"dns_udp_recvmsg_exit"`
+	DnsUdpRecvmsgExitKretprobe   *ebpf.ProgramSpec `ebpf:"dns_udp_recvmsg_exit_kretprobe"`
+	DnsUdpRecvmsgExitNoblock     *ebpf.ProgramSpec `ebpf:"dns_udp_recvmsg_exit_noblock"`
+	DnsUdpRecvmsgKprobe          *ebpf.ProgramSpec `ebpf:"dns_udp_recvmsg_kprobe"`
+	DnsUdpSendmsg                *ebpf.ProgramSpec `ebpf:"dns_udp_sendmsg"`
+	DnsUdpSendmsgKprobe          *ebpf.ProgramSpec `ebpf:"dns_udp_sendmsg_kprobe"`
+	DnsUdpv6Recvmsg              *ebpf.ProgramSpec `ebpf:"dns_udpv6_recvmsg"`
+	DnsUdpv6RecvmsgExit          *ebpf.ProgramSpec `ebpf:"dns_udpv6_recvmsg_exit"`
+	DnsUdpv6RecvmsgExitKretprobe *ebpf.ProgramSpec `ebpf:"dns_udpv6_recvmsg_exit_kretprobe"`
+	DnsUdpv6RecvmsgExitNoblock   *ebpf.ProgramSpec `ebpf:"dns_udpv6_recvmsg_exit_noblock"`
+	DnsUdpv6RecvmsgKprobe        *ebpf.ProgramSpec `ebpf:"dns_udpv6_recvmsg_kprobe"`
+	DnsUdpv6Sendmsg              *ebpf.ProgramSpec `ebpf:"dns_udpv6_sendmsg"`
+	DnsUdpv6SendmsgKprobe        *ebpf.ProgramSpec `ebpf:"dns_udpv6_sendmsg_kprobe"`
+}
+
+// bpfMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type bpfMapSpecs struct {
+	OmDnsDrops      *ebpf.MapSpec `ebpf:"om_dns_drops"`
+	OmDnsEvents     *ebpf.MapSpec `ebpf:"om_dns_events"`
+	OmDnsEventsPerf *ebpf.MapSpec `ebpf:"om_dns_events_perf"`
+	OmDnsRecvBuf    *ebpf.MapSpec `ebpf:"om_dns_recv_buf"`
+	OmDnsScratch    *ebpf.MapSpec `ebpf:"om_dns_scratch"`
+}
+
+// bpfObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
+type bpfObjects struct {
+	bpfPrograms
+	bpfMaps
+}
+
+func (o *bpfObjects) Close() error {
+	return _BpfClose(
+		&o.bpfPrograms,
+		&o.bpfMaps,
+	)
+}
+
+// bpfMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
+type bpfMaps struct {
+	OmDnsDrops      *ebpf.Map `ebpf:"om_dns_drops"`
+	OmDnsEvents     *ebpf.Map `ebpf:"om_dns_events"`
+	OmDnsEventsPerf *ebpf.Map `ebpf:"om_dns_events_perf"`
+	OmDnsRecvBuf    *ebpf.Map `ebpf:"om_dns_recv_buf"`
+	OmDnsScratch    *ebpf.Map `ebpf:"om_dns_scratch"`
+}
+
+func (m *bpfMaps) Close() error {
+	return _BpfClose(
+		m.OmDnsDrops,
+		m.OmDnsEvents,
+		m.OmDnsEventsPerf,
+		m.OmDnsRecvBuf,
+		m.OmDnsScratch,
+	)
+}
+
+// bpfPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
+type bpfPrograms struct {
+	DnsTcpRecvmsg                *ebpf.Program `ebpf:"dns_tcp_recvmsg"`
+	DnsTcpRecvmsgExit            *ebpf.Program `ebpf:"dns_tcp_recvmsg_exit"`
+	DnsTcpRecvmsgExitKretprobe   *ebpf.Program `ebpf:"dns_tcp_recvmsg_exit_kretprobe"`
+	DnsTcpRecvmsgExitNoblock     *ebpf.Program `ebpf:"dns_tcp_recvmsg_exit_noblock"`
+	DnsTcpRecvmsgKprobe          *ebpf.Program `ebpf:"dns_tcp_recvmsg_kprobe"`
+	DnsTcpSendmsg                *ebpf.Program `ebpf:"dns_tcp_sendmsg"`
+	DnsTcpSendmsgKprobe          *ebpf.Program `ebpf:"dns_tcp_sendmsg_kprobe"`
+	DnsUdpRecvmsg                *ebpf.Program `ebpf:"dns_udp_recvmsg"`
+	DnsUdpRecvmsgExit            *ebpf.Program `ebpf:"dns_udp_recvmsg_exit"`
+	DnsUdpRecvmsgExitKretprobe   *ebpf.Program `ebpf:"dns_udp_recvmsg_exit_kretprobe"`
+	DnsUdpRecvmsgExitNoblock     *ebpf.Program `ebpf:"dns_udp_recvmsg_exit_noblock"`
+	DnsUdpRecvmsgKprobe          *ebpf.Program `ebpf:"dns_udp_recvmsg_kprobe"`
+	DnsUdpSendmsg                *ebpf.Program `ebpf:"dns_udp_sendmsg"`
+	DnsUdpSendmsgKprobe          *ebpf.Program `ebpf:"dns_udp_sendmsg_kprobe"`
+	DnsUdpv6Recvmsg              *ebpf.Program `ebpf:"dns_udpv6_recvmsg"`
+	DnsUdpv6RecvmsgExit          *ebpf.Program `ebpf:"dns_udpv6_recvmsg_exit"`
+	DnsUdpv6RecvmsgExitKretprobe *ebpf.Program `ebpf:"dns_udpv6_recvmsg_exit_kretprobe"`
+	DnsUdpv6RecvmsgExitNoblock   *ebpf.Program `ebpf:"dns_udpv6_recvmsg_exit_noblock"`
+	DnsUdpv6RecvmsgKprobe        *ebpf.Program `ebpf:"dns_udpv6_recvmsg_kprobe"`
+	DnsUdpv6Sendmsg              *ebpf.Program `ebpf:"dns_udpv6_sendmsg"`
+	DnsUdpv6SendmsgKprobe        *ebpf.Program `ebpf:"dns_udpv6_sendmsg_kprobe"`
+}
+
+func (p *bpfPrograms) Close() error {
+	return _BpfClose(
+		p.DnsTcpRecvmsg,
+		p.DnsTcpRecvmsgExit,
+		p.DnsTcpRecvmsgExitKretprobe,
+		p.DnsTcpRecvmsgExitNoblock,
+		p.DnsTcpRecvmsgKprobe,
+		p.DnsTcpSendmsg,
+		p.DnsTcpSendmsgKprobe,
+		p.DnsUdpRecvmsg,
+		p.DnsUdpRecvmsgExit,
+		p.DnsUdpRecvmsgExitKretprobe,
+		p.DnsUdpRecvmsgExitNoblock,
+		p.DnsUdpRecvmsgKprobe,
+		p.DnsUdpSendmsg,
+		p.DnsUdpSendmsgKprobe,
+		p.DnsUdpv6Recvmsg,
+		p.DnsUdpv6RecvmsgExit,
+		p.DnsUdpv6RecvmsgExitKretprobe,
+		p.DnsUdpv6RecvmsgExitNoblock,
+		p.DnsUdpv6RecvmsgKprobe,
+		p.DnsUdpv6Sendmsg,
+		p.DnsUdpv6SendmsgKprobe,
+	)
+}
+
+func _BpfClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed bpf_bpfel_x86.o
+var _BpfBytes []byte
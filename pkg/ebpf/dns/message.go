@@ -0,0 +1,190 @@
+package dns
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	ebpfapi "github.com/lonelysadness/OpenMonitor/pkg/ebpf"
+)
+
+// Protocol numbers used in DNSEvent.Protocol
+const protocolTCP = 6
+
+// Message is a parsed DNS query or response together with the process that
+// sent or received it.
+type Message struct {
+	Time      time.Time
+	PID       uint32
+	Comm      string
+	Server    net.IP
+	Protocol  uint8
+	Response  bool
+	ID        uint16
+	Name      string
+	Type      string // e.g. "A", "AAAA"
+	RCode     string // responses only, e.g. "Success", "NameError"
+	Answers   []string
+	Truncated bool // the message was longer than the captured payload
+}
+
+// parseMessage parses the payload of a captured DNS message. Answers are
+// parsed as far as the payload goes.
+func parseMessage(event *ebpfapi.DNSEvent) (*Message, error) {
+	msg := &Message{
+		Time:      time.Now(),
+		PID:       event.PID,
+		Comm:      event.Comm,
+		Server:    event.Server,
+		Protocol:  event.Protocol,
+		Response:  event.Response,
+		Truncated: int(event.Length) > len(event.Payload),
+	}
+
+	payload := event.Payload
+	// DNS over TCP prefixes every message with its length. Resolvers that
+	// send or read the prefix on its own pass the message without it.
+	if event.Protocol == protocolTCP && len(payload) >= 2 &&
+		int(binary.BigEndian.Uint16(payload)) == int(event.Length)-2 {
+		payload = payload[2:]
+	}
+
+	var p dnsmessage.Parser
+	header, err := p.Start(payload)
+	if err != nil {
+		return nil, err
+	}
+	msg.ID = header.ID
+	if event.Response {
+		msg.RCode = strings.TrimPrefix(header.RCode.String(), "RCode")
+	}
+
+	question, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+	msg.Name = strings.TrimSuffix(question.Name.String(), ".")
+	msg.Type = strings.TrimPrefix(question.Type.String(), "Type")
+
+	if !event.Response {
+		return msg, nil
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return msg, nil
+	}
+
+	for {
+		answer, err := p.AnswerHeader()
+		if err != nil {
+			break
+		}
+
+		switch answer.Type {
+		case dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return msg, nil
+			}
+			msg.Answers = append(msg.Answers, net.IP(r.A[:]).String())
+		case dnsmessage.TypeAAAA:
+			r, err := p.AAAAResource()
+			if err != nil {
+				return msg, nil
+			}
+			msg.Answers = append(msg.Answers, net.IP(r.AAAA[:]).String())
+		case dnsmessage.TypeCNAME:
+			r, err := p.CNAMEResource()
+			if err != nil {
+				return msg, nil
+			}
+			msg.Answers = append(msg.Answers, strings.TrimSuffix(r.CNAME.String(), "."))
+		default:
+			if err := p.SkipAnswer(); err != nil {
+				return msg, nil
+			}
+		}
+	}
+
+	return msg, nil
+}
+
+// History keeps the most recent DNS messages, overall and per process.
+type History struct {
+	lock   sync.Mutex
+	size   int
+	recent []*Message // newest first
+	byPID  map[uint32][]*Message
+}
+
+// NewHistory returns a history keeping size messages overall and per process.
+func NewHistory(size int) *History {
+	return &History{
+		size:  size,
+		byPID: make(map[uint32][]*Message),
+	}
+}
+
+func (h *History) Add(msg *Message) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.recent = prepend(h.recent, msg, h.size)
+	h.byPID[msg.PID] = prepend(h.byPID[msg.PID], msg, h.size)
+}
+
+func prepend(list []*Message, msg *Message, size int) []*Message {
+	list = append([]*Message{msg}, list...)
+	if len(list) > size {
+		list = list[:size]
+	}
+	return list
+}
+
+// Recent returns up to n of the newest messages, newest first.
+func (h *History) Recent(n int) []*Message {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if n > len(h.recent) {
+		n = len(h.recent)
+	}
+	return append([]*Message(nil), h.recent[:n]...)
+}
+
+// ForPID returns the newest messages of a process, newest first.
+func (h *History) ForPID(pid uint32) []*Message {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return append([]*Message(nil), h.byPID[pid]...)
+}
+
+// PIDs returns the processes with messages in the history.
+func (h *History) PIDs() []uint32 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	pids := make([]uint32, 0, len(h.byPID))
+	for pid := range h.byPID {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	return pids
+}
+
+// Prune drops the history of processes for which keep returns false.
+func (h *History) Prune(keep func(pid uint32) bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for pid := range h.byPID {
+		if !keep(pid) {
+			delete(h.byPID, pid)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cilium/ebpf"
+
+	ebpfapi "github.com/lonelysadness/OpenMonitor/pkg/ebpf"
+)
+
+// The kprobe fallbacks read registers, they are built for each architecture
+// with an object of its own. The others share an object without them, see
+// programs/kprobe.h.
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cc clang -cflags "-O2 -g -Wall -Werror" -target amd64,arm64 bpf ../programs/dns.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cc clang -cflags "-O2 -g -Wall -Werror" -tags "!386,!amd64,!arm64" bpf ../programs/dns.c
+
+// Tracer captures DNS messages at the socket layer. Unlike the queued
+// packets it also sees traffic that is already covered by a permanent
+// verdict, and it knows the process behind every message.
+type Tracer struct {
+	coll     *ebpfapi.Collection
+	reader   *ebpfapi.EventReader
+	done     chan struct{}
+	counters *ebpfapi.RingBufferCounters
+	messages *ebpfapi.Broadcaster[*Message]
+}
+
+func New() *Tracer {
+	return &Tracer{
+		counters: ebpfapi.NewRingBufferCounters("dns"),
+		messages: ebpfapi.NewBroadcaster[*Message](),
+	}
+}
+
+// Spec returns the compiled object of the tracer.
+func (t *Tracer) Spec() (*ebpf.CollectionSpec, error) {
+	return ebpfapi.LoadObject(loadBpf)
+}
+
+// Start loads and attaches the tracer.
+func (t *Tracer) Start(env *ebpfapi.Env) ([]string, error) {
+	spec, err := t.Spec()
+	if err != nil {
+		return nil, err
+	}
+	if t.coll, err = env.LoadCollection(spec); err != nil {
+		return nil, err
+	}
+	t.counters.SetDropMap(t.coll.Maps["om_dns_drops"])
+
+	programs := []string{
+		"dns_udp_sendmsg",
+		"dns_udpv6_sendmsg",
+		"dns_tcp_sendmsg",
+		"dns_udp_recvmsg",
+		"dns_udp_recvmsg_exit",
+		"dns_udpv6_recvmsg",
+		"dns_udpv6_recvmsg_exit",
+		"dns_tcp_recvmsg",
+		"dns_tcp_recvmsg_exit",
+	}
+	for _, name := range programs {
+		if err := t.coll.AttachTracing(name); err != nil {
+			return nil, fmt.Errorf("failed to attach DNS tracer: %w", err)
+		}
+	}
+
+	if t.reader, err = ebpfapi.NewEventReader(t.coll, "om_dns_events"); err != nil {
+		return nil, err
+	}
+	t.done = make(chan struct{})
+	go t.readEvents()
+	return nil, nil
+}
+
+// Stop detaches the tracer. Subscriptions stay valid.
+func (t *Tracer) Stop() error {
+	if t.reader != nil {
+		t.reader.Close()
+		<-t.done
+		t.reader = nil
+	}
+	if t.coll != nil {
+		t.counters.SetDropMap(nil)
+		t.coll.Close()
+		t.coll = nil
+	}
+	return nil
+}
+
+// Subscribe returns a channel that receives every message read after the
+// call and a function that cancels the subscription and closes the channel.
+// Messages are dropped for subscribers that don't keep up.
+func (t *Tracer) Subscribe(size int) (<-chan *Message, func()) {
+	return t.messages.Subscribe(size)
+}
+
+// Counters returns the event and drop counters of the tracer.
+func (t *Tracer) Counters() *ebpfapi.RingBufferCounters {
+	return t.counters
+}
+
+func (t *Tracer) readEvents() {
+	defer close(t.done)
+	var event ebpfapi.DNSEvent
+	for {
+		sample, err := t.reader.Read()
+		if err != nil {
+			if errors.Is(err, os.ErrClosed) {
+				return
+			}
+			t.counters.ReadError()
+			continue
+		}
+
+		if err := event.UnmarshalBinary(sample); err != nil {
+			t.counters.DecodeError()
+			continue
+		}
+		msg, err := parseMessage(&event)
+		if err != nil {
+			t.counters.DecodeError()
+			continue
+		}
+		t.counters.Received()
+		t.messages.Publish(msg)
+	}
+}
@@ -0,0 +1,154 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build arm64be || armbe || mips || mips64 || mips64p32 || ppc64 || s390 || s390x || sparc || sparc64
+
+package enforce
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type bpfPolicyKey struct {
+	Prefixlen uint32
+	Protocol  uint8
+	IpVersion uint8
+	Port      uint16
+	Addr      [16]uint8
+}
+
+type bpfPolicyRule struct {
+	Id     uint32
+	Action uint8
+	Pad    [3]uint8
+}
+
+// loadBpf returns the embedded CollectionSpec for bpf.
+func loadBpf() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_BpfBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load bpf: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadBpfObjects loads bpf and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*bpfObjects
+//	*bpfPrograms
+//	*bpfMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadBpfObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadBpf()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// bpfSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type bpfSpecs struct {
+	bpfProgramSpecs
+	bpfMapSpecs
+}
+
+// bpfSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type bpfProgramSpecs struct {
+	EnforceConnect4 *ebpf.ProgramSpec `ebpf:"enforce_connect4"`
+	EnforceConnect6 *ebpf.ProgramSpec `ebpf:"enforce_connect6"`
+	EnforceSendmsg4 *ebpf.ProgramSpec `ebpf:"enforce_sendmsg4"`
+	EnforceSendmsg6 *ebpf.ProgramSpec `ebpf:"enforce_sendmsg6"`
+}
+
+// bpfMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type bpfMapSpecs struct {
+	OmEnforceDrops      *ebpf.MapSpec `ebpf:"om_enforce_drops"`
+	OmEnforceEvents     *ebpf.MapSpec `ebpf:"om_enforce_events"`
+	OmEnforceEventsPerf *ebpf.MapSpec `ebpf:"om_enforce_events_perf"`
+	OmPolicyMap         *ebpf.MapSpec `ebpf:"om_policy_map"`
+	OmPolicyProcs       *ebpf.MapSpec `ebpf:"om_policy_procs"`
+}
+
+// bpfObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
+type bpfObjects struct {
+	bpfPrograms
+	bpfMaps
+}
+
+func (o *bpfObjects) Close() error {
+	return _BpfClose(
+		&o.bpfPrograms,
+		&o.bpfMaps,
+	)
+}
+
+// bpfMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
+type bpfMaps struct {
+	OmEnforceDrops      *ebpf.Map `ebpf:"om_enforce_drops"`
+	OmEnforceEvents     *ebpf.Map `ebpf:"om_enforce_events"`
+	OmEnforceEventsPerf *ebpf.Map `ebpf:"om_enforce_events_perf"`
+	OmPolicyMap         *ebpf.Map `ebpf:"om_policy_map"`
+	OmPolicyProcs       *ebpf.Map `ebpf:"om_policy_procs"`
+}
+
+func (m *bpfMaps) Close() error {
+	return _BpfClose(
+		m.OmEnforceDrops,
+		m.OmEnforceEvents,
+		m.OmEnforceEventsPerf,
+		m.OmPolicyMap,
+		m.OmPolicyProcs,
+	)
+}
+
+// bpfPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
+type bpfPrograms struct {
+	EnforceConnect4 *ebpf.Program `ebpf:"enforce_connect4"`
+	EnforceConnect6 *ebpf.Program `ebpf:"enforce_connect6"`
+	EnforceSendmsg4 *ebpf.Program `ebpf:"enforce_sendmsg4"`
+	EnforceSendmsg6 *ebpf.Program `ebpf:"enforce_sendmsg6"`
+}
+
+func (p *bpfPrograms) Close() error {
+	return _BpfClose(
+		p.EnforceConnect4,
+		p.EnforceConnect6,
+		p.EnforceSendmsg4,
+		p.EnforceSendmsg6,
+	)
+}
+
+func _BpfClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed bpf_bpfeb.o
+var _BpfBytes []byte
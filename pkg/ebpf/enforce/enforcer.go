@@ -0,0 +1,258 @@
+package enforce
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"sync"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+
+	ebpfapi "github.com/lonelysadness/OpenMonitor/pkg/ebpf"
+	"github.com/lonelysadness/OpenMonitor/pkg/rules"
+)
+
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cc clang -cflags "-O2 -g -Wall -Werror" bpf ../programs/enforce.c
+
+// Bits of the policy key before the address, KEY_HEADER_BITS in enforce.c.
+const keyHeaderBits = 32
+
+// IPv4 rules are also installed as IPv4-mapped IPv6 prefixes, dual stack
+// sockets connect to those.
+const mappedPrefixBits = 96
+
+// Enforcer rejects outbound connects and sends in the kernel with cgroup
+// sock_addr programs, according to the rules of the rule engine. The caller
+// gets EPERM before a single packet is sent.
+type Enforcer struct {
+	cgroupPath string
+	coll       *ebpfapi.Collection
+	reader     *ebpfapi.EventReader
+	done       chan struct{}
+	counters   *ebpfapi.RingBufferCounters
+	events     *ebpfapi.Broadcaster[*ebpfapi.BlockEvent]
+
+	// Serializes updates of the policy maps and guards rules.
+	lock  sync.Mutex
+	rules []rules.Rule
+}
+
+// New returns an enforcer for the cgroup at cgroupPath. Rules can be
+// applied before it is started.
+func New(cgroupPath string) *Enforcer {
+	return &Enforcer{
+		cgroupPath: cgroupPath,
+		counters:   ebpfapi.NewRingBufferCounters("enforce"),
+		events:     ebpfapi.NewBroadcaster[*ebpfapi.BlockEvent](),
+	}
+}
+
+// Spec returns the compiled object of the enforcer.
+func (e *Enforcer) Spec() (*ebpf.CollectionSpec, error) {
+	return ebpfapi.LoadObject(loadBpf)
+}
+
+// Start attaches the enforcement programs and installs the applied rules.
+// With pinning, the rules of the previous run stay in effect until then.
+func (e *Enforcer) Start(env *ebpfapi.Env) ([]string, error) {
+	spec, err := e.Spec()
+	if err != nil {
+		return nil, err
+	}
+	coll, err := env.LoadCollection(spec)
+	if err != nil {
+		return nil, err
+	}
+	e.lock.Lock()
+	e.coll = coll
+	e.lock.Unlock()
+	if !coll.Features().CgroupSockAddr {
+		return nil, fmt.Errorf("cgroup sock_addr programs: %w", ebpf.ErrNotSupported)
+	}
+	e.counters.SetDropMap(coll.Maps["om_enforce_drops"])
+
+	hooks := []struct {
+		name   string
+		attach ebpf.AttachType
+	}{
+		{"enforce_connect4", ebpf.AttachCGroupInet4Connect},
+		{"enforce_connect6", ebpf.AttachCGroupInet6Connect},
+		{"enforce_sendmsg4", ebpf.AttachCGroupUDP4Sendmsg},
+		{"enforce_sendmsg6", ebpf.AttachCGroupUDP6Sendmsg},
+	}
+	for _, hook := range hooks {
+		err := coll.Attach(hook.name, func(prog *ebpf.Program) (link.Link, error) {
+			return link.AttachCgroup(link.CgroupOptions{
+				Path:    e.cgroupPath,
+				Attach:  hook.attach,
+				Program: prog,
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	e.lock.Lock()
+	err = e.install()
+	e.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if e.reader, err = ebpfapi.NewEventReader(coll, "om_enforce_events"); err != nil {
+		return nil, err
+	}
+	e.done = make(chan struct{})
+	go e.readEvents()
+	return nil, nil
+}
+
+// Stop releases the programs. With pinning their links stay attached for the
+// next start, nothing is blocked in the kernel only once the manager
+// disables the enforcer and unpins them.
+func (e *Enforcer) Stop() error {
+	if e.reader != nil {
+		e.reader.Close()
+		<-e.done
+		e.reader = nil
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.coll != nil {
+		e.counters.SetDropMap(nil)
+		e.coll.Close()
+		e.coll = nil
+	}
+	return nil
+}
+
+// Apply replaces the rules in the kernel with the given rule set. The rules
+// are kept and installed again whenever the enforcer starts.
+func (e *Enforcer) Apply(ruleSet []rules.Rule) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.rules = ruleSet
+	if e.coll == nil {
+		return nil
+	}
+	return e.install()
+}
+
+// install makes the policy maps match the rules. Called with the lock held.
+func (e *Enforcer) install() error {
+	destinations := make(map[bpfPolicyKey]bpfPolicyRule)
+	procs := make(map[[16]uint8]bpfPolicyRule)
+	for _, rule := range e.rules {
+		value := bpfPolicyRule{Id: rule.ID, Action: uint8(rule.Action)}
+		if rule.Process != "" {
+			var comm [16]uint8
+			copy(comm[:], rule.Process)
+			procs[comm] = value
+			continue
+		}
+
+		destinations[policyKey(rule, rule.Network)] = value
+		if rule.Network.Addr().Is4() {
+			mapped := netip.PrefixFrom(netip.AddrFrom16(rule.Network.Addr().As16()), mappedPrefixBits+rule.Network.Bits())
+			destinations[policyKey(rule, mapped)] = value
+		}
+	}
+
+	if err := syncMap(e.coll.Maps["om_policy_map"], destinations); err != nil {
+		return fmt.Errorf("failed to update destination rules: %w", err)
+	}
+	if err := syncMap(e.coll.Maps["om_policy_procs"], procs); err != nil {
+		return fmt.Errorf("failed to update process rules: %w", err)
+	}
+	return nil
+}
+
+// policyKey returns the key of a destination rule for the given prefix.
+func policyKey(rule rules.Rule, prefix netip.Prefix) bpfPolicyKey {
+	key := bpfPolicyKey{
+		Prefixlen: uint32(keyHeaderBits + prefix.Bits()),
+		Protocol:  uint8(rule.Protocol),
+		IpVersion: 4,
+	}
+	// The port is matched in network byte order, the key is compared byte by
+	// byte.
+	var port [2]byte
+	binary.BigEndian.PutUint16(port[:], rule.Port)
+	key.Port = binary.NativeEndian.Uint16(port[:])
+
+	if prefix.Addr().Is6() {
+		key.IpVersion = 6
+		key.Addr = prefix.Addr().As16()
+	} else {
+		addr := prefix.Addr().As4()
+		copy(key.Addr[:], addr[:])
+	}
+	return key
+}
+
+// syncMap makes the map contain exactly the given entries.
+func syncMap[K comparable, V any](m *ebpf.Map, want map[K]V) error {
+	var stale []K
+	var key K
+	var value V
+	iter := m.Iterate()
+	for iter.Next(&key, &value) {
+		if _, ok := want[key]; !ok {
+			stale = append(stale, key)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	for _, k := range stale {
+		if err := m.Delete(k); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return err
+		}
+	}
+	for k, v := range want {
+		if err := m.Put(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Subscribe returns a channel that receives every blocked connection after
+// the call and a function that cancels the subscription.
+func (e *Enforcer) Subscribe(size int) (<-chan *ebpfapi.BlockEvent, func()) {
+	return e.events.Subscribe(size)
+}
+
+// Counters returns the event and drop counters of the enforcer.
+func (e *Enforcer) Counters() *ebpfapi.RingBufferCounters {
+	return e.counters
+}
+
+func (e *Enforcer) readEvents() {
+	defer close(e.done)
+	for {
+		sample, err := e.reader.Read()
+		if err != nil {
+			if errors.Is(err, os.ErrClosed) {
+				return
+			}
+			e.counters.ReadError()
+			continue
+		}
+
+		event := new(ebpfapi.BlockEvent)
+		if err := event.UnmarshalBinary(sample); err != nil {
+			e.counters.DecodeError()
+			continue
+		}
+		e.counters.Received()
+		e.events.Publish(event)
+	}
+}
@@ -0,0 +1,72 @@
+package ebpf
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/cilium/ebpf/perf"
+	"github.com/cilium/ebpf/ringbuf"
+)
+
+// EventReader reads an event stream declared with EVENT_OUTPUT_MAPS, from the
+// ring buffer or from the perf event array, whichever the collection uses.
+type EventReader struct {
+	ring       *ringbuf.Reader
+	ringRecord ringbuf.Record
+	perf       *perf.Reader
+	perfRecord perf.Record
+}
+
+// NewEventReader opens the event stream name of the collection. The maps
+// are looked up in the collection, so call it before assigning them.
+func NewEventReader(c *Collection, name string) (*EventReader, error) {
+	if c.features.RingBuf {
+		rd, err := ringbuf.NewReader(c.Maps[name])
+		if err != nil {
+			return nil, fmt.Errorf("failed to create ring buffer reader: %w", err)
+		}
+		return &EventReader{ring: rd}, nil
+	}
+
+	// Spread the size of the ring buffer over the CPUs.
+	perCPU := os.Getpagesize()
+	if size := int(c.spec.Maps[name].MaxEntries) / runtime.NumCPU(); size > perCPU {
+		perCPU = size
+	}
+	rd, err := perf.NewReader(c.Maps[name+"_perf"], perCPU)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create perf event reader: %w", err)
+	}
+	return &EventReader{perf: rd}, nil
+}
+
+// Read blocks until the next event arrives and returns it. The returned
+// slice is only valid until the next call. Returns os.ErrClosed once the
+// reader is closed.
+func (r *EventReader) Read() ([]byte, error) {
+	if r.ring != nil {
+		if err := r.ring.ReadInto(&r.ringRecord); err != nil {
+			return nil, err
+		}
+		return r.ringRecord.RawSample, nil
+	}
+
+	for {
+		if err := r.perf.ReadInto(&r.perfRecord); err != nil {
+			return nil, err
+		}
+		// Lost samples were counted by the program already.
+		if r.perfRecord.LostSamples == 0 {
+			return r.perfRecord.RawSample, nil
+		}
+	}
+}
+
+// Close makes pending and future reads return os.ErrClosed.
+func (r *EventReader) Close() error {
+	if r.ring != nil {
+		return r.ring.Close()
+	}
+	return r.perf.Close()
+}
@@ -12,6 +12,23 @@ import (
 	"github.com/cilium/ebpf"
 )
 
+type bpfexecEventT struct {
+	Hdr struct {
+		Type   uint32
+		Pid    uint32
+		Ppid   uint32
+		Retval int32
+	}
+	Uid     uint32
+	Gid     uint32
+	Comm    [16]uint8
+	Argc    uint16
+	DataLen uint16
+	Flags   uint8
+	Pad     [3]uint8
+	Data    [9216]uint8
+}
+
 // loadBpfexec returns the embedded CollectionSpec for bpfexec.
 func loadBpfexec() (*ebpf.CollectionSpec, error) {
 	reader := bytes.NewReader(_BpfexecBytes)
@@ -53,14 +70,21 @@ type bpfexecSpecs struct {
 //
 // It can be passed ebpf.CollectionSpec.Assign.
 type bpfexecProgramSpecs struct {
-	EnterExecve *ebpf.ProgramSpec `ebpf:"enter_execve"`
+	EnterExecve      *ebpf.ProgramSpec `ebpf:"enter_execve"`
+	EnterExecveat    *ebpf.ProgramSpec `ebpf:"enter_execveat"`
+	ExitExecve       *ebpf.ProgramSpec `ebpf:"exit_execve"`
+	ExitExecveat     *ebpf.ProgramSpec `ebpf:"exit_execveat"`
+	SchedProcessExit *ebpf.ProgramSpec `ebpf:"sched_process_exit"`
 }
 
 // bpfexecMapSpecs contains maps before they are loaded into the kernel.
 //
 // It can be passed ebpf.CollectionSpec.Assign.
 type bpfexecMapSpecs struct {
-	OmExecMap *ebpf.MapSpec `ebpf:"om_exec_map"`
+	OmExecDrops   *ebpf.MapSpec `ebpf:"om_exec_drops"`
+	OmExecMap     *ebpf.MapSpec `ebpf:"om_exec_map"`
+	OmExecMapPerf *ebpf.MapSpec `ebpf:"om_exec_map_perf"`
+	OmExecScratch *ebpf.MapSpec `ebpf:"om_exec_scratch"`
 }
 
 // bpfexecObjects contains all objects after they have been loaded into the kernel.
@@ -82,12 +106,18 @@ func (o *bpfexecObjects) Close() error {
 //
 // It can be passed to loadBpfexecObjects or ebpf.CollectionSpec.LoadAndAssign.
 type bpfexecMaps struct {
-	OmExecMap *ebpf.Map `ebpf:"om_exec_map"`
+	OmExecDrops   *ebpf.Map `ebpf:"om_exec_drops"`
+	OmExecMap     *ebpf.Map `ebpf:"om_exec_map"`
+	OmExecMapPerf *ebpf.Map `ebpf:"om_exec_map_perf"`
+	OmExecScratch *ebpf.Map `ebpf:"om_exec_scratch"`
 }
 
 func (m *bpfexecMaps) Close() error {
 	return _BpfexecClose(
+		m.OmExecDrops,
 		m.OmExecMap,
+		m.OmExecMapPerf,
+		m.OmExecScratch,
 	)
 }
 
@@ -95,12 +125,20 @@ func (m *bpfexecMaps) Close() error {
 //
 // It can be passed to loadBpfexecObjects or ebpf.CollectionSpec.LoadAndAssign.
 type bpfexecPrograms struct {
-	EnterExecve *ebpf.Program `ebpf:"enter_execve"`
+	EnterExecve      *ebpf.Program `ebpf:"enter_execve"`
+	EnterExecveat    *ebpf.Program `ebpf:"enter_execveat"`
+	ExitExecve       *ebpf.Program `ebpf:"exit_execve"`
+	ExitExecveat     *ebpf.Program `ebpf:"exit_execveat"`
+	SchedProcessExit *ebpf.Program `ebpf:"sched_process_exit"`
 }
 
 func (p *bpfexecPrograms) Close() error {
 	return _BpfexecClose(
 		p.EnterExecve,
+		p.EnterExecveat,
+		p.ExitExecve,
+		p.ExitExecveat,
+		p.SchedProcessExit,
 	)
 }
 
@@ -1,89 +1,124 @@
 package exec
 
 import (
-	"bytes"
-	"encoding/binary"
 	"errors"
-	"fmt"
+	"os"
 
+	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/link"
-	"github.com/cilium/ebpf/ringbuf"
-	"github.com/cilium/ebpf/rlimit"
-	"github.com/lonelysadness/OpenMonitor/pkg/ebpf"
+
+	ebpfapi "github.com/lonelysadness/OpenMonitor/pkg/ebpf"
 )
 
 //go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cc clang -cflags "-O2 -g -Wall -Werror" bpfexec ../programs/exec.c
 
+// Tracer reports exec and exit of processes. It is a component of the
+// eBPF manager.
 type Tracer struct {
-	objs     bpfexecObjects
-	link     link.Link
-	reader   *ringbuf.Reader
-	events   chan *ebpf.ExecEvent
-	stopChan chan struct{}
+	coll     *ebpfapi.Collection
+	reader   *ebpfapi.EventReader
+	done     chan struct{}
+	counters *ebpfapi.RingBufferCounters
+	events   *ebpfapi.Broadcaster[*ebpfapi.ExecEvent]
 }
 
-func New() (*Tracer, error) {
-	if err := rlimit.RemoveMemlock(); err != nil {
-		return nil, fmt.Errorf("failed to remove memory lock: %w", err)
+func New() *Tracer {
+	return &Tracer{
+		counters: ebpfapi.NewRingBufferCounters("exec"),
+		events:   ebpfapi.NewBroadcaster[*ebpfapi.ExecEvent](),
 	}
+}
+
+// Spec returns the compiled object of the tracer.
+func (t *Tracer) Spec() (*ebpf.CollectionSpec, error) {
+	return ebpfapi.LoadObject(loadBpfexec)
+}
 
-	t := &Tracer{
-		events:   make(chan *ebpf.ExecEvent),
-		stopChan: make(chan struct{}),
+// Start loads and attaches the tracer.
+func (t *Tracer) Start(env *ebpfapi.Env) ([]string, error) {
+	spec, err := t.Spec()
+	if err != nil {
+		return nil, err
+	}
+	if t.coll, err = env.LoadCollection(spec); err != nil {
+		return nil, err
 	}
+	t.counters.SetDropMap(t.coll.Maps["om_exec_drops"])
 
-	if err := loadBpfexecObjects(&t.objs, nil); err != nil {
-		return nil, fmt.Errorf("failed to load BPF objects: %w", err)
+	tracepoints := []struct {
+		group string
+		name  string
+		prog  string
+	}{
+		{"syscalls", "sys_enter_execve", "enter_execve"},
+		{"syscalls", "sys_enter_execveat", "enter_execveat"},
+		{"syscalls", "sys_exit_execve", "exit_execve"},
+		{"syscalls", "sys_exit_execveat", "exit_execveat"},
+		{"sched", "sched_process_exit", "sched_process_exit"},
+	}
+	for _, tp := range tracepoints {
+		err := t.coll.Attach(tp.prog, func(prog *ebpf.Program) (link.Link, error) {
+			return link.Tracepoint(tp.group, tp.name, prog, nil)
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	tp, err := link.Tracepoint("syscalls", "sys_enter_execve", t.objs.EnterExecve, nil)
-	if err != nil {
-		t.Close()
-		return nil, fmt.Errorf("failed to create tracepoint: %w", err)
+	if t.reader, err = ebpfapi.NewEventReader(t.coll, "om_exec_map"); err != nil {
+		return nil, err
 	}
-	t.link = tp
+	t.done = make(chan struct{})
+	go t.readEvents()
+	return nil, nil
+}
 
-	reader, err := ringbuf.NewReader(t.objs.OmExecMap)
-	if err != nil {
-		t.Close()
-		return nil, fmt.Errorf("failed to create ring buffer reader: %w", err)
+// Stop detaches the tracer. Subscriptions stay valid.
+func (t *Tracer) Stop() error {
+	if t.reader != nil {
+		t.reader.Close()
+		<-t.done
+		t.reader = nil
+	}
+	if t.coll != nil {
+		t.counters.SetDropMap(nil)
+		t.coll.Close()
+		t.coll = nil
 	}
-	t.reader = reader
+	return nil
+}
 
-	go t.readEvents()
-	return t, nil
+// Subscribe returns a channel that receives every event read after the call
+// and a function that cancels the subscription and closes the channel.
+// Events are dropped for subscribers that don't keep up, so a slow consumer
+// never stalls the ring buffer.
+func (t *Tracer) Subscribe(size int) (<-chan *ebpfapi.ExecEvent, func()) {
+	return t.events.Subscribe(size)
+}
+
+// Counters returns the event and drop counters of the tracer.
+func (t *Tracer) Counters() *ebpfapi.RingBufferCounters {
+	return t.counters
 }
 
 func (t *Tracer) readEvents() {
+	defer close(t.done)
 	for {
-		record, err := t.reader.Read()
+		sample, err := t.reader.Read()
 		if err != nil {
-			if errors.Is(err, ringbuf.ErrClosed) {
+			if errors.Is(err, os.ErrClosed) {
 				return
 			}
+			t.counters.ReadError()
 			continue
 		}
 
-		var event ebpf.ExecEvent
-		if err := binary.Read(bytes.NewBuffer(record.RawSample), binary.LittleEndian, &event); err != nil {
+		event := new(ebpfapi.ExecEvent)
+		if err := event.UnmarshalBinary(sample); err != nil {
+			t.counters.DecodeError()
 			continue
 		}
-
-		select {
-		case t.events <- &event:
-		case <-t.stopChan:
-			return
-		}
-	}
-}
-
-func (t *Tracer) Close() error {
-	close(t.stopChan)
-	if t.reader != nil {
-		t.reader.Close()
-	}
-	if t.link != nil {
-		t.link.Close()
+		t.counters.Received()
+		t.events.Publish(event)
 	}
-	return t.objs.Close()
 }
@@ -0,0 +1,117 @@
+package ebpf
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/features"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+// Features are the kernel capabilities the eBPF programs depend on. Missing
+// ones are worked around where possible, see Degraded.
+type Features struct {
+	BTF            bool // kernel BTF for CO-RE relocations, needed by all tracing programs
+	Fentry         bool // fentry/fexit programs, kprobes are used otherwise
+	RingBuf        bool // BPF ring buffers, perf event arrays are used otherwise
+	SockOps        bool // sockops programs for TCP bandwidth and health
+	CgroupSockAddr bool // cgroup connect and sendmsg hooks for enforcement
+}
+
+var (
+	probeOnce sync.Once
+	probed    Features
+)
+
+// ProbeFeatures checks what the running kernel supports. The kernel is only
+// asked once, later calls return the same result.
+func ProbeFeatures() Features {
+	probeOnce.Do(func() {
+		// Kernels before 5.11 charge eBPF objects to RLIMIT_MEMLOCK, the
+		// probes would fail with the default limit. NewManager reports
+		// the error.
+		_ = rlimit.RemoveMemlock()
+
+		_, err := btf.LoadKernelSpec()
+		probed.BTF = err == nil
+		probed.Fentry = probed.BTF && haveFentry()
+		probed.RingBuf = features.HaveMapType(ebpf.RingBuf) == nil
+		probed.SockOps = features.HaveProgramType(ebpf.SockOps) == nil
+		probed.CgroupSockAddr = features.HaveProgramType(ebpf.CGroupSockAddr) == nil
+	})
+	return probed
+}
+
+// haveFentry loads and attaches an empty fentry program. Some architectures
+// load tracing programs but can't attach them, so loading alone is not
+// enough.
+func haveFentry() bool {
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:       ebpf.Tracing,
+		AttachType: ebpf.AttachTraceFEntry,
+		AttachTo:   "tcp_connect",
+		License:    "GPL",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		return false
+	}
+	defer prog.Close()
+
+	l, err := link.AttachTracing(link.TracingOptions{Program: prog})
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+// takesNoblock returns whether the kernel function fn still has the noblock
+// argument, nonblock in tcp_recvmsg, which recvmsg functions lost in 5.19.
+func takesNoblock(fn string) bool {
+	spec, err := btf.LoadKernelSpec()
+	if err != nil {
+		return false
+	}
+	var f *btf.Func
+	if err := spec.TypeByName(fn, &f); err != nil {
+		return false
+	}
+	proto, ok := f.Type.(*btf.FuncProto)
+	if !ok {
+		return false
+	}
+	for _, param := range proto.Params {
+		if strings.HasSuffix(param.Name, "noblock") {
+			return true
+		}
+	}
+	return false
+}
+
+// Degraded describes every missing feature and what it costs.
+func (f Features) Degraded() []string {
+	var degraded []string
+	if !f.BTF {
+		degraded = append(degraded, "no kernel BTF: connection, DNS, listener and UDP bandwidth tracing unavailable")
+	} else if !f.Fentry {
+		degraded = append(degraded, "no fentry support: using kprobes")
+	}
+	if !f.RingBuf {
+		degraded = append(degraded, "no ring buffers: using perf event arrays, events are copied once more")
+	}
+	if !f.SockOps {
+		degraded = append(degraded, "no sockops support: TCP bandwidth and health unavailable")
+	}
+	if !f.CgroupSockAddr {
+		degraded = append(degraded, "no cgroup sock_addr hooks: in-kernel enforcement unavailable")
+	}
+	return degraded
+}
@@ -0,0 +1,43 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"net/netip"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/flow"
+)
+
+// Directions of ConnectionEvent.Direction, as in monitor.c.
+const (
+	DirectionOutbound uint8 = 0
+	DirectionInbound  uint8 = 1
+)
+
+// Flow returns the connection of the event. monitor.c stores the address
+// words in host byte order and the PID and ports in network byte order.
+func (e *ConnectionEvent) Flow() flow.Flow {
+	direction := flow.Outbound
+	if e.Direction == DirectionInbound {
+		direction = flow.Inbound
+	}
+
+	f := flow.New(flow.Protocol(e.Protocol), direction,
+		netip.AddrPortFrom(eventAddr(e.SrcAddr, e.IPVersion), bits.ReverseBytes16(e.SrcPort)),
+		netip.AddrPortFrom(eventAddr(e.DstAddr, e.IPVersion), bits.ReverseBytes16(e.DstPort)))
+	f.PID = bits.ReverseBytes32(e.PID)
+	return f
+}
+
+func eventAddr(words [4]uint32, ipVersion uint8) netip.Addr {
+	if ipVersion == 4 {
+		var ip [4]byte
+		binary.BigEndian.PutUint32(ip[:], words[0])
+		return netip.AddrFrom4(ip)
+	}
+	var ip [16]byte
+	for i, word := range words {
+		binary.BigEndian.PutUint32(ip[i*4:], word)
+	}
+	return netip.AddrFrom16(ip)
+}
@@ -0,0 +1,284 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/link"
+)
+
+// ErrStaleObject is returned for compiled objects that are empty or don't
+// have the programs their Go code attaches, because they were not
+// regenerated after the C source changed. Starting again won't help.
+var ErrStaleObject = errors.New("compiled eBPF object is missing or out of date, run go generate")
+
+// LoadObject returns the spec of an object embedded by bpf2go, load is the
+// generated loadBpf function.
+func LoadObject(load func() (*ebpf.CollectionSpec, error)) (*ebpf.CollectionSpec, error) {
+	spec, err := load()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStaleObject, err)
+	}
+	return spec, nil
+}
+
+// Suffixes of the kprobe equivalents of fentry and fexit programs. The
+// program tcp_connect falls back to tcp_connect_kprobe, an fexit program may
+// need both.
+var fallbackSuffixes = []string{"_kprobe", "_kretprobe"}
+
+// Suffix of fexit programs for the prototype of recvmsg functions before
+// 5.19, which had a noblock argument. The program udp_recvmsg is replaced by
+// udp_recvmsg_noblock on those kernels.
+const noblockSuffix = "_noblock"
+
+// Collection is a collection loaded for the features of the running kernel.
+// Only the fentry programs or their kprobe equivalents are loaded, and event
+// streams declared with EVENT_OUTPUT_MAPS use the ring buffer or the perf
+// event array.
+type Collection struct {
+	*ebpf.Collection
+	spec     *ebpf.CollectionSpec
+	pins     *Pins
+	features Features
+	links    []link.Link
+}
+
+// LoadCollection loads the programs and maps of spec that fit the kernel,
+// with the map sizes overridden by sizes. Maps are pinned as described for
+// Pins. Close releases everything.
+func (p *Pins) LoadCollection(spec *ebpf.CollectionSpec, sizes MapSizes) (*Collection, error) {
+	spec = spec.Copy()
+	if err := sizes.apply(spec); err != nil {
+		return nil, err
+	}
+
+	c := &Collection{
+		spec:     spec,
+		pins:     p,
+		features: ProbeFeatures(),
+	}
+
+	prepared, err := c.prepare()
+	if err != nil {
+		return nil, err
+	}
+	coll, err := p.newCollection(prepared)
+	if err != nil {
+		return nil, err
+	}
+	c.Collection = coll
+	return c, nil
+}
+
+// prepare returns a copy of the spec with the programs and maps the kernel
+// doesn't support removed or replaced.
+func (c *Collection) prepare() (*ebpf.CollectionSpec, error) {
+	spec := c.spec.Copy()
+
+	// Objects without EVENT_OUTPUT_MAPS have a fixed event output
+	selectable := declaresConstant(spec, "use_ringbuf")
+	if selectable {
+		if err := spec.RewriteConstants(map[string]interface{}{
+			"use_ringbuf": c.features.RingBuf,
+		}); err != nil {
+			return nil, fmt.Errorf("failed to select event output: %w", err)
+		}
+	}
+	if selectable && !c.features.RingBuf {
+		// The programs still refer to the ring buffer, the verifier drops
+		// the branch using it. Any map will do.
+		for name, m := range spec.Maps {
+			if m.Type == ebpf.RingBuf {
+				spec.Maps[name] = &ebpf.MapSpec{
+					Name:       m.Name,
+					Type:       ebpf.Array,
+					KeySize:    4,
+					ValueSize:  4,
+					MaxEntries: 1,
+				}
+			}
+		}
+	}
+
+	for name, prog := range spec.Programs {
+		switch {
+		case prog.Type == ebpf.Tracing && !c.features.Fentry:
+			delete(spec.Programs, name)
+		case prog.Type == ebpf.Kprobe && c.features.Fentry && c.isFallback(name):
+			delete(spec.Programs, name)
+		case prog.Type == ebpf.SockOps && !c.features.SockOps,
+			prog.Type == ebpf.CGroupSockAddr && !c.features.CgroupSockAddr:
+			// The caller finds them missing in the collection.
+			delete(spec.Programs, name)
+		}
+	}
+
+	// Only the variant matching the kernel's prototype is loaded, under the
+	// name of the current one.
+	for name, prog := range spec.Programs {
+		base, ok := strings.CutSuffix(name, noblockSuffix)
+		if !ok || prog.Type != ebpf.Tracing || spec.Programs[base] == nil {
+			continue
+		}
+		if takesNoblock(prog.AttachTo) {
+			spec.Programs[base] = prog
+		}
+		delete(spec.Programs, name)
+	}
+	return spec, nil
+}
+
+// inObject returns whether the object has the program name or one of its
+// kprobe equivalents, loaded or not.
+func (c *Collection) inObject(name string) bool {
+	return c.spec.Programs[name] != nil || c.hasFallback(name)
+}
+
+// hasFallback returns whether the object has a kprobe equivalent of the
+// program name.
+func (c *Collection) hasFallback(name string) bool {
+	for _, suffix := range fallbackSuffixes {
+		if c.spec.Programs[name+suffix] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// declaresConstant returns whether the object has the volatile const name.
+func declaresConstant(spec *ebpf.CollectionSpec, name string) bool {
+	for mapName, m := range spec.Maps {
+		if !strings.HasPrefix(mapName, ".rodata") {
+			continue
+		}
+		sec, ok := m.Value.(*btf.Datasec)
+		if !ok {
+			continue
+		}
+		for _, v := range sec.Vars {
+			if v.Type.TypeName() == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isFallback returns whether the program is the kprobe equivalent of an
+// fentry or fexit program.
+func (c *Collection) isFallback(name string) bool {
+	for _, suffix := range fallbackSuffixes {
+		base, ok := strings.CutSuffix(name, suffix)
+		if !ok {
+			continue
+		}
+		if prog := c.spec.Programs[base]; prog != nil && prog.Type == ebpf.Tracing {
+			return true
+		}
+	}
+	return false
+}
+
+// Features returns the kernel features the collection was loaded for.
+func (c *Collection) Features() Features {
+	return c.features
+}
+
+// usesTracing returns whether the collection has fentry or fexit programs.
+func (c *Collection) usesTracing() bool {
+	for _, prog := range c.spec.Programs {
+		if prog.Type == ebpf.Tracing {
+			return true
+		}
+	}
+	return false
+}
+
+// usesRingBuf returns whether the collection has ring buffers.
+func (c *Collection) usesRingBuf() bool {
+	for _, m := range c.spec.Maps {
+		if m.Type == ebpf.RingBuf {
+			return true
+		}
+	}
+	return false
+}
+
+// Attach attaches the program name with the attach function, see
+// Pins.Attach. The link is closed with the collection.
+func (c *Collection) Attach(name string, attach func(prog *ebpf.Program) (link.Link, error)) error {
+	prog := c.Programs[name]
+	if prog == nil {
+		if c.spec.Programs[name] == nil {
+			return fmt.Errorf("program %s: %w", name, ErrStaleObject)
+		}
+		return fmt.Errorf("program %s is not loaded", name)
+	}
+	l, err := c.pins.Attach(name, prog, func() (link.Link, error) {
+		return attach(prog)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to attach %s: %w", name, err)
+	}
+	c.links = append(c.links, l)
+	return nil
+}
+
+// AttachTracing attaches the fentry or fexit program name, or its kprobe
+// equivalents if the kernel lacks fentry support. The links are closed with
+// the collection.
+func (c *Collection) AttachTracing(name string) error {
+	if c.Programs[name] != nil {
+		return c.Attach(name, func(prog *ebpf.Program) (link.Link, error) {
+			return link.AttachTracing(link.TracingOptions{Program: prog})
+		})
+	}
+
+	attached := false
+	for _, suffix := range fallbackSuffixes {
+		fallback := name + suffix
+		if c.Programs[fallback] == nil {
+			continue
+		}
+		spec := c.spec.Programs[fallback]
+		err := c.Attach(fallback, func(prog *ebpf.Program) (link.Link, error) {
+			if strings.HasPrefix(spec.SectionName, "kretprobe/") {
+				return link.Kretprobe(spec.AttachTo, prog, nil)
+			}
+			return link.Kprobe(spec.AttachTo, prog, nil)
+		})
+		if err != nil {
+			return err
+		}
+		attached = true
+	}
+	if !attached {
+		if !c.inObject(name) {
+			return fmt.Errorf("program %s: %w", name, ErrStaleObject)
+		}
+		if !c.features.Fentry && !c.hasFallback(name) {
+			// Objects shared by architectures without one of their own
+			// leave the kprobe programs out, see programs/kprobe.h.
+			return fmt.Errorf("program %s: no fentry support and no kprobe equivalent on %s: %w",
+				name, runtime.GOARCH, ebpf.ErrNotSupported)
+		}
+		return fmt.Errorf("program %s is not loaded", name)
+	}
+	return nil
+}
+
+// Close detaches all links and releases programs and maps. Pinned objects
+// stay in the kernel, pinned links keep their hooks attached until
+// Pins.UnpinLinks.
+func (c *Collection) Close() {
+	for _, l := range c.links {
+		l.Close()
+	}
+	c.links = nil
+	c.Collection.Close()
+}
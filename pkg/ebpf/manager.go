@@ -0,0 +1,366 @@
+package ebpf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+// Component is a group of eBPF programs the Manager loads, attaches and
+// closes together. A component is started and stopped any number of times,
+// what it hands out to consumers (channels, subscriptions, counters) must
+// stay valid in between.
+type Component interface {
+	// Start loads and attaches the programs and starts reading their
+	// events. It returns a description of everything that works with
+	// reduced functionality.
+	Start(env *Env) (degraded []string, err error)
+
+	// Stop detaches the programs and releases their maps. Pinned objects
+	// stay in the kernel, so a restart takes over the hooks; the manager
+	// unpins the links of disabled components.
+	Stop() error
+
+	// Spec returns the compiled object of the component without loading
+	// it, for checking map sizes before anything starts.
+	Spec() (*ebpf.CollectionSpec, error)
+}
+
+// State is the lifecycle state of a component.
+type State uint8
+
+const (
+	StateDisabled State = iota // not started on purpose
+	StateStarting              // Start is running
+	StateLoaded                // programs are in the kernel, not yet attached
+	StateAttached              // everything runs
+	StateDegraded              // runs with reduced functionality
+	StateFailed                // Start failed, retried later
+)
+
+func (s State) String() string {
+	switch s {
+	case StateDisabled:
+		return "disabled"
+	case StateStarting:
+		return "starting"
+	case StateLoaded:
+		return "loaded"
+	case StateAttached:
+		return "attached"
+	case StateDegraded:
+		return "degraded"
+	case StateFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(s))
+	}
+}
+
+// Status is the current state of a component.
+type Status struct {
+	Name     string
+	State    State
+	Since    time.Time
+	Degraded []string // what works with reduced functionality
+	Err      error    // why the last start failed
+	Failures int      // failed starts in a row
+}
+
+// Failed starts are retried with exponential backoff up to maxRetryDelay.
+const (
+	minRetryDelay = 5 * time.Second
+	maxRetryDelay = 5 * time.Minute
+)
+
+// Manager owns all eBPF components. It starts the enabled ones, retries
+// those that fail, reports their status and stops them in reverse order.
+type Manager struct {
+	pins  *Pins
+	sizes MapSizes
+
+	lock       sync.Mutex
+	components []*managed
+	ctx        context.Context
+}
+
+type managed struct {
+	name      string
+	component Component
+	enabled   bool
+	running   bool
+	status    Status
+	retry     *time.Timer
+}
+
+// NewManager prepares the process for loading eBPF programs. pins may be nil
+// to disable pinning, sizes may be nil to keep the default map sizes.
+func NewManager(pins *Pins, sizes MapSizes) (*Manager, error) {
+	// Allow the current process to lock memory for eBPF resources
+	if err := rlimit.RemoveMemlock(); err != nil {
+		return nil, fmt.Errorf("failed to remove memory lock: %w", err)
+	}
+	return &Manager{pins: pins, sizes: sizes}, nil
+}
+
+// CheckMapSizes returns an error for map sizes given for maps that no
+// registered component has, enabled or not. Objects that can't be read fail
+// their component's start and say so there, names are not checked then.
+func (m *Manager) CheckMapSizes() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	known := make(map[string]bool)
+	for _, c := range m.components {
+		spec, err := c.component.Spec()
+		if err != nil {
+			return nil
+		}
+		for name := range spec.Maps {
+			known[name] = true
+		}
+	}
+	var unknown []string
+	for name := range m.sizes {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("no eBPF map named %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// Register adds a component. Its maps and links are pinned under its name.
+// Components are started in the order they are registered.
+func (m *Manager) Register(name string, component Component, enabled bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	state := StateDisabled
+	if enabled {
+		state = StateStarting
+	}
+	m.components = append(m.components, &managed{
+		name:      name,
+		component: component,
+		enabled:   enabled,
+		status:    Status{Name: name, State: state, Since: time.Now()},
+	})
+}
+
+// Start starts all enabled components. Failed components are retried until
+// the context is done. Hooks a previous run pinned for disabled components
+// are detached.
+func (m *Manager) Start(ctx context.Context) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.ctx = ctx
+	for _, c := range m.components {
+		if c.enabled {
+			m.start(c)
+		} else if err := m.unpinLinks(c); err != nil {
+			log.Printf("Failed to detach eBPF component %s: %v", c.name, err)
+		}
+	}
+}
+
+// start starts the component and schedules a retry if that fails. Called
+// with the lock held.
+func (m *Manager) start(c *managed) {
+	m.setState(c, StateStarting)
+
+	env := &Env{manager: m, component: c}
+	degraded, err := c.component.Start(env)
+	if err != nil {
+		if err := c.component.Stop(); err != nil {
+			log.Printf("Failed to clean up eBPF component %s: %v", c.name, err)
+		}
+		c.status.Err = err
+		c.status.Failures++
+		m.setState(c, StateFailed)
+		log.Printf("Failed to start eBPF component %s: %v", c.name, err)
+		// The kernel won't grow the missing feature and the object won't
+		// change while running, don't try again.
+		if !errors.Is(err, ebpf.ErrNotSupported) && !errors.Is(err, ErrStaleObject) {
+			m.scheduleRetry(c)
+		}
+		return
+	}
+
+	c.running = true
+	c.status.Err = nil
+	c.status.Failures = 0
+	c.status.Degraded = append(env.degraded, degraded...)
+	if len(c.status.Degraded) > 0 {
+		m.setState(c, StateDegraded)
+	} else {
+		m.setState(c, StateAttached)
+	}
+}
+
+func (m *Manager) scheduleRetry(c *managed) {
+	if m.ctx == nil || m.ctx.Err() != nil {
+		return
+	}
+
+	delay := minRetryDelay << (c.status.Failures - 1)
+	if delay > maxRetryDelay || delay <= 0 {
+		delay = maxRetryDelay
+	}
+	c.retry = time.AfterFunc(delay, func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		if c.enabled && !c.running && m.ctx.Err() == nil {
+			m.start(c)
+		}
+	})
+}
+
+// stop stops a running component. Called with the lock held.
+func (m *Manager) stop(c *managed) error {
+	if c.retry != nil {
+		c.retry.Stop()
+		c.retry = nil
+	}
+	if !c.running {
+		return nil
+	}
+	c.running = false
+	c.status.Degraded = nil
+	return c.component.Stop()
+}
+
+func (m *Manager) setState(c *managed, state State) {
+	c.status.State = state
+	c.status.Since = time.Now()
+}
+
+// Enable starts a disabled component.
+func (m *Manager) Enable(name string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	c, err := m.lookup(name)
+	if err != nil {
+		return err
+	}
+	if c.enabled {
+		return nil
+	}
+	c.enabled = true
+	if m.ctx == nil {
+		// Started with the others.
+		m.setState(c, StateStarting)
+		return nil
+	}
+	m.start(c)
+	return c.status.Err
+}
+
+// Disable stops a component and keeps it stopped. Its pinned links are
+// removed, so its hooks are detached even with pinning.
+func (m *Manager) Disable(name string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	c, err := m.lookup(name)
+	if err != nil {
+		return err
+	}
+	c.enabled = false
+	c.status.Err = nil
+	c.status.Failures = 0
+	m.setState(c, StateDisabled)
+	if err := m.stop(c); err != nil {
+		return err
+	}
+	return m.unpinLinks(c)
+}
+
+// unpinLinks removes the pinned links of a component, which must not be
+// running. Called with the lock held.
+func (m *Manager) unpinLinks(c *managed) error {
+	return m.pins.Component(c.name).UnpinLinks()
+}
+
+func (m *Manager) lookup(name string) (*managed, error) {
+	for _, c := range m.components {
+		if c.name == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown eBPF component %q", name)
+}
+
+// Status returns the status of all components in registration order.
+func (m *Manager) Status() []Status {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	status := make([]Status, 0, len(m.components))
+	for _, c := range m.components {
+		s := c.status
+		s.Degraded = append([]string(nil), s.Degraded...)
+		status = append(status, s)
+	}
+	return status
+}
+
+// Close stops all components in reverse order.
+func (m *Manager) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var errs []error
+	for i := len(m.components) - 1; i >= 0; i-- {
+		c := m.components[i]
+		if err := m.stop(c); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Env is handed to a component on start.
+type Env struct {
+	manager   *Manager
+	component *managed
+	degraded  []string
+}
+
+// Pins returns the pins of the component, nil without pinning.
+func (e *Env) Pins() *Pins {
+	return e.manager.pins.Component(e.component.name)
+}
+
+// LoadCollection loads the collection for the running kernel with the map
+// sizes of the manager. The component counts as loaded afterwards, fallbacks
+// the kernel forced are reported as degraded.
+func (e *Env) LoadCollection(spec *ebpf.CollectionSpec) (*Collection, error) {
+	coll, err := e.Pins().LoadCollection(spec, e.manager.sizes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load BPF objects: %w", err)
+	}
+	e.manager.setState(e.component, StateLoaded)
+
+	features := coll.Features()
+	if !features.Fentry && coll.usesTracing() {
+		e.degraded = append(e.degraded, "kprobes instead of fentry")
+	}
+	if !features.RingBuf && coll.usesRingBuf() {
+		e.degraded = append(e.degraded, "perf event arrays instead of ring buffers")
+	}
+	return coll, nil
+}
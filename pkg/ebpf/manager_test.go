@@ -0,0 +1,40 @@
+package ebpf
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+)
+
+// specComponent is a component that only has an object.
+type specComponent struct {
+	maps []string
+}
+
+func (c specComponent) Start(env *Env) ([]string, error) { return nil, nil }
+func (c specComponent) Stop() error                      { return nil }
+
+func (c specComponent) Spec() (*ebpf.CollectionSpec, error) {
+	spec := &ebpf.CollectionSpec{Maps: make(map[string]*ebpf.MapSpec)}
+	for _, name := range c.maps {
+		spec.Maps[name] = &ebpf.MapSpec{Name: name}
+	}
+	return spec, nil
+}
+
+func TestCheckMapSizes(t *testing.T) {
+	m := &Manager{sizes: MapSizes{"om_bandwidth_map": 20000, "om_dns_event": 1 << 22}}
+	m.Register("bandwidth", specComponent{maps: []string{"om_bandwidth_map"}}, true)
+	m.Register("dns", specComponent{maps: []string{"om_dns_events"}}, false)
+
+	err := m.CheckMapSizes()
+	if err == nil || !strings.Contains(err.Error(), "om_dns_event") {
+		t.Fatalf("CheckMapSizes() = %v, want an error naming om_dns_event", err)
+	}
+
+	m.sizes = MapSizes{"om_bandwidth_map": 20000, "om_dns_events": 1 << 22}
+	if err := m.CheckMapSizes(); err != nil {
+		t.Errorf("CheckMapSizes() = %v, want nil for maps of enabled and disabled components", err)
+	}
+}
@@ -0,0 +1,138 @@
+package ebpf
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cilium/ebpf"
+)
+
+// MapSizes overrides the max_entries of maps by name, e.g.
+// om_bandwidth_map=20000. It is a flag.Value, so every -flag name=entries
+// adds a size. Ring buffer sizes are in bytes.
+type MapSizes map[string]uint32
+
+func (s MapSizes) String() string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+"="+strconv.FormatUint(uint64(s[name]), 10))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s MapSizes) Set(value string) error {
+	name, size, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected name=entries, got %q", value)
+	}
+	entries, err := strconv.ParseUint(size, 10, 32)
+	if err != nil || entries == 0 {
+		return fmt.Errorf("invalid size %q for map %s", size, name)
+	}
+	s[name] = uint32(entries)
+	return nil
+}
+
+// apply sets the sizes of the maps of the spec that have one.
+func (s MapSizes) apply(spec *ebpf.CollectionSpec) error {
+	for name, size := range s {
+		m := spec.Maps[name]
+		if m == nil {
+			// Belongs to another collection, Manager.CheckMapSizes
+			// rejects names no collection has.
+			continue
+		}
+		if m.Type == ebpf.RingBuf && (size&(size-1) != 0 || size%uint32(os.Getpagesize()) != 0) {
+			return fmt.Errorf("size of ring buffer %s must be a power of two multiple of the page size", name)
+		}
+		m.MaxEntries = size
+	}
+	return nil
+}
+
+// MapStats is a snapshot of the fill level of an LRU map.
+type MapStats struct {
+	Name     string
+	Entries  uint32
+	Capacity uint32
+	Evicted  uint64 // entries the kernel dropped to make room, at least
+}
+
+// MapCounters tracks the fill level and evictions of an LRU map whose
+// entries are never deleted, so every entry that disappears was evicted.
+// It is safe for concurrent use.
+type MapCounters struct {
+	name     string
+	entries  atomic.Uint32
+	capacity atomic.Uint32
+	evicted  atomic.Uint64
+}
+
+func NewMapCounters(name string) *MapCounters {
+	return &MapCounters{name: name}
+}
+
+// Observe records the result of an iteration over the map.
+func (c *MapCounters) Observe(entries, capacity uint32, evicted uint64) {
+	c.entries.Store(entries)
+	c.capacity.Store(capacity)
+	c.evicted.Add(evicted)
+}
+
+// Stats returns the current counters.
+func (c *MapCounters) Stats() MapStats {
+	return MapStats{
+		Name:     c.name,
+		Entries:  c.entries.Load(),
+		Capacity: c.capacity.Load(),
+		Evicted:  c.evicted.Load(),
+	}
+}
+
+// KeyTracker finds the keys that disappeared from a map between two
+// iterations. Keys evicted and added again in between are not noticed.
+type KeyTracker[K comparable] struct {
+	prev map[K]struct{}
+	cur  map[K]struct{}
+}
+
+func NewKeyTracker[K comparable]() *KeyTracker[K] {
+	return &KeyTracker[K]{
+		prev: make(map[K]struct{}),
+		cur:  make(map[K]struct{}),
+	}
+}
+
+// Seen marks the key as present in the current iteration.
+func (t *KeyTracker[K]) Seen(key K) {
+	t.cur[key] = struct{}{}
+}
+
+// Done ends the current iteration and returns the number of keys seen and
+// the number of keys of the previous iteration that are gone.
+func (t *KeyTracker[K]) Done() (entries int, gone int) {
+	for key := range t.prev {
+		if _, ok := t.cur[key]; !ok {
+			gone++
+		}
+	}
+	entries = len(t.cur)
+	t.prev, t.cur = t.cur, t.prev
+	clear(t.cur)
+	return entries, gone
+}
+
+// Abort forgets the current iteration, e.g. because it failed half way.
+func (t *KeyTracker[K]) Abort() {
+	clear(t.cur)
+}
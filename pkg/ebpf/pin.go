@@ -0,0 +1,188 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// PinRoot is where maps and links are pinned on the BPF filesystem.
+const PinRoot = "/sys/fs/bpf/openmonitor"
+
+// PinSchemaVersion must be increased whenever a pinned map changes layout
+// in a way the kernel can't tell apart, e.g. a value struct whose fields
+// are rearranged but keep their size, or a pinned link is renamed. Pins of
+// other versions are removed on start instead of being reused.
+const PinSchemaVersion = 2
+
+// Pins keeps maps and links of a component pinned under a directory so
+// counters survive restarts and hooks stay attached in between. The links of
+// a disabled component are unpinned so its hooks go away. A nil *Pins
+// disables pinning, objects then live as long as the process.
+type Pins struct {
+	dir string
+}
+
+// OpenPins prepares the pin directory of the current schema version under
+// root and removes pins left behind by other versions.
+func OpenPins(root string) (*Pins, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read pin directory: %w", err)
+	}
+
+	current := schemaDir(PinSchemaVersion)
+	for _, entry := range entries {
+		if entry.Name() == current {
+			continue
+		}
+		if _, ok := parseSchemaDir(entry.Name()); !ok {
+			continue
+		}
+		log.Printf("Removing pinned eBPF objects of incompatible schema %s", entry.Name())
+		if err := os.RemoveAll(filepath.Join(root, entry.Name())); err != nil {
+			return nil, fmt.Errorf("failed to remove stale pins: %w", err)
+		}
+	}
+
+	dir := filepath.Join(root, current)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create pin directory: %w", err)
+	}
+	return &Pins{dir: dir}, nil
+}
+
+// RemovePins unpins everything under root. Pinned links are detached once
+// no process holds them anymore.
+func RemovePins(root string) error {
+	if err := os.RemoveAll(root); err != nil {
+		return fmt.Errorf("failed to remove pins: %w", err)
+	}
+	return nil
+}
+
+// UnpinLinks removes the links pinned by Attach. Their hooks are detached
+// once no process holds them anymore, instead of being taken over by the
+// next start.
+func (p *Pins) UnpinLinks() error {
+	if p == nil {
+		return nil
+	}
+	if err := os.RemoveAll(filepath.Join(p.dir, "links")); err != nil {
+		return fmt.Errorf("failed to unpin links: %w", err)
+	}
+	return nil
+}
+
+func schemaDir(version int) string {
+	return "v" + strconv.Itoa(version)
+}
+
+func parseSchemaDir(name string) (int, bool) {
+	if !strings.HasPrefix(name, "v") {
+		return 0, false
+	}
+	version, err := strconv.Atoi(name[1:])
+	return version, err == nil
+}
+
+// Component returns the pins of a single component, e.g. "bandwidth".
+func (p *Pins) Component(name string) *Pins {
+	if p == nil {
+		return nil
+	}
+	return &Pins{dir: filepath.Join(p.dir, name)}
+}
+
+// newCollection loads the collection. With pinning, all maps are pinned by
+// name and maps pinned by a previous run are reused. Pinned maps the spec no
+// longer fits are replaced. Global data is never pinned, it holds the
+// constants of this run.
+func (p *Pins) newCollection(spec *ebpf.CollectionSpec) (*ebpf.Collection, error) {
+	if p == nil {
+		return ebpf.NewCollection(spec)
+	}
+
+	if err := os.MkdirAll(p.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create pin directory: %w", err)
+	}
+	for name, m := range spec.Maps {
+		if !strings.HasPrefix(name, ".") {
+			m.Pinning = ebpf.PinByName
+		}
+	}
+	opts := ebpf.CollectionOptions{
+		Maps: ebpf.MapOptions{PinPath: p.dir},
+	}
+
+	coll, err := ebpf.NewCollectionWithOptions(spec, opts)
+	if !errors.Is(err, ebpf.ErrMapIncompatible) {
+		return coll, err
+	}
+
+	// The kernel tells us about differences in type, size and flags. Start
+	// over for this component rather than failing.
+	log.Printf("Pinned maps in %s are incompatible, recreating them: %v", p.dir, err)
+	if err := p.removeMaps(spec); err != nil {
+		return nil, err
+	}
+	return ebpf.NewCollectionWithOptions(spec, opts)
+}
+
+func (p *Pins) removeMaps(spec *ebpf.CollectionSpec) error {
+	for name := range spec.Maps {
+		if err := os.Remove(filepath.Join(p.dir, name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to unpin map %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Attach attaches prog with the attach function, or takes over the link
+// pinned under name by a previous run. A pinned link is switched to the new
+// program in place if the kernel supports it, so the hook is never
+// detached. Otherwise the new link is created before the old one goes away.
+func (p *Pins) Attach(name string, prog *ebpf.Program, attach func() (link.Link, error)) (link.Link, error) {
+	if p == nil {
+		return attach()
+	}
+
+	dir := filepath.Join(p.dir, "links")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create pin directory: %w", err)
+	}
+	path := filepath.Join(dir, name)
+
+	pinned, err := link.LoadPinnedLink(path, nil)
+	if err == nil {
+		if err := pinned.Update(prog); err == nil {
+			return pinned, nil
+		}
+		// Tracing and perf event links can't be updated.
+	}
+
+	l, err := attach()
+	if err != nil {
+		if pinned != nil {
+			pinned.Close()
+		}
+		return nil, err
+	}
+	if pinned != nil {
+		// Both programs ran for a moment, the old one is detached now.
+		pinned.Unpin()
+		pinned.Close()
+	}
+	if err := l.Pin(path); err != nil && !errors.Is(err, ebpf.ErrNotSupported) {
+		l.Close()
+		return nil, fmt.Errorf("failed to pin link %s: %w", name, err)
+	}
+	return l, nil
+}
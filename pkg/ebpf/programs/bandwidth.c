@@ -2,6 +2,8 @@
 #include "headers/bpf_helpers.h"
 #include "headers/bpf_tracing.h"
 #include "headers/bpf_core_read.h"
+#include "kprobe.h"
+#include "output.h"
 
 #define AF_INET 2
 #define AF_INET6 10
@@ -9,6 +11,12 @@
 #define PROTOCOL_TCP 6
 #define PROTOCOL_UDP 17
 
+// Listen event types
+#define LISTEN_START 1
+#define LISTEN_STOP  2
+
+#define COMMLEN 16
+
 char __license[] SEC("license") = "GPL";
 
 struct sk_key {
@@ -26,7 +34,17 @@ struct sk_info {
 	u64 reported;
 };
 
-// Max number of connections that will be kept. Increse the number if it's not enough.
+// TCP health of a connection, keyed like the bandwidth.
+struct tcp_health {
+	u32 srtt_us;       // smoothed RTT in usec << 3, as in tcp_sock
+	u32 total_retrans; // retransmitted segments reported by sockops
+	u32 retransmits;   // tcp_retransmit_skb events, also covers sockets without sockops callbacks
+	u32 resets;        // tcp_send_reset events
+	u32 state;         // BPF_TCP_* state
+};
+
+// Default number of connections that are kept, the loader can change it with
+// -map-size.
 #define SOCKOPS_MAP_SIZE 5000
 struct {
 	__uint(type, BPF_MAP_TYPE_LRU_HASH);
@@ -35,6 +53,208 @@ struct {
 	__type(value, struct sk_info);
 } om_bandwidth_map SEC(".maps");
 
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_HASH);
+	__uint(max_entries, SOCKOPS_MAP_SIZE);
+	__type(key, struct sk_key);
+	__type(value, struct tcp_health);
+} om_tcp_health_map SEC(".maps");
+
+// Listening TCP sockets and bound UDP sockets come and go through this ring
+// buffer (or perf event array on older kernels).
+EVENT_OUTPUT_MAPS(om_listen_events, 1 << 16);
+
+// Events lost because the ring buffer was full
+DROP_COUNTER_MAP(om_listen_drops);
+
+// Event struct that will be sent to Go when a socket starts or stops
+// listening. This struct must be kept in sync with the Golang decoder.
+struct listen_event {
+	u32 addr[4]; // bind address, network byte order
+	u32 pid;
+	u8  comm[COMMLEN];
+	u16 port;    // host byte order
+	u8  ip_version;
+	u8  protocol;
+	u8  type;
+	u8  pad[3];
+};
+struct listen_event *unused_listen_event __attribute__((unused));
+
+// Events are built here when writing to the perf event array.
+EVENT_SCRATCH_MAP(om_listen_scratch, struct listen_event);
+
+// Socket of a pending bind by pid_tgid, for the kretprobes.
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_HASH);
+	__uint(max_entries, 4096);
+	__type(key, u64);
+	__type(value, u64);
+} om_bind_args SEC(".maps");
+
+// Reserves a listen event and fills in the current process.
+static __always_inline struct listen_event *new_listen_event(u8 type, u8 protocol, u16 port) {
+	struct listen_event *event;
+	event = event_reserve(&om_listen_events, &om_listen_scratch, sizeof(struct listen_event), &om_listen_drops);
+	if (!event) {
+		return NULL;
+	}
+
+	__builtin_memset(event, 0, sizeof(struct listen_event));
+	// Read PID (Careful: This is the Thread Group ID in kernel speak!)
+	event->pid = bpf_get_current_pid_tgid() >> 32;
+	bpf_get_current_comm(&event->comm, sizeof(event->comm));
+	event->port = port;
+	event->ip_version = 0;
+	event->protocol = protocol;
+	event->type = type;
+	return event;
+}
+
+// Emits a listen event for a TCP socket from a sockops callback. The callback
+// runs in the context of the process calling listen() or close().
+static __always_inline void emit_tcp_listen(struct bpf_sock_ops *skops, u8 type) {
+	struct listen_event *event = new_listen_event(type, PROTOCOL_TCP, skops->local_port);
+	if (!event) {
+		return;
+	}
+
+	if (skops->family == AF_INET) {
+		// Keeps the compiler from merging this load with the IPv6 one
+		// below into arithmetic on the context, which the verifier rejects.
+		u32 addr = skops->local_ip4;
+		barrier_var(addr);
+		event->addr[0] = addr;
+		event->ip_version = 4;
+	} else if (skops->family == AF_INET6) {
+		event->addr[0] = skops->local_ip6[0];
+		event->addr[1] = skops->local_ip6[1];
+		event->addr[2] = skops->local_ip6[2];
+		event->addr[3] = skops->local_ip6[3];
+		event->ip_version = 6;
+	}
+	event_submit(skops, &om_listen_events, &om_listen_events_perf, event, sizeof(struct listen_event), &om_listen_drops);
+}
+
+// Emits a listen event for a UDP socket.
+static __always_inline void emit_udp_bind(void *ctx, struct sock *sk, u8 type) {
+	u16 port = BPF_CORE_READ(sk, __sk_common.skc_num);
+	if (port == 0) {
+		return;
+	}
+
+	struct listen_event *event = new_listen_event(type, PROTOCOL_UDP, port);
+	if (!event) {
+		return;
+	}
+
+	u16 family = BPF_CORE_READ(sk, __sk_common.skc_family);
+	if (family == AF_INET) {
+		event->addr[0] = BPF_CORE_READ(sk, __sk_common.skc_rcv_saddr);
+		event->ip_version = 4;
+	} else if (family == AF_INET6) {
+		BPF_CORE_READ_INTO(&event->addr, sk, __sk_common.skc_v6_rcv_saddr.in6_u.u6_addr32);
+		event->ip_version = 6;
+	}
+	event_submit(ctx, &om_listen_events, &om_listen_events_perf, event, sizeof(struct listen_event), &om_listen_drops);
+}
+
+// Emits a listen event for a socket that was just bound successfully.
+static __always_inline void handle_bind(void *ctx, struct socket *sock, int ret) {
+	struct sock *sk = BPF_CORE_READ(sock, sk);
+	if (ret == 0 && sk && BPF_CORE_READ(sk, sk_protocol) == PROTOCOL_UDP) {
+		emit_udp_bind(ctx, sk, LISTEN_START);
+	}
+}
+
+#ifdef bpf_target_defined
+// Remembers the socket of a bind for the kretprobe.
+static __always_inline void save_bind_sock(struct socket *sock) {
+	u64 id = bpf_get_current_pid_tgid();
+	u64 ptr = (u64)sock;
+	bpf_map_update_elem(&om_bind_args, &id, &ptr, BPF_ANY);
+}
+
+// Returns the socket saved on entry of the bind.
+static __always_inline struct socket *take_bind_sock(void) {
+	u64 id = bpf_get_current_pid_tgid();
+	u64 *ptr = bpf_map_lookup_elem(&om_bind_args, &id);
+	if (!ptr) {
+		return NULL;
+	}
+	struct socket *sock = (struct socket *)*ptr;
+	bpf_map_delete_elem(&om_bind_args, &id);
+	return sock;
+}
+#endif
+
+// Returns the health entry of the connection, creating it if needed.
+static __always_inline struct tcp_health *get_health(struct sk_key *key) {
+	struct tcp_health *health = bpf_map_lookup_elem(&om_tcp_health_map, key);
+	if (health != NULL) {
+		return health;
+	}
+
+	struct tcp_health newHealth = {0};
+	bpf_map_update_elem(&om_tcp_health_map, key, &newHealth, BPF_NOEXIST);
+	return bpf_map_lookup_elem(&om_tcp_health_map, key);
+}
+
+// Update the health entry from a sockops callback.
+static __always_inline void update_health(struct bpf_sock_ops *skops, struct sk_key *key) {
+	struct tcp_health *health = get_health(key);
+	if (health == NULL) {
+		return;
+	}
+
+	// Both fields are read so the compiler can't pick between their
+	// addresses, the verifier rejects arithmetic on the context.
+	u32 state = skops->state;
+	u32 new_state = skops->args[1]; // for BPF_SOCK_OPS_STATE_CB
+	barrier_var(state);
+	barrier_var(new_state);
+
+	health->srtt_us = skops->srtt_us;
+	health->total_retrans = skops->total_retrans;
+	health->state = skops->op == BPF_SOCK_OPS_STATE_CB ? new_state : state;
+}
+
+// Start of the tcp_retransmit_skb and tcp_send_reset events. Newer kernels
+// give each event a struct of its own and moved the address fields, these
+// stayed in place.
+struct tcp_event_head {
+	u64 ent; // struct trace_entry
+	const void *skbaddr;
+	const void *skaddr;
+	int state;
+};
+
+// Generate the key of a connection from a tcp tracepoint. Returns false if
+// the event has no socket.
+static __always_inline bool tracepoint_key(struct tcp_event_head *ctx, struct sk_key *key) {
+	struct sock *sk = (struct sock *)ctx->skaddr;
+	if (sk == NULL) {
+		return false;
+	}
+
+	key->protocol = PROTOCOL_TCP;
+	key->src_port = BPF_CORE_READ(sk, __sk_common.skc_num);
+	key->dst_port = __builtin_bswap16(BPF_CORE_READ(sk, __sk_common.skc_dport));
+	u16 family = BPF_CORE_READ(sk, __sk_common.skc_family);
+	if (family == AF_INET) {
+		key->src_ip[0] = BPF_CORE_READ(sk, __sk_common.skc_rcv_saddr);
+		key->dst_ip[0] = BPF_CORE_READ(sk, __sk_common.skc_daddr);
+		key->ipv6 = 0;
+	} else if (family == AF_INET6) {
+		BPF_CORE_READ_INTO(&key->src_ip, sk, __sk_common.skc_v6_rcv_saddr.in6_u.u6_addr32);
+		BPF_CORE_READ_INTO(&key->dst_ip, sk, __sk_common.skc_v6_daddr.in6_u.u6_addr32);
+		key->ipv6 = 1;
+	} else {
+		return false;
+	}
+	return true;
+}
+
 SEC("sockops")
 int socket_operations(struct bpf_sock_ops *skops) {
 	switch (skops->op) {
@@ -44,8 +264,17 @@ int socket_operations(struct bpf_sock_ops *skops) {
 		return 0;
 	case BPF_SOCK_OPS_TCP_LISTEN_CB: // Listening ports
 		bpf_sock_ops_cb_flags_set(skops, BPF_SOCK_OPS_ALL_CB_FLAGS);
+		emit_tcp_listen(skops, LISTEN_START);
 		// No rx tx data for this socket object.
 		return 0;
+	case BPF_SOCK_OPS_STATE_CB:
+		// args[0] is the old state. A listening socket has no rx tx data
+		// either, it only needs to be reported as gone.
+		if (skops->args[0] == BPF_TCP_LISTEN) {
+			emit_tcp_listen(skops, LISTEN_STOP);
+			return 0;
+		}
+		break;
 	case BPF_SOCK_OPS_PASSIVE_ESTABLISHED_CB: // Incoming connections
 		// Set flag so any modification on the socket, will trigger this function.
 		bpf_sock_ops_cb_flags_set(skops, BPF_SOCK_OPS_ALL_CB_FLAGS);
@@ -54,6 +283,12 @@ int socket_operations(struct bpf_sock_ops *skops) {
 		break;
 	}
 
+	// RTT, retransmit and state callbacks update the health in addition to
+	// the bandwidth.
+	bool health = skops->op == BPF_SOCK_OPS_RTT_CB ||
+		skops->op == BPF_SOCK_OPS_RETRANS_CB ||
+		skops->op == BPF_SOCK_OPS_STATE_CB;
+
 	struct bpf_sock *sk = skops->sk;
 	if (sk == NULL) {
 		return 0;
@@ -74,6 +309,9 @@ int socket_operations(struct bpf_sock_ops *skops) {
 		newInfo.tx = skops->bytes_acked;
 
 		bpf_map_update_elem(&om_bandwidth_map, &key, &newInfo, BPF_ANY);
+		if (health) {
+			update_health(skops, &key);
+		}
 	} else if(sk->family == AF_INET6){
 		// Generate key for IPv6
 		key.src_ip[0] = sk->src_ip6[0];
@@ -95,125 +333,312 @@ int socket_operations(struct bpf_sock_ops *skops) {
 		newInfo.tx = skops->bytes_acked;
 
 		bpf_map_update_elem(&om_bandwidth_map, &key, &newInfo, BPF_ANY);
+		if (health) {
+			update_health(skops, &key);
+		}
 	}
 
 	return 0;
 }
 
-// udp_sendmsg hookes to the respective kernel function and saves the bandwidth data
-SEC("fentry/udp_sendmsg")
-int BPF_PROG(udp_sendmsg, struct sock *sk, struct msghdr *msg, size_t len) {
-	struct sock_common *skc = &sk->__sk_common;
+// tcp_retransmit_skb counts retransmitted segments
+SEC("tracepoint/tcp/tcp_retransmit_skb")
+int tcp_retransmit_skb(struct tcp_event_head *ctx) {
+	struct sk_key key = {0};
+	if (!tracepoint_key(ctx, &key)) {
+		return 0;
+	}
 
-	// Create a key for the map and set all the nececery information.
+	struct tcp_health *health = get_health(&key);
+	if (health != NULL) {
+		__sync_fetch_and_add(&health->retransmits, 1);
+		health->state = ctx->state;
+	}
+	return 0;
+}
+
+// tcp_send_reset counts resets sent by this host
+SEC("tracepoint/tcp/tcp_send_reset")
+int tcp_send_reset(struct tcp_event_head *ctx) {
 	struct sk_key key = {0};
-	key.protocol = PROTOCOL_UDP;
-	key.src_ip[0] = skc->skc_rcv_saddr;
-	key.dst_ip[0] = skc->skc_daddr;
-	key.src_port = skc->skc_num;
-	key.dst_port = __builtin_bswap16(skc->skc_dport);
-	key.ipv6 = 0;
+	if (!tracepoint_key(ctx, &key)) {
+		return 0;
+	}
 
-	// Update the map with the new information
-	struct sk_info *info = bpf_map_lookup_elem(&om_bandwidth_map, &key);
-	if (info != NULL) {
-		__sync_fetch_and_add(&info->tx, len); // TODO: Use atomic instead.
-		__sync_fetch_and_and(&info->reported, 0); // TODO: Use atomic instead.
-	} else {
-		struct sk_info newInfo = {0};
+	struct tcp_health *health = get_health(&key);
+	if (health != NULL) {
+		__sync_fetch_and_add(&health->resets, 1);
+	}
+	return 0;
+}
 
-		newInfo.tx = len;
-		bpf_map_update_elem(&om_bandwidth_map, &key, &newInfo, BPF_ANY);
+// inet_bind reports UDP sockets bound to a port
+SEC("fexit/inet_bind")
+int BPF_PROG(inet_bind, struct socket *sock, struct sockaddr *uaddr, int addr_len, int ret) {
+	handle_bind(ctx, sock, ret);
+	return 0;
+}
+
+// inet6_bind reports UDP sockets bound to a port
+SEC("fexit/inet6_bind")
+int BPF_PROG(inet6_bind, struct socket *sock, struct sockaddr *uaddr, int addr_len, int ret) {
+	handle_bind(ctx, sock, ret);
+	return 0;
+}
+
+// udp_lib_unhash reports UDP sockets releasing their port
+SEC("fentry/udp_lib_unhash")
+int BPF_PROG(udp_lib_unhash, struct sock *sk) {
+	emit_udp_bind(ctx, sk, LISTEN_STOP);
+	return 0;
+}
+
+#ifdef bpf_target_defined
+// Same as the bind hooks for kernels without fentry support. The socket is
+// only passed on entry.
+SEC("kprobe/inet_bind")
+int BPF_KPROBE(inet_bind_kprobe, struct socket *sock) {
+	save_bind_sock(sock);
+	return 0;
+}
+
+SEC("kretprobe/inet_bind")
+int BPF_KRETPROBE(inet_bind_kretprobe, int ret) {
+	struct socket *sock = take_bind_sock();
+	if (sock) {
+		handle_bind(ctx, sock, ret);
+	}
+	return 0;
+}
+
+SEC("kprobe/inet6_bind")
+int BPF_KPROBE(inet6_bind_kprobe, struct socket *sock) {
+	save_bind_sock(sock);
+	return 0;
+}
+
+SEC("kretprobe/inet6_bind")
+int BPF_KRETPROBE(inet6_bind_kretprobe, int ret) {
+	struct socket *sock = take_bind_sock();
+	if (sock) {
+		handle_bind(ctx, sock, ret);
 	}
+	return 0;
+}
 
+SEC("kprobe/udp_lib_unhash")
+int BPF_KPROBE(udp_lib_unhash_kprobe, struct sock *sk) {
+	emit_udp_bind(ctx, sk, LISTEN_STOP);
 	return 0;
+}
+#endif
+
+// Arguments of a pending UDP receive by pid_tgid, for the kretprobes.
+struct udp_recv_args {
+	u64 sk;
+	u64 msg;
 };
 
-// udp_recvmsg hookes to the respective kernel function and saves the bandwidth data
-SEC("fentry/udp_recvmsg")
-int BPF_PROG(udp_recvmsg, struct sock *sk, struct msghdr *msg, size_t len, int flags, int *addr_len) {
-	struct sock_common *skc = &sk->__sk_common;
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_HASH);
+	__uint(max_entries, 4096);
+	__type(key, u64);
+	__type(value, struct udp_recv_args);
+} om_udp_recv_args SEC(".maps");
+
+// Sets the peer of a UDP message in the key. Unconnected sockets have no
+// peer of their own, the address comes with every message: from the caller
+// on send, filled in by the kernel on receive. Connected sockets may leave
+// it out.
+static __always_inline void set_udp_peer(struct sk_key *key, struct sock *sk, struct msghdr *msg, bool ipv6) {
+	struct sockaddr *name = BPF_CORE_READ(msg, msg_name);
+	u16 family = 0;
+	if (name) {
+		bpf_probe_read_kernel(&family, sizeof(family), &name->sa_family);
+	}
+
+	if (family == AF_INET) {
+		struct sockaddr_in *sin = (struct sockaddr_in *)name;
+		u32 addr = BPF_CORE_READ(sin, sin_addr.s_addr);
+		if (ipv6) {
+			// IPv4 peer of a dual stack socket, stored IPv4-mapped
+			key->dst_ip[2] = __builtin_bswap32(0xffff);
+			key->dst_ip[3] = addr;
+		} else {
+			key->dst_ip[0] = addr;
+		}
+		key->dst_port = __builtin_bswap16(BPF_CORE_READ(sin, sin_port));
+		return;
+	}
+	if (family == AF_INET6 && ipv6) {
+		struct sockaddr_in6 *sin6 = (struct sockaddr_in6 *)name;
+		BPF_CORE_READ_INTO(&key->dst_ip, sin6, sin6_addr.in6_u.u6_addr32);
+		key->dst_port = __builtin_bswap16(BPF_CORE_READ(sin6, sin6_port));
+		return;
+	}
+
+	if (ipv6) {
+		BPF_CORE_READ_INTO(&key->dst_ip, sk, __sk_common.skc_v6_daddr.in6_u.u6_addr32);
+	} else {
+		key->dst_ip[0] = BPF_CORE_READ(sk, __sk_common.skc_daddr);
+	}
+	key->dst_port = __builtin_bswap16(BPF_CORE_READ(sk, __sk_common.skc_dport));
+}
 
+// Adds the bytes of a UDP send or receive to the entry of the peer.
+static __always_inline void update_udp(struct sock *sk, struct msghdr *msg, size_t len, bool ipv6, bool tx) {
 	// Create a key for the map and set all the nececery information.
 	struct sk_key key = {0};
 	key.protocol = PROTOCOL_UDP;
-	key.src_ip[0] = skc->skc_rcv_saddr;
-	key.dst_ip[0] = skc->skc_daddr;
-	key.src_port = skc->skc_num;
-	key.dst_port = __builtin_bswap16(skc->skc_dport);
-	key.ipv6 = 0;
+	if (ipv6) {
+		BPF_CORE_READ_INTO(&key.src_ip, sk, __sk_common.skc_v6_rcv_saddr.in6_u.u6_addr32);
+	} else {
+		key.src_ip[0] = BPF_CORE_READ(sk, __sk_common.skc_rcv_saddr);
+	}
+	key.src_port = BPF_CORE_READ(sk, __sk_common.skc_num);
+	key.ipv6 = ipv6;
+	set_udp_peer(&key, sk, msg, ipv6);
 
 	// Update the map with the new information
 	struct sk_info *info = bpf_map_lookup_elem(&om_bandwidth_map, &key);
 	if (info != NULL) {
-		__sync_fetch_and_add(&info->rx, len); // TODO: Use atomic instead.
+		if (tx) {
+			__sync_fetch_and_add(&info->tx, len); // TODO: Use atomic instead.
+		} else {
+			__sync_fetch_and_add(&info->rx, len); // TODO: Use atomic instead.
+		}
 		__sync_fetch_and_and(&info->reported, 0); // TODO: Use atomic instead.
 	} else {
 		struct sk_info newInfo = {0};
-
-		newInfo.rx = len;
+		if (tx) {
+			newInfo.tx = len;
+		} else {
+			newInfo.rx = len;
+		}
 		bpf_map_update_elem(&om_bandwidth_map, &key, &newInfo, BPF_ANY);
 	}
+}
+
+#ifdef bpf_target_defined
+// Remembers the arguments of a receive for the kretprobe.
+static __always_inline void save_udp_recv(struct sock *sk, struct msghdr *msg) {
+	u64 id = bpf_get_current_pid_tgid();
+	struct udp_recv_args args = {
+		.sk = (u64)sk,
+		.msg = (u64)msg,
+	};
+	bpf_map_update_elem(&om_udp_recv_args, &id, &args, BPF_ANY);
+}
+
+// Counts a finished receive with the arguments saved on entry.
+static __always_inline void finish_udp_recv(int ret, bool ipv6) {
+	u64 id = bpf_get_current_pid_tgid();
+	struct udp_recv_args *pending = bpf_map_lookup_elem(&om_udp_recv_args, &id);
+	if (!pending) {
+		return;
+	}
+	struct udp_recv_args args = *pending;
+	bpf_map_delete_elem(&om_udp_recv_args, &id);
+
+	if (ret > 0) {
+		update_udp((struct sock *)args.sk, (struct msghdr *)args.msg, ret, ipv6, false);
+	}
+}
+#endif
 
+// udp_sendmsg hookes to the respective kernel function and saves the bandwidth data
+SEC("fentry/udp_sendmsg")
+int BPF_PROG(udp_sendmsg, struct sock *sk, struct msghdr *msg, size_t len) {
+	update_udp(sk, msg, len, false, true);
 	return 0;
 };
 
+// udp_recvmsg hookes to the respective kernel function and saves the
+// bandwidth data. The peer address is only known once it returns. The
+// arguments after msg changed twice since 5.19, so the return value is
+// read with bpf_get_func_ret.
+SEC("fexit/udp_recvmsg")
+int BPF_PROG(udp_recvmsg, struct sock *sk, struct msghdr *msg) {
+	u64 ret = 0;
+	bpf_get_func_ret(ctx, &ret);
+	if ((int)ret > 0) {
+		update_udp(sk, msg, (int)ret, false, false);
+	}
+	return 0;
+};
+
+// udp_recvmsg for kernels before 5.19, which pass noblock and have no
+// bpf_get_func_ret in all versions. The loader picks it by prototype.
+SEC("fexit/udp_recvmsg")
+int BPF_PROG(udp_recvmsg_noblock, struct sock *sk, struct msghdr *msg, size_t len, int noblock, int flags, int *addr_len, int ret) {
+	if (ret > 0) {
+		update_udp(sk, msg, ret, false, false);
+	}
+	return 0;
+}
+
 // udpv6_sendmsg hookes to the respective kernel function and saves the bandwidth data
 SEC("fentry/udpv6_sendmsg")
 int BPF_PROG(udpv6_sendmsg, struct sock *sk, struct msghdr *msg, size_t len) {
-	struct sock_common *skc = &sk->__sk_common;
+	update_udp(sk, msg, len, true, true);
+	return 0;
+}
 
-	// Create a key for the map and set all the nececery information.
-	struct sk_key key = {0};
-	key.protocol = PROTOCOL_UDP;
-	for (int i = 0; i < 4; i++) {
-		key.src_ip[i] = skc->skc_v6_rcv_saddr.in6_u.u6_addr32[i];
-		key.dst_ip[i] = skc->skc_v6_rcv_saddr.in6_u.u6_addr32[i];
+// udpv6_recvmsg hookes to the respective kernel function and saves the
+// bandwidth data, see udp_recvmsg.
+SEC("fexit/udpv6_recvmsg")
+int BPF_PROG(udpv6_recvmsg, struct sock *sk, struct msghdr *msg) {
+	u64 ret = 0;
+	bpf_get_func_ret(ctx, &ret);
+	if ((int)ret > 0) {
+		update_udp(sk, msg, (int)ret, true, false);
 	}
-	key.src_port = skc->skc_num;
-	key.dst_port = __builtin_bswap16(skc->skc_dport);
-	key.ipv6 = 1;
+	return 0;
+}
 
-	// Update the map with the new information
-	struct sk_info *info = bpf_map_lookup_elem(&om_bandwidth_map, &key);
-	if (info != NULL) {
-		__sync_fetch_and_add(&info->tx, len); // TODO: Use atomic instead.
-		__sync_fetch_and_and(&info->reported, 0); // TODO: Use atomic instead.
-	} else {
-		struct sk_info newInfo = {0};
-		newInfo.tx = len;
-		bpf_map_update_elem(&om_bandwidth_map, &key, &newInfo, BPF_ANY);
+SEC("fexit/udpv6_recvmsg")
+int BPF_PROG(udpv6_recvmsg_noblock, struct sock *sk, struct msghdr *msg, size_t len, int noblock, int flags, int *addr_len, int ret) {
+	if (ret > 0) {
+		update_udp(sk, msg, ret, true, false);
 	}
+	return 0;
+}
 
+#ifdef bpf_target_defined
+// Same as the UDP hooks above for kernels without fentry support.
+SEC("kprobe/udp_sendmsg")
+int BPF_KPROBE(udp_sendmsg_kprobe, struct sock *sk, struct msghdr *msg, size_t len) {
+	update_udp(sk, msg, len, false, true);
 	return 0;
 }
 
-// udpv6_recvmsg hookes to the respective kernel function and saves the bandwidth data
-SEC("fentry/udpv6_recvmsg")
-int BPF_PROG(udpv6_recvmsg, struct sock *sk, struct msghdr *msg, size_t len, int flags, int *addr_len) {
-	struct sock_common *skc = &sk->__sk_common;
+SEC("kprobe/udp_recvmsg")
+int BPF_KPROBE(udp_recvmsg_kprobe, struct sock *sk, struct msghdr *msg) {
+	save_udp_recv(sk, msg);
+	return 0;
+}
 
-	// Create a key for the map and set all the nececery information.
-	struct sk_key key = {0};
-	key.protocol = PROTOCOL_UDP;
-	for (int i = 0; i < 4; i++) {
-		key.src_ip[i] = skc->skc_v6_rcv_saddr.in6_u.u6_addr32[i];
-		key.dst_ip[i] = skc->skc_v6_rcv_saddr.in6_u.u6_addr32[i];
-	}
-	key.src_port = skc->skc_num;
-	key.dst_port = __builtin_bswap16(skc->skc_dport);
-	key.ipv6 = 1;
+SEC("kretprobe/udp_recvmsg")
+int BPF_KRETPROBE(udp_recvmsg_kretprobe, int ret) {
+	finish_udp_recv(ret, false);
+	return 0;
+}
 
-	// Update the map with the new information
-	struct sk_info *info = bpf_map_lookup_elem(&om_bandwidth_map, &key);
-	if (info != NULL) {
-		__sync_fetch_and_add(&info->rx, len); // TODO: Use atomic instead.
-		__sync_fetch_and_and(&info->reported, 0); // TODO: Use atomic instead.
-	} else {
-		struct sk_info newInfo = {0};
-		newInfo.rx = len;
-		bpf_map_update_elem(&om_bandwidth_map, &key, &newInfo, BPF_ANY);
-	}
+SEC("kprobe/udpv6_sendmsg")
+int BPF_KPROBE(udpv6_sendmsg_kprobe, struct sock *sk, struct msghdr *msg, size_t len) {
+	update_udp(sk, msg, len, true, true);
+	return 0;
+}
 
+SEC("kprobe/udpv6_recvmsg")
+int BPF_KPROBE(udpv6_recvmsg_kprobe, struct sock *sk, struct msghdr *msg) {
+	save_udp_recv(sk, msg);
 	return 0;
-}
\ No newline at end of file
+}
+
+SEC("kretprobe/udpv6_recvmsg")
+int BPF_KRETPROBE(udpv6_recvmsg_kretprobe, int ret) {
+	finish_udp_recv(ret, true);
+	return 0;
+}
+#endif
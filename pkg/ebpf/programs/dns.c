@@ -0,0 +1,294 @@
+#include "vmlinux.h"
+#include "headers/bpf_helpers.h"
+#include "headers/bpf_tracing.h"
+#include "headers/bpf_core_read.h"
+#include "kprobe.h"
+#include "output.h"
+#include "msg.h"
+
+#define AF_INET 2
+#define AF_INET6 10
+
+// Protocols
+#define TCP 6
+#define UDP 17
+
+#define QUERY    0
+#define RESPONSE 1
+
+#define DNS_PORT 53
+#define DNS_MAX  512 // classic DNS message size limit, larger messages are truncated
+#define COMMLEN  16
+
+char __license[] SEC("license") = "GPL";
+
+// Ring buffer (or perf event array on older kernels) for all DNS messages
+EVENT_OUTPUT_MAPS(om_dns_events, 1 << 22);
+
+// Events lost because the ring buffer was full
+DROP_COUNTER_MAP(om_dns_drops);
+
+// A pending receive. The iterator in msghdr is advanced while copying, so the
+// user buffers have to be remembered on entry. kretprobes don't get the
+// arguments at all.
+struct recv_args {
+	struct user_seg segs[MSG_SEGS];
+	u64 sk;
+	u64 msg;
+	u32 nsegs;
+};
+
+// Pending receives by pid_tgid
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_HASH);
+	__uint(max_entries, 4096);
+	__type(key, u64);
+	__type(value, struct recv_args);
+} om_dns_recv_buf SEC(".maps");
+
+// Event struct that will be sent to Go for every DNS message. This struct must
+// be kept in sync with the Golang decoder.
+struct dns_event {
+	u32 pid;
+	u8  comm[COMMLEN];
+	u32 server[4]; // address of the DNS server, network byte order
+	u16 server_port;
+	u16 len;       // size of the message
+	u16 captured;  // bytes of the message in payload
+	u8  ip_version;
+	u8  protocol;
+	u8  direction;
+	u8  pad[3];
+	u8  payload[DNS_MAX * 2]; // up to DNS_MAX used, the rest bounds the copies for the verifier
+};
+struct dns_event *unused __attribute__((unused));
+
+// Events are built here when writing to the perf event array.
+EVENT_SCRATCH_MAP(om_dns_scratch, struct dns_event);
+
+// Fills the server address and port. The peer of a connected socket is in the
+// socket itself, unconnected sockets pass it in msg_name.
+static __always_inline bool peer_of(struct sock *sk, struct msghdr *msg, struct dns_event *event) {
+	u16 family = BPF_CORE_READ(sk, __sk_common.skc_family);
+	u16 port = BPF_CORE_READ(sk, __sk_common.skc_dport);
+
+	if (port != 0) {
+		event->server_port = __builtin_bswap16(port);
+		if (family == AF_INET) {
+			event->server[0] = BPF_CORE_READ(sk, __sk_common.skc_daddr);
+			event->ip_version = 4;
+		} else if (family == AF_INET6) {
+			BPF_CORE_READ_INTO(&event->server, sk, __sk_common.skc_v6_daddr.in6_u.u6_addr32);
+			event->ip_version = 6;
+		}
+		return true;
+	}
+
+	void *name = BPF_CORE_READ(msg, msg_name);
+	if (!name) {
+		return false;
+	}
+
+	struct sockaddr_in6 addr = {0};
+	bpf_probe_read_kernel(&addr, sizeof(addr), name);
+	if (addr.sin6_family == AF_INET) {
+		struct sockaddr_in *addr4 = (struct sockaddr_in *)&addr;
+		event->server[0] = addr4->sin_addr.s_addr;
+		event->server_port = __builtin_bswap16(addr4->sin_port);
+		event->ip_version = 4;
+		return true;
+	}
+	if (addr.sin6_family == AF_INET6) {
+		__builtin_memcpy(event->server, addr.sin6_addr.in6_u.u6_addr32, sizeof(event->server));
+		event->server_port = __builtin_bswap16(addr.sin6_port);
+		event->ip_version = 6;
+		return true;
+	}
+	return false;
+}
+
+// Copies a DNS message of len bytes from the user buffers segs to the ring
+// buffer.
+static __always_inline void emit(void *ctx, struct sock *sk, struct msghdr *msg, struct user_seg *segs, u32 nsegs, u32 len, u8 protocol, u8 direction) {
+	if (nsegs == 0 || len == 0) {
+		return;
+	}
+	// Resolvers may send or read the length prefix of DNS over TCP on its
+	// own, it is not a message.
+	if (protocol == TCP && len <= 2) {
+		return;
+	}
+
+	struct dns_event *event;
+	event = event_reserve(&om_dns_events, &om_dns_scratch, sizeof(struct dns_event), &om_dns_drops);
+	if (!event) {
+		return;
+	}
+
+	__builtin_memset(event->server, 0, sizeof(event->server));
+	event->ip_version = 0;
+	if (!peer_of(sk, msg, event) || event->server_port != DNS_PORT) {
+		event_discard(event);
+		return;
+	}
+
+	// Read PID (Careful: This is the Thread Group ID in kernel speak!)
+	event->pid = bpf_get_current_pid_tgid() >> 32;
+	bpf_get_current_comm(&event->comm, sizeof(event->comm));
+	event->protocol = protocol;
+	event->direction = direction;
+
+	// The message continues in the next buffer where one ends. Offset and
+	// size are 64 bits wide and behind a barrier, so the compiler checks the
+	// registers it passes on and not zero extended copies of them. Both are
+	// at most DNS_MAX, the payload has room for both.
+	u64 captured = 0;
+	u64 left = len < DNS_MAX ? len : DNS_MAX;
+	for (int i = 0; i < MSG_SEGS && i < nsegs && left > 0; i++) {
+		u64 chunk = segs[i].len < left ? segs[i].len : left;
+		barrier_var(chunk);
+		barrier_var(captured);
+		if (chunk > DNS_MAX || captured > DNS_MAX) {
+			break;
+		}
+		if (bpf_probe_read_user(event->payload + captured, chunk, (void *)segs[i].base)) {
+			event_discard(event);
+			return;
+		}
+		captured += chunk;
+		left -= chunk;
+	}
+	event->len = len;
+	event->captured = captured;
+
+	// Only the captured part of the payload is sent on perf event arrays.
+	u64 size = sizeof(struct dns_event);
+	if (!use_ringbuf) {
+		size = offsetof(struct dns_event, payload) + captured;
+		if (size > sizeof(struct dns_event)) {
+			size = sizeof(struct dns_event);
+		}
+	}
+	event_submit(ctx, &om_dns_events, &om_dns_events_perf, event, size, &om_dns_drops);
+}
+
+// Whether the socket could be talking to a DNS server. Unconnected UDP sockets
+// are only known to be DNS once the peer in msg_name is checked.
+static __always_inline bool maybe_dns(struct sock *sk, u8 protocol) {
+	u16 port = __builtin_bswap16(BPF_CORE_READ(sk, __sk_common.skc_dport));
+	if (port == DNS_PORT) {
+		return true;
+	}
+	return protocol == UDP && port == 0;
+}
+
+static __always_inline void handle_send(void *ctx, struct sock *sk, struct msghdr *msg, size_t len, u8 protocol) {
+	if (!maybe_dns(sk, protocol)) {
+		return;
+	}
+	struct user_seg segs[MSG_SEGS] = {0};
+	u32 nsegs = iter_segs(&msg->msg_iter, segs);
+	emit(ctx, sk, msg, segs, nsegs, len, protocol, QUERY);
+}
+
+static __always_inline void handle_recv_enter(struct sock *sk, struct msghdr *msg, u8 protocol) {
+	if (!maybe_dns(sk, protocol)) {
+		return;
+	}
+
+	u64 id = bpf_get_current_pid_tgid();
+	struct recv_args args = {
+		.sk = (u64)sk,
+		.msg = (u64)msg,
+	};
+	args.nsegs = iter_segs(&msg->msg_iter, args.segs);
+	if (args.nsegs > 0) {
+		bpf_map_update_elem(&om_dns_recv_buf, &id, &args, BPF_ANY);
+	}
+}
+
+static __always_inline void handle_recv_exit(void *ctx, int ret, u8 protocol) {
+	u64 id = bpf_get_current_pid_tgid();
+	struct recv_args *pending = bpf_map_lookup_elem(&om_dns_recv_buf, &id);
+	if (!pending) {
+		return;
+	}
+	struct recv_args args = *pending;
+	bpf_map_delete_elem(&om_dns_recv_buf, &id);
+
+	if (ret <= 0) {
+		return;
+	}
+	emit(ctx, (struct sock *)args.sk, (struct msghdr *)args.msg, args.segs, args.nsegs, ret, protocol, RESPONSE);
+}
+
+// Defines the fentry program of a send function.
+#define SEND_HOOK(name, func, protocol)                                   \
+	SEC("fentry/" #func)                                                  \
+	int BPF_PROG(name, struct sock *sk, struct msghdr *msg, size_t len) { \
+		handle_send(ctx, sk, msg, len, protocol);                         \
+		return 0;                                                         \
+	}
+
+// Defines the kprobe equivalent of SEND_HOOK.
+#define SEND_KPROBE(name, func, protocol)                                            \
+	SEC("kprobe/" #func)                                                             \
+	int BPF_KPROBE(name##_kprobe, struct sock *sk, struct msghdr *msg, size_t len) { \
+		handle_send(ctx, sk, msg, len, protocol);                                    \
+		return 0;                                                                    \
+	}
+
+// Defines the fentry/fexit programs of a receive function. Only the return
+// value is used on exit, the arguments were saved on entry. Its position
+// depends on the prototype: the exit program reads it with
+// bpf_get_func_ret, the _noblock variant is for kernels before 5.19, which
+// pass noblock (nonblock for TCP) after len.
+#define RECV_HOOK(name, func, protocol)                                  \
+	SEC("fentry/" #func)                                                 \
+	int BPF_PROG(name, struct sock *sk, struct msghdr *msg) {            \
+		handle_recv_enter(sk, msg, protocol);                            \
+		return 0;                                                        \
+	}                                                                    \
+	SEC("fexit/" #func)                                                  \
+	int BPF_PROG(name##_exit) {                                          \
+		u64 ret = 0;                                                     \
+		bpf_get_func_ret(ctx, &ret);                                     \
+		handle_recv_exit(ctx, (int)ret, protocol);                       \
+		return 0;                                                        \
+	}                                                                    \
+	SEC("fexit/" #func)                                                  \
+	int BPF_PROG(name##_exit_noblock, struct sock *sk, struct msghdr *msg, size_t len, int noblock, int flags, int *addr_len, int ret) { \
+		handle_recv_exit(ctx, ret, protocol);                            \
+		return 0;                                                        \
+	}
+
+// Defines the kprobe equivalents of RECV_HOOK.
+#define RECV_KPROBE(name, func, protocol)                                \
+	SEC("kprobe/" #func)                                                 \
+	int BPF_KPROBE(name##_kprobe, struct sock *sk, struct msghdr *msg) { \
+		handle_recv_enter(sk, msg, protocol);                            \
+		return 0;                                                        \
+	}                                                                    \
+	SEC("kretprobe/" #func)                                              \
+	int BPF_KRETPROBE(name##_exit_kretprobe, int ret) {                  \
+		handle_recv_exit(ctx, ret, protocol);                            \
+		return 0;                                                        \
+	}
+
+SEND_HOOK(dns_udp_sendmsg, udp_sendmsg, UDP)
+SEND_HOOK(dns_udpv6_sendmsg, udpv6_sendmsg, UDP)
+SEND_HOOK(dns_tcp_sendmsg, tcp_sendmsg, TCP)
+
+RECV_HOOK(dns_udp_recvmsg, udp_recvmsg, UDP)
+RECV_HOOK(dns_udpv6_recvmsg, udpv6_recvmsg, UDP)
+RECV_HOOK(dns_tcp_recvmsg, tcp_recvmsg, TCP)
+
+#ifdef bpf_target_defined
+SEND_KPROBE(dns_udp_sendmsg, udp_sendmsg, UDP)
+SEND_KPROBE(dns_udpv6_sendmsg, udpv6_sendmsg, UDP)
+SEND_KPROBE(dns_tcp_sendmsg, tcp_sendmsg, TCP)
+
+RECV_KPROBE(dns_udp_recvmsg, udp_recvmsg, UDP)
+RECV_KPROBE(dns_udpv6_recvmsg, udpv6_recvmsg, UDP)
+RECV_KPROBE(dns_tcp_recvmsg, tcp_recvmsg, TCP)
+#endif
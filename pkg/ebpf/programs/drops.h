@@ -0,0 +1,26 @@
+// Accounting for events that never make it to userspace. Every program that
+// writes to a ring buffer declares a counter map with DROP_COUNTER_MAP and
+// calls count_drop whenever bpf_ringbuf_reserve or bpf_ringbuf_output fails.
+
+#ifndef __OM_DROPS_H
+#define __OM_DROPS_H
+
+// Per CPU so the counter can be updated without atomics. Userspace sums the
+// values of all CPUs.
+#define DROP_COUNTER_MAP(name)                        \
+	struct {                                          \
+		__uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);      \
+		__uint(max_entries, 1);                       \
+		__type(key, u32);                             \
+		__type(value, u64);                           \
+	} name SEC(".maps")
+
+static __always_inline void count_drop(void *map) {
+	u32 key = 0;
+	u64 *count = bpf_map_lookup_elem(map, &key);
+	if (count) {
+		(*count)++;
+	}
+}
+
+#endif
@@ -0,0 +1,170 @@
+#include "vmlinux.h"
+#include "headers/bpf_helpers.h"
+#include "headers/bpf_tracing.h"
+#include "output.h"
+
+#define AF_INET 2
+#define AF_INET6 10
+
+// Protocols
+#define TCP 6
+#define UDP 17
+
+// Rule actions
+#define ACTION_BLOCK 1
+#define ACTION_ALLOW 2
+
+// Return values of cgroup sock_addr programs
+#define VERDICT_REJECT 0
+#define VERDICT_PASS   1
+
+#define COMMLEN 16
+
+// Bits of the policy key before the address: protocol, ip version and port.
+#define KEY_HEADER_BITS 32
+
+char __license[] SEC("license") = "GPL";
+
+// Key of the destination rules. Rules for any protocol or port are stored with
+// a zero protocol or port, the address is matched by prefix.
+struct policy_key {
+	u32 prefixlen;
+	u8  protocol;
+	u8  ip_version;
+	u16 port;     // network byte order
+	u8  addr[16]; // network byte order
+};
+
+struct policy_rule {
+	u32 id;       // rule id in the Go rule engine
+	u8  action;
+	u8  pad[3];
+};
+
+// Destination rules, populated from the Go rule engine
+struct {
+	__uint(type, BPF_MAP_TYPE_LPM_TRIE);
+	__uint(max_entries, 4096);
+	__uint(map_flags, BPF_F_NO_PREALLOC);
+	__type(key, struct policy_key);
+	__type(value, struct policy_rule);
+} om_policy_map SEC(".maps");
+
+// Process rules by comm, populated from the Go rule engine
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__uint(max_entries, 1024);
+	__type(key, u8[COMMLEN]);
+	__type(value, struct policy_rule);
+} om_policy_procs SEC(".maps");
+
+// Ring buffer (or perf event array on older kernels) for blocked connections
+EVENT_OUTPUT_MAPS(om_enforce_events, 1 << 18);
+
+// Events lost because the ring buffer was full
+DROP_COUNTER_MAP(om_enforce_drops);
+
+// Event struct that will be sent to Go for every blocked connect or send. This
+// struct must be kept in sync with the Golang decoder.
+struct block_event {
+	u32 addr[4]; // destination, network byte order
+	u32 pid;
+	u32 rule_id;
+	u8  comm[COMMLEN];
+	u16 port;    // destination, host byte order
+	u8  ip_version;
+	u8  protocol;
+};
+struct block_event *unused __attribute__((unused));
+
+// Looks up the destination, most specific protocol and port first.
+static __always_inline struct policy_rule *lookup_destination(struct policy_key *key, u8 protocol, u16 port) {
+	struct policy_rule *rule;
+
+	u8 protocols[2] = {protocol, 0};
+	u16 ports[2] = {port, 0};
+	for (int i = 0; i < 2; i++) {
+		for (int j = 0; j < 2; j++) {
+			key->protocol = protocols[i];
+			key->port = ports[j];
+			rule = bpf_map_lookup_elem(&om_policy_map, key);
+			if (rule) {
+				return rule;
+			}
+		}
+	}
+	return NULL;
+}
+
+static __always_inline void report(struct bpf_sock_addr *ctx, struct policy_key *key, u8 protocol, u16 port, u32 rule_id) {
+	struct block_event event = {0};
+	__builtin_memcpy(event.addr, key->addr, sizeof(event.addr));
+	// Read PID (Careful: This is the Thread Group ID in kernel speak!)
+	event.pid = bpf_get_current_pid_tgid() >> 32;
+	event.rule_id = rule_id;
+	bpf_get_current_comm(&event.comm, sizeof(event.comm));
+	event.port = __builtin_bswap16(port);
+	event.ip_version = key->ip_version;
+	event.protocol = protocol;
+
+	event_output(ctx, &om_enforce_events, &om_enforce_events_perf, &event, sizeof(event), &om_enforce_drops);
+}
+
+// Decides about a connect or send of the calling process.
+static __always_inline int enforce(struct bpf_sock_addr *ctx, bool v6) {
+	u8 protocol = ctx->protocol;
+	if (protocol != TCP && protocol != UDP) {
+		return VERDICT_PASS;
+	}
+	u16 port = ctx->user_port;
+
+	struct policy_key key = {0};
+	key.prefixlen = KEY_HEADER_BITS + (v6 ? 128 : 32);
+	if (v6) {
+		key.ip_version = 6;
+		u32 addr[4];
+		addr[0] = ctx->user_ip6[0];
+		addr[1] = ctx->user_ip6[1];
+		addr[2] = ctx->user_ip6[2];
+		addr[3] = ctx->user_ip6[3];
+		__builtin_memcpy(key.addr, addr, sizeof(addr));
+	} else {
+		key.ip_version = 4;
+		u32 addr = ctx->user_ip4;
+		__builtin_memcpy(key.addr, &addr, sizeof(addr));
+	}
+
+	// Process rules apply to every destination.
+	u8 comm[COMMLEN] = {0};
+	bpf_get_current_comm(&comm, sizeof(comm));
+	struct policy_rule *rule = bpf_map_lookup_elem(&om_policy_procs, &comm);
+	if (!rule) {
+		rule = lookup_destination(&key, protocol, port);
+	}
+
+	if (rule && rule->action == ACTION_BLOCK) {
+		report(ctx, &key, protocol, port, rule->id);
+		return VERDICT_REJECT;
+	}
+	return VERDICT_PASS;
+}
+
+SEC("cgroup/connect4")
+int enforce_connect4(struct bpf_sock_addr *ctx) {
+	return enforce(ctx, false);
+}
+
+SEC("cgroup/connect6")
+int enforce_connect6(struct bpf_sock_addr *ctx) {
+	return enforce(ctx, true);
+}
+
+SEC("cgroup/sendmsg4")
+int enforce_sendmsg4(struct bpf_sock_addr *ctx) {
+	return enforce(ctx, false);
+}
+
+SEC("cgroup/sendmsg6")
+int enforce_sendmsg6(struct bpf_sock_addr *ctx) {
+	return enforce(ctx, true);
+}
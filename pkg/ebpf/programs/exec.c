@@ -1,17 +1,32 @@
 #include "vmlinux.h"
 #include "headers/bpf_helpers.h"
 #include "headers/bpf_tracing.h"
+#include "headers/bpf_core_read.h"
+#include "output.h"
 
-#define ARGLEN  32   // maximum amount of args in argv we'll copy
-#define ARGSIZE 1024 // maximum byte length of each arg in argv we'll copy
+#define ARGLEN    32   // maximum amount of args in argv we'll copy
+#define ARGSIZE   1024 // maximum byte length of the filename and each arg we'll copy
+#define DATA_SIZE 8192 // packed filename and argv budget, must be a power of two
+#define COMMLEN   16   // TASK_COMM_LEN
+
+// Truncation flags, must match the Truncated* constants in Go.
+#define TRUNC_FILENAME 1 // filename didn't fit in ARGSIZE with its NUL
+#define TRUNC_ARG      2 // at least one argument didn't fit in ARGSIZE with its NUL
+#define TRUNC_ARGC     4 // there were more than ARGLEN arguments
+#define TRUNC_DATA     8 // arguments were dropped because DATA_SIZE was exceeded
+
+// Event types, must match the ExecEvent* constants in Go.
+#define EVENT_EXEC     1 // execve/execveat entered
+#define EVENT_EXEC_RET 2 // execve/execveat returned
+#define EVENT_EXIT     3 // thread group leader exited
 
 char __license[] SEC("license") = "GPL";
 
-// Ring buffer for all connection events
-struct {
-	__uint(type, BPF_MAP_TYPE_RINGBUF);
-	__uint(max_entries, 1 << 24);
-} om_exec_map SEC(".maps");
+// Ring buffer (or perf event array on older kernels) for all exec events
+EVENT_OUTPUT_MAPS(om_exec_map, 1 << 24);
+
+// Events lost because the ring buffer was full
+DROP_COUNTER_MAP(om_exec_drops);
 
 // This struct is defined according to
 // /sys/kernel/debug/tracing/events/syscalls/sys_enter_execve/format
@@ -28,89 +43,232 @@ struct exec_info {
 	const u8 *const *envp;      // offset=32, size=8 (ptr)
 };
 
-// The event struct. This struct must be kept in sync with the Golang
-// counterpart.
+// This struct is defined according to
+// /sys/kernel/debug/tracing/events/syscalls/sys_enter_execveat/format
+struct execat_info {
+	u16 common_type;            // offset=0,  size=2
+	u8  common_flags;           // offset=2,  size=1
+	u8  common_preempt_count;   // offset=3,  size=1
+	s32 common_pid;             // offset=4,  size=4
+
+	s32             syscall_nr; // offset=8,  size=4
+	u32             pad;        // offset=12, size=4 (pad)
+	s64             fd;         // offset=16, size=8
+	const u8        *filename;  // offset=24, size=8 (ptr)
+	const u8 *const *argv;      // offset=32, size=8 (ptr)
+	const u8 *const *envp;      // offset=40, size=8 (ptr)
+	s64             flags;      // offset=48, size=8
+};
+
+// This struct is defined according to
+// /sys/kernel/debug/tracing/events/syscalls/sys_exit_execve/format
+struct exec_ret_info {
+	u16 common_type;            // offset=0,  size=2
+	u8  common_flags;           // offset=2,  size=1
+	u8  common_preempt_count;   // offset=3,  size=1
+	s32 common_pid;             // offset=4,  size=4
+
+	s32 syscall_nr;             // offset=8,  size=4
+	u32 pad;                    // offset=12, size=4 (pad)
+	s64 ret;                    // offset=16, size=8
+};
+
+// Header that starts every event on the ring buffer. Exit and exec return
+// events consist only of the header. This struct must be kept in sync with the
+// Golang counterpart.
+struct event_hdr_t {
+	u32 type;
+	u32 pid;
+	u32 ppid;
+	s32 retval; // return code of execve or wait status of the exited process
+};
+
+// The exec event. Only the used part of data is sent to userspace: the
+// filename followed by argc arguments, each NUL terminated. This struct must
+// be kept in sync with the Golang decoder.
 struct event_t {
+	struct event_hdr_t hdr;
+
 	// Details about the process being launched.
-	u8  filename[ARGSIZE];
-	u8  argv[ARGLEN][ARGSIZE];
-	u32 argc; // set to ARGLEN + 1 if there were more than ARGLEN arguments
 	u32 uid;
 	u32 gid;
-	u32 pid;
+	u8  comm[COMMLEN]; // name of the calling process
+	u16 argc;          // number of arguments in data
+	u16 data_len;      // number of used bytes in data
+	u8  flags;         // TRUNC_* flags
+	u8  pad[3];
 
-	// Name of the calling process.
-	u8  comm[ARGSIZE];
+	// The last string may start just below DATA_SIZE, so leave room for one
+	// more full string.
+	u8  data[DATA_SIZE + ARGSIZE];
 };
 
-// Tracepoint at the top of execve() syscall.
-SEC("tracepoint/syscalls/sys_enter_execve")
-s32 enter_execve(struct exec_info *ctx) {
-	// Reserve memory for our event on the `events` ring buffer defined above.
-	struct event_t *event;
-	event = bpf_ringbuf_reserve(&om_exec_map, sizeof(struct event_t), 0);
-	if (!event) {
-		bpf_printk("could not reserve ringbuf memory");
+// Scratch space to build the exec event in, it is too big for the stack.
+struct {
+	__uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+	__uint(max_entries, 1);
+	__type(key, u32);
+	__type(value, struct event_t);
+} om_exec_scratch SEC(".maps");
+
+// Fill the header with details about the current process.
+static __always_inline void fill_header(struct event_hdr_t *hdr, u32 type) {
+	struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+
+	hdr->type = type;
+	hdr->pid = bpf_get_current_pid_tgid() >> 32; // pid is the last 32 bits (tgid)
+	hdr->ppid = BPF_CORE_READ(task, real_parent, tgid);
+}
+
+// Whether a string read from src with bpf_probe_read_user_str into ARGSIZE
+// bytes was cut. The read counts the NUL it always writes, so a result of
+// ARGSIZE is a string that fit exactly or one that was cut: the byte the NUL
+// took the place of tells.
+static __always_inline bool str_truncated(s32 ret, const u8 *src) {
+	if (ret != ARGSIZE) {
+		return false;
+	}
+	u8 next = 0;
+	return bpf_probe_read_user(&next, sizeof(next), src + ARGSIZE - 1) == 0 && next != 0;
+}
 
+// Shared body of the execve and execveat entry tracepoints.
+static __always_inline s32 handle_exec(void *ctx, const u8 *filename, const u8 *const *argv) {
+	u32 zero = 0;
+	struct event_t *event = bpf_map_lookup_elem(&om_exec_scratch, &zero);
+	if (!event) {
 		return 1;
 	}
 
 	// Store process/calling process details.
+	fill_header(&event->hdr, EVENT_EXEC);
+	event->hdr.retval = 0;
+	event->argc = 0;
+	event->flags = 0;
+
 	u64 uidgid = bpf_get_current_uid_gid();
-	u64 pidtgid = bpf_get_current_pid_tgid();
 	event->uid = uidgid;       // uid is the first 32 bits
 	event->gid = uidgid >> 32; // gid is the last 32 bits NOLINT(readability-magic-numbers)
-	event->pid = pidtgid;      // pid is the first 32 bits
 	s32 ret = bpf_get_current_comm(&event->comm, sizeof(event->comm));
 	if (ret) {
 		bpf_printk("could not get current comm: %d", ret);
-		bpf_ringbuf_discard(event, 0);
 		return 1;
 	}
 
 	// Write the filename in addition to argv[0] because the filename contains
 	// the full path to the file which could be more useful in some situations.
-	ret = bpf_probe_read_user_str(event->filename, sizeof(event->filename), ctx->filename);
+	ret = bpf_probe_read_user_str(event->data, ARGSIZE, filename);
 	if (ret < 0) {
 		bpf_printk("could not read filename into event struct: %d", ret);
-		bpf_ringbuf_discard(event, 0);
 		return 1;
 	}
+	if (str_truncated(ret, filename)) {
+		event->flags |= TRUNC_FILENAME;
+	}
+	u32 len = ret;
 
-	// Copy everything from ctx->argv to event->argv, incrementing event->argc
-	// as we go.
+	// Pack everything from argv behind the filename, incrementing
+	// event->argc as we go.
+	const u8 *argp = NULL;
 	for (s32 i = 0; i < ARGLEN; i++) {
-		if (!(&ctx->argv[i])) {
+		// Copying the arg pointer into it's own variable before reading the
+		// string prevents memory corruption.
+		argp = NULL;
+		ret = bpf_probe_read_user(&argp, sizeof(argp), &argv[i]);
+		if (ret || !argp) {
 			goto out;
 		}
 
-		// Copying the arg into it's own variable before copying it into
-		// event->argv[i] prevents memory corruption.
-		const u8 *argp = NULL;
-		ret = bpf_probe_read_user(&argp, sizeof(argp), &ctx->argv[i]);
-		if (ret || !argp) {
+		if (len >= DATA_SIZE) {
+			event->flags |= TRUNC_DATA;
 			goto out;
 		}
 
-		// Copy argp to event->argv[i].
-		ret = bpf_probe_read_user_str(event->argv[i], sizeof(event->argv[i]), argp);
+		// The mask is a no-op, it proves to the verifier that the string
+		// stays within data.
+		ret = bpf_probe_read_user_str(&event->data[len & (DATA_SIZE - 1)], ARGSIZE, argp);
 		if (ret < 0) {
 			bpf_printk("read argv %d: %d", i, ret);
 			goto out;
 		}
+		if (str_truncated(ret, argp)) {
+			event->flags |= TRUNC_ARG;
+		}
 
+		len += ret;
 		event->argc++;
 	}
 
-	// This won't get hit if we `goto out` in the loop above. This is to signify
-	// to userspace that we couldn't copy all of the arguments because it
-	// exceeded ARGLEN.
-	event->argc++;
+	// All ARGLEN slots are used, check whether there were more.
+	argp = NULL;
+	ret = bpf_probe_read_user(&argp, sizeof(argp), &argv[ARGLEN]);
+	if (!ret && argp) {
+		event->flags |= TRUNC_ARGC;
+	}
 
 out:
-	// Write the event to the ring buffer and notify userspace. This will cause
+	event->data_len = len;
+
+	u64 size = offsetof(struct event_t, data) + len;
+	if (size > sizeof(struct event_t)) {
+		size = sizeof(struct event_t);
+	}
+
+	// Copy the event to the ring buffer and notify userspace. This will cause
 	// the `Read()` call in userspace to return if it was blocked.
-	bpf_ringbuf_submit(event, 0);
+	event_output(ctx, &om_exec_map, &om_exec_map_perf, event, size, &om_exec_drops);
+	return 0;
+}
+
+// Shared body of the execve and execveat exit tracepoints.
+static __always_inline s32 handle_exec_ret(void *ctx, s64 retval) {
+	struct event_hdr_t event = {0};
+	fill_header(&event, EVENT_EXEC_RET);
+	event.retval = retval;
+
+	event_output(ctx, &om_exec_map, &om_exec_map_perf, &event, sizeof(event), &om_exec_drops);
+	return 0;
+}
+
+// Tracepoint at the top of execve() syscall.
+SEC("tracepoint/syscalls/sys_enter_execve")
+s32 enter_execve(struct exec_info *ctx) {
+	return handle_exec(ctx, ctx->filename, ctx->argv);
+}
+
+// Tracepoint at the top of execveat() syscall.
+SEC("tracepoint/syscalls/sys_enter_execveat")
+s32 enter_execveat(struct execat_info *ctx) {
+	return handle_exec(ctx, ctx->filename, ctx->argv);
+}
+
+// Tracepoint at the end of execve() syscall. On success this runs in the
+// context of the new program.
+SEC("tracepoint/syscalls/sys_exit_execve")
+s32 exit_execve(struct exec_ret_info *ctx) {
+	return handle_exec_ret(ctx, ctx->ret);
+}
+
+// Tracepoint at the end of execveat() syscall.
+SEC("tracepoint/syscalls/sys_exit_execveat")
+s32 exit_execveat(struct exec_ret_info *ctx) {
+	return handle_exec_ret(ctx, ctx->ret);
+}
+
+// Tracepoint on process exit. This fires for every thread, only the thread
+// group leader is reported as it marks the end of the process.
+SEC("tracepoint/sched/sched_process_exit")
+s32 sched_process_exit(struct trace_event_raw_sched_process_template *ctx) {
+	u64 pidtgid = bpf_get_current_pid_tgid();
+	if ((u32)pidtgid != (u32)(pidtgid >> 32)) {
+		return 0;
+	}
+
+	struct event_hdr_t event = {0};
+	struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+	fill_header(&event, EVENT_EXIT);
+	event.retval = BPF_CORE_READ(task, exit_code);
 
+	event_output(ctx, &om_exec_map, &om_exec_map_perf, &event, sizeof(event), &om_exec_drops);
 	return 0;
-}
\ No newline at end of file
+}
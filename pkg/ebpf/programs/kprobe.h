@@ -0,0 +1,25 @@
+// Kprobe programs read the arguments of the probed function from registers,
+// which differ between architectures. bpf2go builds objects of their own for
+// amd64 and arm64 with the matching __TARGET_ARCH_*, the objects shared by
+// all other architectures leave the kprobe programs out: they are wrapped in
+// #ifdef bpf_target_defined, and kernels without fentry support can't run
+// the programs there.
+//
+// vmlinux.h is generated on x86. The register struct bpf_tracing.h uses on
+// arm64 is declared here, its layout is part of the uapi.
+
+#ifndef __OM_KPROBE_H
+#define __OM_KPROBE_H
+
+#include "headers/bpf_tracing.h"
+
+#ifdef __TARGET_ARCH_arm64
+struct user_pt_regs {
+	__u64 regs[31];
+	__u64 sp;
+	__u64 pc;
+	__u64 pstate;
+};
+#endif
+
+#endif
@@ -1,6 +1,10 @@
 #include "vmlinux.h"
 #include "headers/bpf_helpers.h"
 #include "headers/bpf_tracing.h"
+#include "headers/bpf_core_read.h"
+#include "kprobe.h"
+#include "output.h"
+#include "msg.h"
 
 // IP Version
 #define AF_INET 2
@@ -10,17 +14,19 @@
 #define TCP     6
 #define UDP     17
 #define UDPLite 136
+#define ICMP    1
+#define ICMPV6  58
 
 #define OUTBOUND 0
 #define INBOUND 1
 
 char __license[] SEC("license") = "GPL";
 
-// Ring buffer for all connection events
-struct {
-	__uint(type, BPF_MAP_TYPE_RINGBUF);
-	__uint(max_entries, 1 << 24);
-} om_connection_events SEC(".maps");
+// Ring buffer (or perf event array on older kernels) for all connection events
+EVENT_OUTPUT_MAPS(om_connection_events, 1 << 24);
+
+// Events lost because the ring buffer was full
+DROP_COUNTER_MAP(om_connection_drops);
 
 // Event struct that will be sent to Go on each new connection. (The name should be the same as the go generate command)
 struct Event {
@@ -32,155 +38,415 @@ struct Event {
 	u8 ipVersion;
 	u8 protocol;
 	u8 direction;
+	u8 icmpType;  // first byte of ICMP and ICMPv6 messages, 0 otherwise
+	u8 icmpCode;
+	u8 sockType;  // SOCK_STREAM, SOCK_DGRAM or SOCK_RAW
 };
 struct Event *unused __attribute__((unused));
 
-// Fentry of tcp_connect will be executed when equivalent kernel function is called.
-// In the kernel all IP address and ports should be set before tcp_connect is called. [this-function] -> tcp_connect 
-SEC("fentry/tcp_connect")
-int BPF_PROG(tcp_connect, struct sock *sk) {
+// Events are built here when writing to the perf event array.
+EVENT_SCRATCH_MAP(om_connection_scratch, struct Event);
+
+// Socket of a pending datagram connect by pid_tgid, for the kretprobes.
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_HASH);
+	__uint(max_entries, 4096);
+	__type(key, u64);
+	__type(value, u64);
+} om_connect_args SEC(".maps");
+
+// Messages of ping and raw sockets are reported at most once per second for
+// every process, peer and ICMP type, a flood shows up as a single line.
+#define ICMP_REPORT_INTERVAL 1000000000ULL
+
+struct icmp_key {
+	u32 pid;
+	u32 daddr[4];
+	u8  protocol;
+	u8  icmp_type;
+	u8  pad[2];
+};
+
+// Last report by icmp_key, in nanoseconds since boot
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_HASH);
+	__uint(max_entries, 8192);
+	__type(key, struct icmp_key);
+	__type(value, u64);
+} om_icmp_seen SEC(".maps");
+
+static __always_inline void emit_tcp_connect(void *ctx, struct sock *sk) {
 	// Alloc space for the event
 	struct Event *tcp_info;
-	tcp_info = bpf_ringbuf_reserve(&om_connection_events, sizeof(struct Event), 0);
+	tcp_info = event_reserve(&om_connection_events, &om_connection_scratch, sizeof(struct Event), &om_connection_drops);
 	if (!tcp_info) {
-		return 0;
+		return;
 	}
+	__builtin_memset(tcp_info, 0, sizeof(struct Event));
 
 	// Read PID (Careful: This is the Thread Group ID in kernel speak!)
 	tcp_info->pid = __builtin_bswap32((u32)(bpf_get_current_pid_tgid() >> 32));
 
 	// Set protocol
 	tcp_info->protocol = TCP;
+	tcp_info->sockType = SOCK_STREAM;
 
 	// Set direction
 	tcp_info->direction = OUTBOUND;
 
 	// Set src and dist ports
-	tcp_info->sport = __builtin_bswap16(sk->__sk_common.skc_num);
-	tcp_info->dport = sk->__sk_common.skc_dport;
+	tcp_info->sport = __builtin_bswap16(BPF_CORE_READ(sk, __sk_common.skc_num));
+	tcp_info->dport = BPF_CORE_READ(sk, __sk_common.skc_dport);
 
 	// Set src and dist IPs
-	if (sk->__sk_common.skc_family == AF_INET) {
-		tcp_info->saddr[0] = __builtin_bswap32(sk->__sk_common.skc_rcv_saddr);
-		tcp_info->daddr[0] = __builtin_bswap32(sk->__sk_common.skc_daddr);
+	u16 family = BPF_CORE_READ(sk, __sk_common.skc_family);
+	if (family == AF_INET) {
+		tcp_info->saddr[0] = __builtin_bswap32(BPF_CORE_READ(sk, __sk_common.skc_rcv_saddr));
+		tcp_info->daddr[0] = __builtin_bswap32(BPF_CORE_READ(sk, __sk_common.skc_daddr));
 		// Set IP version
 		tcp_info->ipVersion = 4;
-	} else if (sk->__sk_common.skc_family == AF_INET6) {
+	} else if (family == AF_INET6) {
+		u32 saddr[4], daddr[4];
+		BPF_CORE_READ_INTO(&saddr, sk, __sk_common.skc_v6_rcv_saddr.in6_u.u6_addr32);
+		BPF_CORE_READ_INTO(&daddr, sk, __sk_common.skc_v6_daddr.in6_u.u6_addr32);
 		for(int i = 0; i < 4; i++) {
-			tcp_info->saddr[i] = __builtin_bswap32(sk->__sk_common.skc_v6_rcv_saddr.in6_u.u6_addr32[i]);
+			tcp_info->saddr[i] = __builtin_bswap32(saddr[i]);
 		}
 		for(int i = 0; i < 4; i++) {
-			tcp_info->daddr[i] = __builtin_bswap32(sk->__sk_common.skc_v6_daddr.in6_u.u6_addr32[i]);
+			tcp_info->daddr[i] = __builtin_bswap32(daddr[i]);
 		}
 		// Set IP version
 		tcp_info->ipVersion = 6;
 	}
 
 	// Send event
-	bpf_ringbuf_submit(tcp_info, 0);
-	return 0;
-};
+	event_submit(ctx, &om_connection_events, &om_connection_events_perf, tcp_info, sizeof(struct Event), &om_connection_drops);
+}
 
-// Fexit(function exit) of udp_v4_connect will be executed after the ip4_datagram_connect kernel function is called.
-// ip4_datagram_connect -> udp_v4_connect
-SEC("fexit/ip4_datagram_connect")
-int BPF_PROG(udp_v4_connect, struct sock *sk) {
-	// Ignore everything else then IPv4
-	if (sk->__sk_common.skc_family != AF_INET) {
-		return 0;
+static __always_inline void emit_udp_connect(void *ctx, struct sock *sk, u16 family) {
+	// Ignore everything else then the family of the hook
+	if (BPF_CORE_READ(sk, __sk_common.skc_family) != family) {
+		return;
 	}
 
-	// ip4_datagram_connect return error
-	if (sk->__sk_common.skc_dport == 0) {
-		return 0;
+	// connect returned an error
+	if (BPF_CORE_READ(sk, __sk_common.skc_dport) == 0) {
+		return;
 	}
 
 	// Allocate space for the event.
 	struct Event *udp_info;
-	udp_info = bpf_ringbuf_reserve(&om_connection_events, sizeof(struct Event), 0);
+	udp_info = event_reserve(&om_connection_events, &om_connection_scratch, sizeof(struct Event), &om_connection_drops);
 	if (!udp_info) {
-		return 0;
+		return;
 	}
+	__builtin_memset(udp_info, 0, sizeof(struct Event));
 
 	// Read PID (Careful: This is the Thread Group ID in kernel speak!)
 	udp_info->pid = __builtin_bswap32((u32)(bpf_get_current_pid_tgid() >> 32));
 
+	// Set direction
+	udp_info->direction = OUTBOUND;
+
 	// Set src and dst ports
-	udp_info->sport = __builtin_bswap16(sk->__sk_common.skc_num);
-	udp_info->dport = sk->__sk_common.skc_dport;
+	udp_info->sport = __builtin_bswap16(BPF_CORE_READ(sk, __sk_common.skc_num));
+	udp_info->dport = BPF_CORE_READ(sk, __sk_common.skc_dport);
 
 	// Set src and dst IPs
-	udp_info->saddr[0] = __builtin_bswap32(sk->__sk_common.skc_rcv_saddr);
-	udp_info->daddr[0] = __builtin_bswap32(sk->__sk_common.skc_daddr);
-
-	// Set IP version
-	udp_info->ipVersion = 4;
+	if (family == AF_INET) {
+		udp_info->saddr[0] = __builtin_bswap32(BPF_CORE_READ(sk, __sk_common.skc_rcv_saddr));
+		udp_info->daddr[0] = __builtin_bswap32(BPF_CORE_READ(sk, __sk_common.skc_daddr));
+		udp_info->ipVersion = 4;
+	} else {
+		u32 saddr[4], daddr[4];
+		BPF_CORE_READ_INTO(&saddr, sk, __sk_common.skc_v6_rcv_saddr.in6_u.u6_addr32);
+		BPF_CORE_READ_INTO(&daddr, sk, __sk_common.skc_v6_daddr.in6_u.u6_addr32);
+		for(int i = 0; i < 4; i++) {
+			udp_info->saddr[i] = __builtin_bswap32(saddr[i]);
+		}
+		for(int i = 0; i < 4; i++) {
+			udp_info->daddr[i] = __builtin_bswap32(daddr[i]);
+		}
+		udp_info->ipVersion = 6;
+	}
 
 	// Set protocol
-	if(sk->sk_protocol == IPPROTO_UDPLITE) {
+	udp_info->sockType = SOCK_DGRAM;
+	if(BPF_CORE_READ(sk, sk_protocol) == IPPROTO_UDPLITE) {
 		udp_info->protocol = UDPLite;
 	} else {
 		udp_info->protocol = UDP;
 	}
 
 	// Send event
-	bpf_ringbuf_submit(udp_info, 0);
+	event_submit(ctx, &om_connection_events, &om_connection_events_perf, udp_info, sizeof(struct Event), &om_connection_drops);
+}
+
+// Fills the destination of a message of a ping or raw socket. Most of them
+// are unconnected and pass the peer in msg_name.
+static __always_inline void icmp_peer(struct Event *event, struct sock *sk, struct msghdr *msg, u16 family) {
+	u32 daddr[4] = {0};
+	struct sockaddr *name = BPF_CORE_READ(msg, msg_name);
+	u16 name_family = 0;
+	if (name) {
+		bpf_probe_read_kernel(&name_family, sizeof(name_family), &name->sa_family);
+	}
+
+	if (name_family == AF_INET) {
+		daddr[0] = BPF_CORE_READ((struct sockaddr_in *)name, sin_addr.s_addr);
+	} else if (name_family == AF_INET6) {
+		BPF_CORE_READ_INTO(&daddr, (struct sockaddr_in6 *)name, sin6_addr.in6_u.u6_addr32);
+	} else if (family == AF_INET) {
+		daddr[0] = BPF_CORE_READ(sk, __sk_common.skc_daddr);
+	} else {
+		BPF_CORE_READ_INTO(&daddr, sk, __sk_common.skc_v6_daddr.in6_u.u6_addr32);
+	}
+	for (int i = 0; i < 4; i++) {
+		event->daddr[i] = __builtin_bswap32(daddr[i]);
+	}
+}
+
+// Since 6.6 the flags of inet_sock are bits of inet_flags.
+struct inet_sock___flags {
+	unsigned long inet_flags;
+} __attribute__((preserve_access_index));
+
+#define INET_FLAGS_HDRINCL___flags 12
+
+// Whether messages of the raw socket start with the IP header (IP_HDRINCL,
+// IPV6_HDRINCL or an IPPROTO_RAW socket).
+static __always_inline bool ip_hdrincl(struct sock *sk) {
+	struct inet_sock___flags *flags = (void *)sk;
+	if (bpf_core_field_exists(flags->inet_flags)) {
+		return BPF_CORE_READ(flags, inet_flags) & (1UL << INET_FLAGS_HDRINCL___flags);
+	}
+	struct inet_sock *inet = (void *)sk;
+	return BPF_CORE_READ_BITFIELD_PROBED(inet, hdrincl);
+}
+
+// Returns the offset of the ICMP header in a message that starts with the IP
+// header, or -1 if the packet doesn't carry ICMP right after it.
+static __always_inline int icmp_offset(struct user_seg *seg, u16 family) {
+	u8 ip[10];
+	if (seg->len < sizeof(ip) || bpf_probe_read_user(&ip, sizeof(ip), (void *)seg->base) != 0) {
+		return -1;
+	}
+	if (family == AF_INET) {
+		// The protocol field follows the TTL, the header length is in words.
+		return ip[9] == ICMP ? (ip[0] & 0x0f) * 4 : -1;
+	}
+	// Extension headers before the ICMPv6 header are not followed.
+	return ip[6] == ICMPV6 ? 40 : -1;
+}
+
+// Emits an event for a message sent on a ping or raw socket, unless the same
+// process sent the same kind of message to the peer just before.
+static __always_inline void emit_icmp_send(void *ctx, struct sock *sk, struct msghdr *msg, u16 family) {
+	struct Event event = {0};
+
+	event.pid = __builtin_bswap32((u32)(bpf_get_current_pid_tgid() >> 32));
+	event.direction = OUTBOUND;
+	event.protocol = BPF_CORE_READ(sk, sk_protocol);
+	event.sockType = BPF_CORE_READ(sk, sk_type);
+	event.ipVersion = family == AF_INET ? 4 : 6;
+
+	if (family == AF_INET) {
+		event.saddr[0] = __builtin_bswap32(BPF_CORE_READ(sk, __sk_common.skc_rcv_saddr));
+	} else {
+		u32 saddr[4];
+		BPF_CORE_READ_INTO(&saddr, sk, __sk_common.skc_v6_rcv_saddr.in6_u.u6_addr32);
+		for (int i = 0; i < 4; i++) {
+			event.saddr[i] = __builtin_bswap32(saddr[i]);
+		}
+	}
+	icmp_peer(&event, sk, msg, family);
+
+	// The identifier of ping sockets takes the place of the port.
+	if (event.sockType == SOCK_DGRAM) {
+		event.sport = __builtin_bswap16(BPF_CORE_READ(sk, __sk_common.skc_num));
+	}
+
+	// The message starts with the ICMP header, type and code come first. With
+	// IP_HDRINCL the IP header comes before it.
+	if (event.protocol == ICMP || event.protocol == ICMPV6) {
+		u8 header[2];
+		struct user_seg segs[MSG_SEGS] = {0};
+		int offset = 0;
+		if (iter_segs(&msg->msg_iter, segs) > 0) {
+			if (event.sockType == SOCK_RAW && ip_hdrincl(sk)) {
+				offset = icmp_offset(&segs[0], family);
+			}
+			if (offset >= 0 && segs[0].len >= offset + sizeof(header) &&
+			    bpf_probe_read_user(&header, sizeof(header), (void *)(segs[0].base + offset)) == 0) {
+				event.icmpType = header[0];
+				event.icmpCode = header[1];
+			}
+		}
+	}
+
+	struct icmp_key key = {0};
+	key.pid = event.pid;
+	__builtin_memcpy(key.daddr, event.daddr, sizeof(key.daddr));
+	key.protocol = event.protocol;
+	key.icmp_type = event.icmpType;
+
+	u64 now = bpf_ktime_get_ns();
+	u64 *last = bpf_map_lookup_elem(&om_icmp_seen, &key);
+	if (last && now - *last < ICMP_REPORT_INTERVAL) {
+		return;
+	}
+	bpf_map_update_elem(&om_icmp_seen, &key, &now, BPF_ANY);
+
+	event_output(ctx, &om_connection_events, &om_connection_events_perf, &event, sizeof(event), &om_connection_drops);
+}
+
+#ifdef bpf_target_defined
+// Remembers the socket of a datagram connect for the kretprobe.
+static __always_inline void save_connect_sock(struct sock *sk) {
+	u64 id = bpf_get_current_pid_tgid();
+	u64 ptr = (u64)sk;
+	bpf_map_update_elem(&om_connect_args, &id, &ptr, BPF_ANY);
+}
+
+// Returns the socket saved on entry of the datagram connect.
+static __always_inline struct sock *take_connect_sock(void) {
+	u64 id = bpf_get_current_pid_tgid();
+	u64 *ptr = bpf_map_lookup_elem(&om_connect_args, &id);
+	if (!ptr) {
+		return NULL;
+	}
+	struct sock *sk = (struct sock *)*ptr;
+	bpf_map_delete_elem(&om_connect_args, &id);
+	return sk;
+}
+#endif
+
+// Fentry of tcp_connect will be executed when equivalent kernel function is called.
+// In the kernel all IP address and ports should be set before tcp_connect is called. [this-function] -> tcp_connect 
+SEC("fentry/tcp_connect")
+int BPF_PROG(tcp_connect, struct sock *sk) {
+	emit_tcp_connect(ctx, sk);
+	return 0;
+};
+
+#ifdef bpf_target_defined
+// Same as tcp_connect for kernels without fentry support.
+SEC("kprobe/tcp_connect")
+int BPF_KPROBE(tcp_connect_kprobe, struct sock *sk) {
+	emit_tcp_connect(ctx, sk);
+	return 0;
+}
+#endif
+
+// Fexit(function exit) of udp_v4_connect will be executed after the ip4_datagram_connect kernel function is called.
+// ip4_datagram_connect -> udp_v4_connect
+SEC("fexit/ip4_datagram_connect")
+int BPF_PROG(udp_v4_connect, struct sock *sk) {
+	emit_udp_connect(ctx, sk, AF_INET);
+	return 0;
+}
+
+#ifdef bpf_target_defined
+// Same as udp_v4_connect for kernels without fentry support. The socket is
+// only passed on entry.
+SEC("kprobe/ip4_datagram_connect")
+int BPF_KPROBE(udp_v4_connect_kprobe, struct sock *sk) {
+	save_connect_sock(sk);
 	return 0;
 }
 
+SEC("kretprobe/ip4_datagram_connect")
+int BPF_KRETPROBE(udp_v4_connect_kretprobe) {
+	struct sock *sk = take_connect_sock();
+	if (sk) {
+		emit_udp_connect(ctx, sk, AF_INET);
+	}
+	return 0;
+}
+#endif
+
 // Fentry(function enter) of udp_v6_connect will be executed after the ip6_datagram_connect kernel function is called.
 // ip6_datagram_connect -> udp_v6_connect
 SEC("fexit/ip6_datagram_connect")
 int BPF_PROG(udp_v6_connect, struct sock *sk) {
-	// Ignore everything else then IPv6
-	if (sk->__sk_common.skc_family != AF_INET6) {
-		return 0;
-	}
-
-	// ip6_datagram_connect return error
-	if (sk->__sk_common.skc_dport == 0) {
-		return 0;
-	}
-
 	// Make sure its udp6 socket
 	struct udp6_sock *us = bpf_skc_to_udp6_sock(sk);
 	if (!us) {
 		return 0;
 	}
 
-	// Allocate space for the event.
-	struct Event *udp_info;
-	udp_info = bpf_ringbuf_reserve(&om_connection_events, sizeof(struct Event), 0);
-	if (!udp_info) {
-		return 0;
+	emit_udp_connect(ctx, sk, AF_INET6);
+	return 0;
+}
+
+#ifdef bpf_target_defined
+// Same as udp_v6_connect for kernels without fentry support. The socket is
+// only passed on entry.
+SEC("kprobe/ip6_datagram_connect")
+int BPF_KPROBE(udp_v6_connect_kprobe, struct sock *sk) {
+	save_connect_sock(sk);
+	return 0;
+}
+
+SEC("kretprobe/ip6_datagram_connect")
+int BPF_KRETPROBE(udp_v6_connect_kretprobe) {
+	struct sock *sk = take_connect_sock();
+	if (sk && BPF_CORE_READ(sk, sk_type) == SOCK_DGRAM) {
+		emit_udp_connect(ctx, sk, AF_INET6);
 	}
+	return 0;
+}
+#endif
 
-	// Read PID (Careful: This is the Thread Group ID in kernel speak!)
-	udp_info->pid = __builtin_bswap32((u32)(bpf_get_current_pid_tgid() >> 32));
+// ping_v4_sendmsg and ping_v6_sendmsg see ICMP echo requests of unprivileged
+// ping sockets, raw_sendmsg and rawv6_sendmsg everything sent on raw sockets.
+SEC("fentry/ping_v4_sendmsg")
+int BPF_PROG(ping_v4_sendmsg, struct sock *sk, struct msghdr *msg) {
+	emit_icmp_send(ctx, sk, msg, AF_INET);
+	return 0;
+}
 
-	// Set src and dst ports
-	udp_info->sport = __builtin_bswap16(sk->__sk_common.skc_num);
-	udp_info->dport = sk->__sk_common.skc_dport;
+SEC("fentry/ping_v6_sendmsg")
+int BPF_PROG(ping_v6_sendmsg, struct sock *sk, struct msghdr *msg) {
+	emit_icmp_send(ctx, sk, msg, AF_INET6);
+	return 0;
+}
 
-	// Set src and dst IPs
-	for(int i = 0; i < 4; i++) {
-		udp_info->saddr[i] = __builtin_bswap32(sk->__sk_common.skc_v6_rcv_saddr.in6_u.u6_addr32[i]);
-	}
-	for(int i = 0; i < 4; i++) {
-		udp_info->daddr[i] = __builtin_bswap32(sk->__sk_common.skc_v6_daddr.in6_u.u6_addr32[i]);
-	}
+SEC("fentry/raw_sendmsg")
+int BPF_PROG(raw_sendmsg, struct sock *sk, struct msghdr *msg) {
+	emit_icmp_send(ctx, sk, msg, AF_INET);
+	return 0;
+}
 
-	// IP version
-	udp_info->ipVersion = 6;
+SEC("fentry/rawv6_sendmsg")
+int BPF_PROG(rawv6_sendmsg, struct sock *sk, struct msghdr *msg) {
+	emit_icmp_send(ctx, sk, msg, AF_INET6);
+	return 0;
+}
 
-	// Set protocol
-	if(sk->sk_protocol == IPPROTO_UDPLITE) {
-		udp_info->protocol = UDPLite;
-	} else {
-		udp_info->protocol = UDP;
-	}
+#ifdef bpf_target_defined
+// Same as the hooks above for kernels without fentry support.
+SEC("kprobe/ping_v4_sendmsg")
+int BPF_KPROBE(ping_v4_sendmsg_kprobe, struct sock *sk, struct msghdr *msg) {
+	emit_icmp_send(ctx, sk, msg, AF_INET);
+	return 0;
+}
 
-	// Send event
-	bpf_ringbuf_submit(udp_info, 0);
+SEC("kprobe/ping_v6_sendmsg")
+int BPF_KPROBE(ping_v6_sendmsg_kprobe, struct sock *sk, struct msghdr *msg) {
+	emit_icmp_send(ctx, sk, msg, AF_INET6);
+	return 0;
+}
+
+SEC("kprobe/raw_sendmsg")
+int BPF_KPROBE(raw_sendmsg_kprobe, struct sock *sk, struct msghdr *msg) {
+	emit_icmp_send(ctx, sk, msg, AF_INET);
 	return 0;
-}
\ No newline at end of file
+}
+
+SEC("kprobe/rawv6_sendmsg")
+int BPF_KPROBE(rawv6_sendmsg_kprobe, struct sock *sk, struct msghdr *msg) {
+	emit_icmp_send(ctx, sk, msg, AF_INET6);
+	return 0;
+}
+#endif
@@ -0,0 +1,84 @@
+// Access to the data of a struct msghdr passed to the sendmsg and recvmsg
+// functions of the kernel.
+
+#ifndef __OM_MSG_H
+#define __OM_MSG_H
+
+// Before 5.14 the iterator type is a bit mask that includes the direction.
+struct iov_iter___type {
+	unsigned int type;
+} __attribute__((preserve_access_index));
+
+#define ITER_IOVEC___type 4
+
+// Since 6.4 the iovec array is __iov.
+struct iov_iter___iov {
+	const struct iovec *__iov;
+} __attribute__((preserve_access_index));
+
+// Number of buffers of an iterator that are looked at. glibc sends DNS over
+// TCP with writev, the length prefix and the message in buffers of their own.
+#define MSG_SEGS 4
+
+// A buffer in user space.
+struct user_seg {
+	u64 base;
+	u64 len;
+};
+
+// Fills segs with the user space buffers of the iterator from its current
+// position on and returns how many there are. The values of enum iter_type
+// differ between kernels, they are relocated.
+static __always_inline int iter_segs(struct iov_iter *iter, struct user_seg segs[MSG_SEGS]) {
+	u64 offset = BPF_CORE_READ(iter, iov_offset);
+
+	if (bpf_core_field_exists(iter->iter_type)) {
+		u8 type = BPF_CORE_READ(iter, iter_type);
+		if (bpf_core_enum_value_exists(enum iter_type, ITER_UBUF) &&
+		    type == bpf_core_enum_value(enum iter_type, ITER_UBUF)) {
+			segs[0].base = (u64)BPF_CORE_READ(iter, ubuf) + offset;
+			segs[0].len = BPF_CORE_READ(iter, count);
+			return 1;
+		}
+		if (type != bpf_core_enum_value(enum iter_type, ITER_IOVEC)) {
+			return 0;
+		}
+	} else {
+		struct iov_iter___type *old = (void *)iter;
+		if (!(BPF_CORE_READ(old, type) & ITER_IOVEC___type)) {
+			return 0;
+		}
+	}
+
+	const struct iovec *iov;
+	struct iov_iter___iov *renamed = (void *)iter;
+	if (bpf_core_field_exists(renamed->__iov)) {
+		iov = BPF_CORE_READ(renamed, __iov);
+	} else {
+		iov = BPF_CORE_READ(iter, iov);
+	}
+	u64 nr_segs = BPF_CORE_READ(iter, nr_segs);
+
+	int n = 0;
+	for (int i = 0; i < MSG_SEGS && i < nr_segs; i++) {
+		struct iovec vec;
+		if (bpf_probe_read_kernel(&vec, sizeof(vec), &iov[i])) {
+			break;
+		}
+		segs[i].base = (u64)vec.iov_base;
+		segs[i].len = vec.iov_len;
+		n++;
+	}
+
+	// The offset is into the current buffer, the first one.
+	if (n > 0) {
+		if (offset > segs[0].len) {
+			return 0;
+		}
+		segs[0].base += offset;
+		segs[0].len -= offset;
+	}
+	return n;
+}
+
+#endif
@@ -0,0 +1,83 @@
+// Event output that works with and without ring buffers. Every event stream
+// is declared with EVENT_OUTPUT_MAPS, which creates a ring buffer and a perf
+// event array next to it. The loader sets use_ringbuf depending on what the
+// kernel supports, the verifier then drops the branch that is not taken.
+//
+// With a ring buffer events are reserved in place. With a perf event array
+// they are built in a per CPU scratch map declared with EVENT_SCRATCH_MAP and
+// copied out on submit.
+
+#ifndef __OM_OUTPUT_H
+#define __OM_OUTPUT_H
+
+#include "drops.h"
+
+const volatile bool use_ringbuf = true;
+
+#define EVENT_OUTPUT_MAPS(name, size)                    \
+	struct {                                             \
+		__uint(type, BPF_MAP_TYPE_RINGBUF);              \
+		__uint(max_entries, size);                       \
+	} name SEC(".maps");                                 \
+	struct {                                             \
+		__uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);     \
+		__uint(key_size, sizeof(u32));                   \
+		__uint(value_size, sizeof(u32));                 \
+	} name##_perf SEC(".maps")
+
+#define EVENT_SCRATCH_MAP(name, event_type)              \
+	struct {                                             \
+		__uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);         \
+		__uint(max_entries, 1);                          \
+		__type(key, u32);                                \
+		__type(value, event_type);                       \
+	} name SEC(".maps")
+
+// Returns space for an event of size bytes, or NULL if the ring buffer is
+// full. The drop is counted in the map declared with DROP_COUNTER_MAP.
+static __always_inline void *event_reserve(void *ringbuf, void *scratch, u64 size, void *drops) {
+	void *event;
+	if (use_ringbuf) {
+		event = bpf_ringbuf_reserve(ringbuf, size, 0);
+	} else {
+		u32 zero = 0;
+		event = bpf_map_lookup_elem(scratch, &zero);
+	}
+	if (!event) {
+		count_drop(drops);
+	}
+	return event;
+}
+
+// Hands a reserved event to userspace.
+static __always_inline void event_submit(void *ctx, void *ringbuf, void *perf, void *event, u64 size, void *drops) {
+	if (use_ringbuf) {
+		bpf_ringbuf_submit(event, 0);
+		return;
+	}
+	if (bpf_perf_event_output(ctx, perf, BPF_F_CURRENT_CPU, event, size)) {
+		count_drop(drops);
+	}
+}
+
+// Gives back a reserved event without sending it.
+static __always_inline void event_discard(void *event) {
+	if (use_ringbuf) {
+		bpf_ringbuf_discard(event, 0);
+	}
+}
+
+// Copies size bytes of data to userspace.
+static __always_inline void event_output(void *ctx, void *ringbuf, void *perf, void *data, u64 size, void *drops) {
+	long ret;
+	if (use_ringbuf) {
+		ret = bpf_ringbuf_output(ringbuf, data, size, 0);
+	} else {
+		ret = bpf_perf_event_output(ctx, perf, BPF_F_CURRENT_CPU, data, size);
+	}
+	if (ret) {
+		count_drop(drops);
+	}
+}
+
+#endif
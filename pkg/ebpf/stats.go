@@ -0,0 +1,83 @@
+package ebpf
+
+import (
+	"sync/atomic"
+
+	"github.com/cilium/ebpf"
+)
+
+// RingBufferStats is a snapshot of the counters of a ring buffer consumer.
+type RingBufferStats struct {
+	Name          string
+	Received      uint64 // events decoded and handed on
+	ReserveFailed uint64 // events the kernel could not write, the ring buffer was full
+	ReadErrors    uint64 // failed reads from the ring buffer
+	DecodeErrors  uint64 // samples that could not be decoded
+}
+
+// Lost returns the number of events that were not handed on.
+func (s RingBufferStats) Lost() uint64 {
+	return s.ReserveFailed + s.ReadErrors + s.DecodeErrors
+}
+
+// RingBufferCounters counts the events of a ring buffer consumer and those
+// lost on the way from the eBPF program. It is safe for concurrent use.
+type RingBufferCounters struct {
+	name         string
+	kernel       atomic.Pointer[ebpf.Map]
+	received     atomic.Uint64
+	readErrors   atomic.Uint64
+	decodeErrors atomic.Uint64
+}
+
+func NewRingBufferCounters(name string) *RingBufferCounters {
+	return &RingBufferCounters{name: name}
+}
+
+// SetDropMap sets the per CPU counter map declared with DROP_COUNTER_MAP in
+// the eBPF program.
+func (c *RingBufferCounters) SetDropMap(m *ebpf.Map) {
+	c.kernel.Store(m)
+}
+
+func (c *RingBufferCounters) Received() {
+	c.received.Add(1)
+}
+
+func (c *RingBufferCounters) ReadError() {
+	c.readErrors.Add(1)
+}
+
+func (c *RingBufferCounters) DecodeError() {
+	c.decodeErrors.Add(1)
+}
+
+// Stats returns the current counters.
+func (c *RingBufferCounters) Stats() RingBufferStats {
+	return RingBufferStats{
+		Name:          c.name,
+		Received:      c.received.Load(),
+		ReserveFailed: c.kernelDrops(),
+		ReadErrors:    c.readErrors.Load(),
+		DecodeErrors:  c.decodeErrors.Load(),
+	}
+}
+
+// kernelDrops sums the per CPU values of the drop counter map.
+func (c *RingBufferCounters) kernelDrops() uint64 {
+	m := c.kernel.Load()
+	if m == nil {
+		return 0
+	}
+
+	var perCPU []uint64
+	if err := m.Lookup(uint32(0), &perCPU); err != nil {
+		return 0
+	}
+
+	var total uint64
+	for _, v := range perCPU {
+		total += v
+	}
+	return total
+}
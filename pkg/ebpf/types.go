@@ -1,5 +1,12 @@
 package ebpf
 
+import (
+	"net"
+	"time"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/flow"
+)
+
 // ConnectionEvent matches the Event struct in monitor.c
 type ConnectionEvent struct {
 	SrcAddr   [4]uint32
@@ -10,22 +17,174 @@ type ConnectionEvent struct {
 	IPVersion uint8
 	Protocol  uint8
 	Direction uint8
+	ICMPType  uint8 // ICMP and ICMPv6 only
+	ICMPCode  uint8
+	SockType  uint8
 }
 
-// BandwidthInfo matches the sk_info struct in bandwidth.c
+// Socket types of ConnectionEvent.SockType, as in the kernel.
+const (
+	SockStream uint8 = 1
+	SockDgram  uint8 = 2
+	SockRaw    uint8 = 3
+)
+
+// BandwidthInfo matches the sk_info struct in bandwidth.c. The worker sends
+// the totals over all sockets together with the per connection values.
 type BandwidthInfo struct {
 	RX       uint64
 	TX       uint64
 	Reported uint64
+
+	Connections []ConnectionBandwidth
+	Health      []ConnectionHealth
+}
+
+// ConnectionBandwidth is the traffic of a single socket in om_bandwidth_map.
+type ConnectionBandwidth struct {
+	Flow flow.Flow
+	RX   uint64
+	TX   uint64
+}
+
+// ConnectionHealth is the tcp_health struct in bandwidth.c of a single TCP
+// connection.
+type ConnectionHealth struct {
+	Flow           flow.Flow
+	SRTT           time.Duration // smoothed round trip time
+	Retransmits    uint64        // retransmitted segments since the connection was first seen
+	RetransmitRate float64       // retransmitted segments per second since the previous update
+	Resets         uint64        // resets sent
+	State          TCPState
+}
+
+// TCPState is the state of a TCP socket as used by the kernel.
+type TCPState uint8
+
+// TCP states, matching BPF_TCP_* in the kernel
+const (
+	TCPEstablished TCPState = iota + 1
+	TCPSynSent
+	TCPSynRecv
+	TCPFinWait1
+	TCPFinWait2
+	TCPTimeWait
+	TCPClose
+	TCPCloseWait
+	TCPLastAck
+	TCPListen
+	TCPClosing
+	TCPNewSynRecv
+)
+
+func (s TCPState) String() string {
+	switch s {
+	case TCPEstablished:
+		return "ESTABLISHED"
+	case TCPSynSent:
+		return "SYN_SENT"
+	case TCPSynRecv:
+		return "SYN_RECV"
+	case TCPFinWait1:
+		return "FIN_WAIT1"
+	case TCPFinWait2:
+		return "FIN_WAIT2"
+	case TCPTimeWait:
+		return "TIME_WAIT"
+	case TCPClose:
+		return "CLOSE"
+	case TCPCloseWait:
+		return "CLOSE_WAIT"
+	case TCPLastAck:
+		return "LAST_ACK"
+	case TCPListen:
+		return "LISTEN"
+	case TCPClosing:
+		return "CLOSING"
+	case TCPNewSynRecv:
+		return "NEW_SYN_RECV"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Exec event types, matching the EVENT_* defines in exec.c
+const (
+	ExecEventExec    uint32 = 1 // execve/execveat entered
+	ExecEventExecRet uint32 = 2 // execve/execveat returned
+	ExecEventExit    uint32 = 3 // process exited
+)
+
+// ExecEventHeader matches the event_hdr_t struct in exec.c
+type ExecEventHeader struct {
+	Type uint32
+	PID  uint32
+	PPID uint32
+	// Retval is the return code of execve for ExecEventExecRet and the wait
+	// status of the process for ExecEventExit.
+	Retval int32
 }
 
-// ExecEvent matches the event_t struct in exec.c
+// ExecTruncation flags, matching the TRUNC_* defines in exec.c
+type ExecTruncation uint8
+
+const (
+	TruncatedFilename ExecTruncation = 1 << iota // filename was cut
+	TruncatedArg                                 // at least one argument was cut
+	TruncatedArgc                                // there were more arguments than captured
+	TruncatedData                                // arguments were dropped for lack of space
+)
+
+// ExecEvent is the decoded event_t struct of exec.c. Only the header is set
+// for events other than ExecEventExec.
 type ExecEvent struct {
-	Filename [1024]byte
-	Argv     [32][1024]byte
-	Argc     uint32
-	UID      uint32
-	GID      uint32
-	PID      uint32
-	Comm     [1024]byte
+	ExecEventHeader
+	UID       uint32
+	GID       uint32
+	Comm      string
+	Filename  string
+	Argv      []string
+	Truncated ExecTruncation
+}
+
+// DNSEvent is the decoded dns_event struct of dns.c
+type DNSEvent struct {
+	PID        uint32
+	Comm       string
+	Server     net.IP
+	ServerPort uint16
+	Length     uint16 // size of the whole message
+	IPVersion  uint8
+	Protocol   uint8
+	Response   bool
+	Payload    []byte // captured part of the message, at most 512 bytes
+}
+
+// Listen event types, matching the LISTEN_* defines in bandwidth.c
+const (
+	ListenStart uint8 = 1 // TCP socket started listening or UDP socket was bound
+	ListenStop  uint8 = 2 // socket closed
+)
+
+// ListenEvent is the decoded listen_event struct of bandwidth.c
+type ListenEvent struct {
+	Type      uint8
+	PID       uint32 // process that called listen, bind or close
+	Comm      string
+	Addr      net.IP
+	Port      uint16
+	IPVersion uint8
+	Protocol  uint8
+}
+
+// BlockEvent is the decoded block_event struct of enforce.c, sent for every
+// connect or send rejected in the kernel.
+type BlockEvent struct {
+	PID       uint32
+	RuleID    uint32
+	Comm      string
+	Addr      net.IP
+	Port      uint16
+	IPVersion uint8
+	Protocol  uint8
 }
@@ -0,0 +1,116 @@
+// Package flow is the decoded form of a connection. The eBPF programs, the
+// packet queues and the bandwidth maps each describe connections in their
+// own byte orders, converted to a Flow they can be joined by Key.
+package flow
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+)
+
+// Protocol is an IP protocol number.
+type Protocol uint8
+
+const (
+	ICMP    Protocol = 1
+	TCP     Protocol = 6
+	UDP     Protocol = 17
+	ICMPv6  Protocol = 58
+	UDPLite Protocol = 136
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case ICMP:
+		return "ICMP"
+	case TCP:
+		return "TCP"
+	case UDP:
+		return "UDP"
+	case ICMPv6:
+		return "ICMPv6"
+	case UDPLite:
+		return "UDPLite"
+	default:
+		return strconv.Itoa(int(p))
+	}
+}
+
+// HasPorts returns whether flows of the protocol are told apart by port.
+func (p Protocol) HasPorts() bool {
+	return p == TCP || p == UDP || p == UDPLite
+}
+
+// Direction is who opened a flow, as seen from this host.
+type Direction uint8
+
+const (
+	Unknown  Direction = iota // e.g. bandwidth entries
+	Outbound                  // opened by a local process
+	Inbound                   // opened by the remote host
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Unknown:
+		return "unknown"
+	case Outbound:
+		return "outbound"
+	case Inbound:
+		return "inbound"
+	default:
+		return fmt.Sprintf("Direction(%d)", uint8(d))
+	}
+}
+
+// Flow is a connection with addresses in their canonical form: IPv4
+// addresses are never IPv4-mapped, ports are in host byte order. Ports are
+// zero for protocols without them.
+type Flow struct {
+	Protocol  Protocol
+	Direction Direction
+	Local     netip.AddrPort
+	Remote    netip.AddrPort
+	PID       uint32 // 0 if unknown
+}
+
+// New returns a flow with the addresses brought into canonical form.
+func New(protocol Protocol, direction Direction, local, remote netip.AddrPort) Flow {
+	return Flow{
+		Protocol:  protocol,
+		Direction: direction,
+		Local:     netip.AddrPortFrom(local.Addr().Unmap(), local.Port()),
+		Remote:    netip.AddrPortFrom(remote.Addr().Unmap(), remote.Port()),
+	}
+}
+
+// Key is what every source knows about a flow. The local address is left
+// out, sockets bound to any address don't report it everywhere.
+type Key struct {
+	Protocol  Protocol
+	LocalPort uint16
+	Remote    netip.AddrPort
+}
+
+// Key returns the key to join the flow with flows from other sources.
+func (f Flow) Key() Key {
+	return Key{Protocol: f.Protocol, LocalPort: f.Local.Port(), Remote: f.Remote}
+}
+
+// String formats the flow as "TCP 10.0.0.1:4000 -> 1.1.1.1:443", inbound
+// flows with the arrow pointing the other way.
+func (f Flow) String() string {
+	arrow := "->"
+	if f.Direction == Inbound {
+		arrow = "<-"
+	}
+	return fmt.Sprintf("%s %s %s %s", f.Protocol, f.endpoint(f.Local), arrow, f.endpoint(f.Remote))
+}
+
+func (f Flow) endpoint(ap netip.AddrPort) string {
+	if !f.Protocol.HasPorts() {
+		return ap.Addr().String()
+	}
+	return ap.String()
+}
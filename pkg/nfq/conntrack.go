@@ -5,9 +5,12 @@ package nfq
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 
 	ct "github.com/florianl/go-conntrack"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/flow"
 )
 
 var nfct *ct.Nfct
@@ -35,7 +38,7 @@ func DeleteAllMarkedConnection() error {
 
 	// Delete IPv4 marked connections
 	deleted := deleteMarkedConnections(nfct, ct.IPv4)
-	fmt.Printf("deleted %d conntrack entries\n", deleted)
+	slog.Info("Deleted conntrack entries", "count", deleted)
 	return nil
 }
 
@@ -49,13 +52,13 @@ func deleteMarkedConnections(nfct *ct.Nfct, f ct.Family) (deleted int) {
 		*filter.Mark = markValue
 		conns, err := nfct.Dump(ct.Conntrack, f)
 		if err != nil {
-			fmt.Printf("error on conntrack query: %s\n", err)
+			slog.Warn("Failed to query conntrack", "error", err)
 			continue
 		}
 
 		for _, conn := range conns {
 			if err := nfct.Delete(ct.Conntrack, f, conn); err != nil {
-				fmt.Printf("failed to delete connection: %s\n", err)
+				slog.Warn("Failed to delete connection", "error", err)
 				continue
 			}
 			deleted++
@@ -65,6 +68,8 @@ func deleteMarkedConnections(nfct *ct.Nfct, f ct.Family) (deleted int) {
 	return deleted
 }
 
+// Connection is the origin tuple of a conntrack entry, as sent by the host
+// that opened the connection.
 type Connection struct {
 	SrcIP    net.IP
 	DstIP    net.IP
@@ -73,11 +78,18 @@ type Connection struct {
 	DstPort  uint16
 }
 
+// DeleteConnection removes the conntrack entry of an IPv4 or IPv6
+// connection, the next packet of it is queued again as a new connection.
 func DeleteConnection(conn *Connection) error {
 	if nfct == nil {
 		return errors.New("conntrack not initialized")
 	}
 
+	family := ct.IPv4
+	if conn.SrcIP.To4() == nil {
+		family = ct.IPv6
+	}
+
 	con := ct.Con{
 		Origin: &ct.IPTuple{
 			Src: &conn.SrcIP,
@@ -90,5 +102,25 @@ func DeleteConnection(conn *Connection) error {
 		},
 	}
 
-	return nfct.Delete(ct.Conntrack, ct.IPv4, con)
+	return nfct.Delete(ct.Conntrack, family, con)
+}
+
+// DeleteFlow removes the conntrack entry of a TCP, UDP or UDP-Lite flow. The origin of
+// an inbound flow is the remote host.
+func DeleteFlow(f flow.Flow) error {
+	if !f.Protocol.HasPorts() {
+		return fmt.Errorf("%s connections can't be deleted", f.Protocol)
+	}
+
+	origin, reply := f.Local, f.Remote
+	if f.Direction == flow.Inbound {
+		origin, reply = reply, origin
+	}
+	return DeleteConnection(&Connection{
+		SrcIP:    origin.Addr().AsSlice(),
+		DstIP:    reply.Addr().AsSlice(),
+		Protocol: uint8(f.Protocol),
+		SrcPort:  origin.Port(),
+		DstPort:  reply.Port(),
+	})
 }
@@ -2,6 +2,7 @@ package nfq
 
 import (
 	"fmt"
+	"log/slog"
 	"strings"
 
 	"github.com/coreos/go-iptables/iptables"
@@ -152,7 +153,7 @@ func flushIPTables() error {
 			// Try to delete custom chains
 			if err := ipt.DeleteChain(table, chain); err != nil {
 				// Ignore errors here as chain might be in use
-				fmt.Printf("Warning: could not delete chain %s in table %s: %v\n", chain, table, err)
+				slog.Warn("Could not delete chain", "chain", chain, "table", table, "error", err)
 			}
 		}
 	}
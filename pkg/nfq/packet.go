@@ -3,11 +3,14 @@ package nfq
 import (
 	"fmt"
 	"net"
+	"net/netip"
 	"sync/atomic"
 	"time"
 
 	"github.com/florianl/go-nfqueue"
 	"github.com/tevino/abool"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/flow"
 )
 
 // Packet represents a network packet
@@ -26,6 +29,26 @@ type Packet struct {
 	verdictPending *abool.AtomicBool
 }
 
+// Flow returns the connection the packet belongs to, local and remote
+// picked by the queue it came from.
+func (p *Packet) Flow() flow.Flow {
+	src, _ := netip.AddrFromSlice(p.SrcIP)
+	dst, _ := netip.AddrFromSlice(p.DstIP)
+	srcPort, dstPort := p.SrcPort, p.DstPort
+	if !flow.Protocol(p.Protocol).HasPorts() {
+		srcPort, dstPort = 0, 0
+	}
+
+	local := netip.AddrPortFrom(src, srcPort)
+	remote := netip.AddrPortFrom(dst, dstPort)
+	direction := flow.Outbound
+	if p.Inbound {
+		local, remote = remote, local
+		direction = flow.Inbound
+	}
+	return flow.New(flow.Protocol(p.Protocol), direction, local, remote)
+}
+
 // Mark constants for packet handling
 const (
 	MarkAccept       = 1700
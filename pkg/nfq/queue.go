@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
+	"log/slog"
 	"net"
 	"runtime"
 	"sync/atomic"
@@ -134,7 +135,7 @@ func (q *Queue) open(ctx context.Context) error {
 			case <-ctx.Done():
 				return 0
 			default:
-				fmt.Printf("nfqueue error: %v\n", e)
+				slog.Warn("nfqueue error", "queue", q.id, "error", e)
 				return 1
 			}
 		},
@@ -164,7 +165,7 @@ func (q *Queue) handlePacket(attr nfqueue.Attribute) int {
 	}
 
 	if attr.Payload == nil {
-		fmt.Printf("Warning: packet #%d has no payload\n", pkt.ID)
+		slog.Warn("Packet has no payload", "queue", q.id, "packet", pkt.ID)
 		return 0
 	}
 
@@ -0,0 +1,74 @@
+package policy
+
+import (
+	"sync"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/flow"
+	"github.com/lonelysadness/OpenMonitor/pkg/nfq"
+	"github.com/lonelysadness/OpenMonitor/pkg/sockets"
+)
+
+// Key identifies a socket by protocol and local port, which is what
+// connection events, queued packets and bandwidth entries have in common.
+type Key struct {
+	Protocol  flow.Protocol
+	LocalPort uint16
+}
+
+// Owners maps local sockets to the processes owning them. The monitor fills
+// it from connection events, the pipeline reads it for every packet, it is
+// safe for concurrent use.
+type Owners struct {
+	lock      sync.RWMutex
+	byKey     map[Key]uint32
+	listeners *sockets.Inventory
+}
+
+// NewOwners returns an empty map. Packets to ports without a connection
+// event are attributed to the process listening on them.
+func NewOwners(listeners *sockets.Inventory) *Owners {
+	return &Owners{
+		byKey:     make(map[Key]uint32),
+		listeners: listeners,
+	}
+}
+
+// Set records the owner of a socket.
+func (o *Owners) Set(key Key, pid uint32) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.byKey[key] = pid
+}
+
+// Lookup returns the owner of a socket seen in a connection event.
+func (o *Owners) Lookup(key Key) (uint32, bool) {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	pid, ok := o.byKey[key]
+	return pid, ok
+}
+
+// PacketOwner returns the PID owning the local end of a queued packet.
+func (o *Owners) PacketOwner(pkt nfq.Packet, isInbound bool) (uint32, bool) {
+	localPort := pkt.SrcPort
+	if isInbound {
+		localPort = pkt.DstPort
+	}
+	if pid, ok := o.Lookup(Key{Protocol: flow.Protocol(pkt.Protocol), LocalPort: localPort}); ok {
+		return pid, true
+	}
+	// Inbound connections to a server have no connection event, the
+	// listening socket tells who owns the port.
+	return o.listeners.Owner(pkt.Protocol, localPort)
+}
+
+// Prune forgets the sockets of processes alive reports as gone.
+func (o *Owners) Prune(alive func(pid uint32) bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	for key, pid := range o.byKey {
+		if !alive(pid) {
+			delete(o.byKey, key)
+		}
+	}
+}
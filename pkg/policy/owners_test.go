@@ -0,0 +1,41 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/flow"
+	"github.com/lonelysadness/OpenMonitor/pkg/nfq"
+	"github.com/lonelysadness/OpenMonitor/pkg/sockets"
+)
+
+func TestPacketOwner(t *testing.T) {
+	owners := NewOwners(sockets.NewInventory())
+	owners.Set(Key{Protocol: flow.TCP, LocalPort: 51000}, 4242)
+
+	pkt := nfq.Packet{Protocol: uint8(flow.TCP), SrcPort: 51000, DstPort: 443}
+	if pid, ok := owners.PacketOwner(pkt, false); !ok || pid != 4242 {
+		t.Errorf("outbound owner = %d, %v, want 4242", pid, ok)
+	}
+	// Inbound packets are owned by their destination port
+	if pid, ok := owners.PacketOwner(pkt, true); ok {
+		t.Errorf("inbound owner = %d, want none", pid)
+	}
+	reply := nfq.Packet{Protocol: uint8(flow.TCP), SrcPort: 443, DstPort: 51000}
+	if pid, ok := owners.PacketOwner(reply, true); !ok || pid != 4242 {
+		t.Errorf("inbound owner = %d, %v, want 4242", pid, ok)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	owners := NewOwners(sockets.NewInventory())
+	owners.Set(Key{Protocol: flow.TCP, LocalPort: 1}, 1)
+	owners.Set(Key{Protocol: flow.UDP, LocalPort: 2}, 2)
+
+	owners.Prune(func(pid uint32) bool { return pid == 1 })
+	if _, ok := owners.Lookup(Key{Protocol: flow.TCP, LocalPort: 1}); !ok {
+		t.Error("socket of a live process was pruned")
+	}
+	if _, ok := owners.Lookup(Key{Protocol: flow.UDP, LocalPort: 2}); ok {
+		t.Error("socket of an exited process was kept")
+	}
+}
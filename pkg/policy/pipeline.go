@@ -0,0 +1,132 @@
+// Package policy gives verdicts to the packets of the NFQUEUE queues. It runs
+// on its own, so packets are never held up by whoever shows the decisions.
+package policy
+
+import (
+	"context"
+	"log"
+	"net/netip"
+	"time"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/nfq"
+	"github.com/lonelysadness/OpenMonitor/pkg/process"
+	"github.com/lonelysadness/OpenMonitor/pkg/rules"
+)
+
+// How many decisions wait for the monitor before new ones are dropped.
+const decisionBuffer = 1024
+
+// Queues with more verdict errors than this are reopened.
+const maxQueueErrors = 1000
+
+// Decision is the verdict a queued packet got.
+type Decision struct {
+	Packet  nfq.Packet
+	Inbound bool
+	PID     uint32     // owner of the local socket, 0 if unknown
+	Blocked bool       // otherwise accepted
+	Rule    rules.Rule // the blocking rule
+}
+
+// Pipeline checks queued outbound packets against the rules and accepts
+// inbound ones.
+type Pipeline struct {
+	owners    *Owners
+	procs     *process.Table
+	rules     *rules.Engine
+	decisions chan Decision
+}
+
+func New(owners *Owners, procs *process.Table, engine *rules.Engine) *Pipeline {
+	return &Pipeline{
+		owners:    owners,
+		procs:     procs,
+		rules:     engine,
+		decisions: make(chan Decision, decisionBuffer),
+	}
+}
+
+// Decisions returns the verdicts given so far. The packets don't wait for
+// them to be picked up, decisions that don't fit the buffer are dropped.
+func (p *Pipeline) Decisions() <-chan Decision {
+	return p.decisions
+}
+
+// Run gives verdicts to the packets of both queues and reopens queues that
+// fail too often until the context is done.
+func (p *Pipeline) Run(ctx context.Context, inQueue, outQueue *nfq.Queue) {
+	health := time.NewTicker(30 * time.Second)
+	defer health.Stop()
+
+	inPackets, outPackets := inQueue.PacketChannel(), outQueue.PacketChannel()
+	for {
+		select {
+		case pkt := <-inPackets:
+			d := Decision{Packet: pkt, Inbound: true}
+			d.PID, _ = p.owners.PacketOwner(pkt, true)
+			if err := pkt.Accept(); err != nil {
+				log.Printf("Error setting IN verdict: %v", err)
+			}
+			p.publish(d)
+
+		case pkt := <-outPackets:
+			d := p.outboundVerdict(pkt)
+			verdict := pkt.Accept
+			if d.Blocked {
+				verdict = pkt.Block
+			}
+			if err := verdict(); err != nil {
+				log.Printf("Error setting OUT verdict: %v", err)
+			}
+			p.publish(d)
+
+		case <-health.C:
+			for _, q := range []*nfq.Queue{inQueue, outQueue} {
+				stats := q.GetVerdictStats()
+				if stats.Errors > maxQueueErrors {
+					select {
+					case q.Restart <- struct{}{}:
+						log.Printf("High error rate detected (%d), restarting queue %d", stats.Errors, q.ID())
+					default:
+					}
+				}
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// publish hands a decision to the monitor without waiting for it.
+func (p *Pipeline) publish(d Decision) {
+	select {
+	case p.decisions <- d:
+	default:
+	}
+}
+
+// outboundVerdict checks a queued outbound packet against the rules.
+// In-kernel enforcement rejects most blocked connections before they are
+// sent, this catches whatever gets past it.
+func (p *Pipeline) outboundVerdict(pkt nfq.Packet) Decision {
+	d := Decision{Packet: pkt}
+	comm := ""
+	if pid, ok := p.owners.PacketOwner(pkt, false); ok {
+		d.PID = pid
+		if proc, found := p.procs.Lookup(pid); found {
+			comm = proc.Comm
+		}
+	}
+
+	addr, ok := netip.AddrFromSlice(pkt.DstIP)
+	if !ok {
+		return d
+	}
+	rule, ok := p.rules.Match(rules.Protocol(pkt.Protocol), addr, pkt.DstPort, comm)
+	d.Blocked = ok && rule.Action == rules.Block
+	if d.Blocked {
+		d.Rule = rule
+	}
+	return d
+}
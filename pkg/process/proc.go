@@ -0,0 +1,195 @@
+package process
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, the unit of the start time in /proc/<pid>/stat.
+// It is 100 on every architecture Linux supports.
+const clockTicks = 100
+
+// procRoot is where procfs is mounted.
+var procRoot = "/proc"
+
+// bootTime is read once, it is needed to turn start ticks into a time.
+var bootTime = readBootTime()
+
+func readBootTime() time.Time {
+	data, err := os.ReadFile(filepath.Join(procRoot, "stat"))
+	if err != nil {
+		return time.Time{}
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "btime ") {
+			sec, err := strconv.ParseInt(strings.TrimSpace(line[len("btime "):]), 10, 64)
+			if err == nil {
+				return time.Unix(sec, 0)
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// listPIDs returns the PIDs of all processes currently in procfs.
+func listPIDs() ([]uint32, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	pids := make([]uint32, 0, len(entries))
+	for _, entry := range entries {
+		pid, err := strconv.ParseUint(entry.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+		pids = append(pids, uint32(pid))
+	}
+	return pids, nil
+}
+
+// readStat returns the comm, parent PID and start time in clock ticks from
+// /proc/<pid>/stat.
+func readStat(pid uint32) (comm string, ppid uint32, startTicks uint64, err error) {
+	data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(int(pid)), "stat"))
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	// comm is wrapped in parentheses and may itself contain spaces and
+	// parentheses, so split on the last closing one.
+	open := bytes.IndexByte(data, '(')
+	end := bytes.LastIndexByte(data, ')')
+	if open < 0 || end < open {
+		return "", 0, 0, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	comm = string(data[open+1 : end])
+
+	// Fields after comm start with the state (field 3).
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 20 {
+		return "", 0, 0, fmt.Errorf("short stat for pid %d", pid)
+	}
+
+	parent, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid ppid for pid %d: %w", pid, err)
+	}
+	startTicks, err = strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid start time for pid %d: %w", pid, err)
+	}
+
+	return comm, uint32(parent), startTicks, nil
+}
+
+// readIDs returns the real uid and gid from /proc/<pid>/status.
+func readIDs(pid uint32) (uid, gid uint32) {
+	f, err := os.Open(filepath.Join(procRoot, strconv.Itoa(int(pid)), "status"))
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		var target *uint32
+		switch {
+		case strings.HasPrefix(line, "Uid:"):
+			target = &uid
+		case strings.HasPrefix(line, "Gid:"):
+			target = &gid
+		default:
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 32); err == nil {
+			*target = uint32(v)
+		}
+	}
+	return uid, gid
+}
+
+// readCmdline returns the argv of the process.
+func readCmdline(pid uint32) []string {
+	data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(int(pid)), "cmdline"))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimRight(string(data), "\x00"), "\x00")
+}
+
+// readExe returns the path of the executable of the process.
+func readExe(pid uint32) string {
+	exe, err := os.Readlink(filepath.Join(procRoot, strconv.Itoa(int(pid)), "exe"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(exe, " (deleted)")
+}
+
+// readCgroup returns the cgroup v2 path of the process, falling back to the
+// first hierarchy listed on cgroup v1 hosts.
+func readCgroup(pid uint32) string {
+	data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(int(pid)), "cgroup"))
+	if err != nil {
+		return ""
+	}
+
+	var first string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			return parts[2]
+		}
+		if first == "" {
+			first = parts[2]
+		}
+	}
+	return first
+}
+
+// readProcess builds a process from procfs.
+func readProcess(pid uint32) (*Process, error) {
+	comm, ppid, startTicks, err := readStat(pid)
+	if err != nil {
+		return nil, err
+	}
+	uid, gid := readIDs(pid)
+
+	return &Process{
+		PID:        pid,
+		PPID:       ppid,
+		Comm:       comm,
+		Exe:        readExe(pid),
+		Argv:       readCmdline(pid),
+		UID:        uid,
+		GID:        gid,
+		StartTicks: startTicks,
+		StartTime:  ticksToTime(startTicks),
+		Cgroup:     readCgroup(pid),
+	}, nil
+}
+
+func ticksToTime(ticks uint64) time.Time {
+	if bootTime.IsZero() {
+		return time.Time{}
+	}
+	return bootTime.Add(time.Duration(ticks) * time.Second / clockTicks)
+}
@@ -0,0 +1,303 @@
+package process
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/ebpf"
+)
+
+// How long exited processes are kept so late events can still be
+// attributed to them.
+const exitedRetention = 30 * time.Second
+
+// How long a PID that could not be read from procfs is not read again.
+// Packets of exited processes keep coming for a while, each lookup would
+// read procfs otherwise.
+const missRetention = 2 * time.Second
+
+// Process is a snapshot of a running (or recently exited) process. It is
+// never modified after being added to the table.
+type Process struct {
+	PID        uint32
+	PPID       uint32
+	Comm       string
+	Exe        string
+	Argv       []string
+	UID        uint32
+	GID        uint32
+	StartTicks uint64 // start time in clock ticks since boot, identifies the process together with the PID
+	StartTime  time.Time
+	Cgroup     string
+	ExitStatus int32
+	ExitedAt   time.Time // zero while running
+}
+
+// Name returns the most descriptive short name of the process.
+func (p *Process) Name() string {
+	if p.Comm != "" {
+		return p.Comm
+	}
+	if p.Exe != "" {
+		return p.Exe
+	}
+	return "unknown"
+}
+
+// Exited returns whether the process has exited.
+func (p *Process) Exited() bool {
+	return !p.ExitedAt.IsZero()
+}
+
+// Table is the live process table. It is bootstrapped from procfs and kept
+// current from exec tracer events.
+type Table struct {
+	lock  sync.RWMutex
+	procs map[uint32]*Process
+
+	// Exec events waiting for the matching return event, by PID.
+	pending map[uint32]*ebpf.ExecEvent
+
+	// When a PID was last looked up in procfs without finding a new
+	// process.
+	misses map[uint32]time.Time
+
+	now func() time.Time
+}
+
+func NewTable() *Table {
+	return &Table{
+		procs:   make(map[uint32]*Process),
+		pending: make(map[uint32]*ebpf.ExecEvent),
+		misses:  make(map[uint32]time.Time),
+		now:     time.Now,
+	}
+}
+
+// Bootstrap adds all processes currently in procfs to the table.
+func (t *Table) Bootstrap() error {
+	pids, err := listPIDs()
+	if err != nil {
+		return err
+	}
+
+	for _, pid := range pids {
+		proc, err := readProcess(pid)
+		if err != nil {
+			// Process exited while scanning.
+			continue
+		}
+		t.lock.Lock()
+		t.procs[pid] = proc
+		t.lock.Unlock()
+	}
+	return nil
+}
+
+// Run applies exec events to the table and periodically removes exited and
+// reused entries until the context is done or the channel is closed.
+func (t *Table) Run(ctx context.Context, events <-chan *ebpf.ExecEvent) {
+	ticker := time.NewTicker(exitedRetention)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			t.handleEvent(event)
+		case <-ticker.C:
+			t.sweep()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (t *Table) handleEvent(event *ebpf.ExecEvent) {
+	switch event.Type {
+	case ebpf.ExecEventExec:
+		t.lock.Lock()
+		t.pending[event.PID] = event
+		t.lock.Unlock()
+
+	case ebpf.ExecEventExecRet:
+		t.lock.Lock()
+		exec := t.pending[event.PID]
+		delete(t.pending, event.PID)
+		t.lock.Unlock()
+
+		// Failed exec calls leave the process untouched.
+		if event.Retval != 0 {
+			return
+		}
+		t.replace(fromExecEvent(event.ExecEventHeader, exec))
+
+	case ebpf.ExecEventExit:
+		t.lock.Lock()
+		delete(t.pending, event.PID)
+		if proc, ok := t.procs[event.PID]; ok && !proc.Exited() {
+			exited := *proc
+			exited.ExitStatus = event.Retval
+			exited.ExitedAt = t.now()
+			t.procs[event.PID] = &exited
+		}
+		t.lock.Unlock()
+	}
+}
+
+// fromExecEvent builds a process from a successful exec. The exec event may
+// be nil if it was dropped, procfs fills the gaps in either case.
+func fromExecEvent(hdr ebpf.ExecEventHeader, exec *ebpf.ExecEvent) *Process {
+	proc, err := readProcess(hdr.PID)
+	if err != nil {
+		// Already gone, keep what the event told us.
+		proc = &Process{PID: hdr.PID}
+	}
+	proc.PPID = hdr.PPID
+
+	if exec != nil {
+		proc.UID = exec.UID
+		proc.GID = exec.GID
+		if proc.Exe == "" {
+			proc.Exe = exec.Filename
+		}
+		if len(proc.Argv) == 0 {
+			proc.Argv = exec.Argv
+		}
+	}
+	return proc
+}
+
+// replace stores proc, replacing whatever was known under its PID.
+func (t *Table) replace(proc *Process) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.procs[proc.PID] = proc
+	delete(t.misses, proc.PID)
+}
+
+// Lookup returns the process with the given PID. Unknown PIDs, e.g. from
+// forks that never called exec, are resolved from procfs and cached. An
+// exited entry is only returned while its PID has not been reused. PIDs
+// not found in procfs are not looked for again for missRetention.
+func (t *Table) Lookup(pid uint32) (*Process, bool) {
+	if pid == 0 {
+		return nil, false
+	}
+
+	now := t.now()
+	t.lock.RLock()
+	proc, ok := t.procs[pid]
+	missed, recent := t.misses[pid]
+	t.lock.RUnlock()
+	if ok && !proc.Exited() {
+		return proc, true
+	}
+	if recent && now.Sub(missed) < missRetention {
+		return proc, ok
+	}
+
+	// Only a new process under the PID is worth reading in full
+	if ok {
+		if _, _, startTicks, err := readStat(pid); err != nil || startTicks == proc.StartTicks {
+			t.miss(pid, now)
+			return proc, ok
+		}
+	}
+	fresh, err := readProcess(pid)
+	if err != nil {
+		t.miss(pid, now)
+		return proc, ok
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	// An event may have raced us.
+	if current := t.procs[pid]; current != proc {
+		return current, current != nil
+	}
+	t.procs[pid] = fresh
+	delete(t.misses, pid)
+	return fresh, true
+}
+
+// miss remembers that pid was looked up in procfs in vain.
+func (t *Table) miss(pid uint32, now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.misses[pid] = now
+}
+
+// Snapshot returns all processes currently in the table.
+func (t *Table) Snapshot() []*Process {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	procs := make([]*Process, 0, len(t.procs))
+	for _, proc := range t.procs {
+		procs = append(procs, proc)
+	}
+	return procs
+}
+
+// sweep drops processes that exited a while ago and entries whose PID was
+// reused by a new process without the exit being observed.
+func (t *Table) sweep() {
+	now := t.now()
+
+	for _, proc := range t.Snapshot() {
+		if proc.Exited() {
+			if now.Sub(proc.ExitedAt) > exitedRetention {
+				t.remove(proc)
+			}
+			continue
+		}
+
+		_, _, startTicks, err := readStat(proc.PID)
+		switch {
+		case err != nil:
+			// Exit event was lost.
+			exited := *proc
+			exited.ExitedAt = now
+			t.replaceIfSame(proc, &exited)
+		case startTicks != proc.StartTicks:
+			// PID reuse.
+			if fresh, err := readProcess(proc.PID); err == nil {
+				t.replaceIfSame(proc, fresh)
+			}
+		}
+	}
+
+	t.lock.Lock()
+	for pid := range t.pending {
+		if _, _, _, err := readStat(pid); err != nil {
+			delete(t.pending, pid)
+		}
+	}
+	for pid, missed := range t.misses {
+		if now.Sub(missed) >= missRetention {
+			delete(t.misses, pid)
+		}
+	}
+	t.lock.Unlock()
+}
+
+// replaceIfSame swaps old for proc unless an event already replaced it.
+func (t *Table) replaceIfSame(old, proc *Process) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.procs[old.PID] == old {
+		t.procs[old.PID] = proc
+	}
+}
+
+func (t *Table) remove(proc *Process) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.procs[proc.PID] == proc {
+		delete(t.procs, proc.PID)
+	}
+}
@@ -0,0 +1,179 @@
+package process
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeProc points procRoot to an empty directory for the test.
+func fakeProc(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	old := procRoot
+	procRoot = root
+	t.Cleanup(func() { procRoot = old })
+	return root
+}
+
+// writeProc adds a process to the fake procfs, replacing whatever was
+// there under its PID.
+func writeProc(t *testing.T, root string, pid uint32, comm string, startTicks uint64) {
+	t.Helper()
+	dir := filepath.Join(root, strconv.Itoa(int(pid)))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// 17 fields between the parent PID and the start time
+	stat := fmt.Sprintf("%d (%s) S 1 %s %d 0 0\n", pid, comm, strings.Repeat("0 ", 16)+"0", startTicks)
+	files := map[string]string{
+		"stat":    stat,
+		"status":  "Name:\t" + comm + "\nUid:\t1000\t1000\t1000\t1000\nGid:\t100\t100\t100\t100\n",
+		"cmdline": comm + "\x00--flag\x00",
+		"cgroup":  "0::/user.slice\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func removeProc(t *testing.T, root string, pid uint32) {
+	t.Helper()
+	if err := os.RemoveAll(filepath.Join(root, strconv.Itoa(int(pid)))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testTable returns a table whose clock only moves when the returned
+// function is called.
+func testTable() (*Table, func(time.Duration)) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	table := NewTable()
+	table.now = func() time.Time { return now }
+	return table, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestLookupReadsProcfs(t *testing.T) {
+	root := fakeProc(t)
+	writeProc(t, root, 42, "curl", 1000)
+	table, _ := testTable()
+
+	proc, ok := table.Lookup(42)
+	if !ok {
+		t.Fatal("process not found")
+	}
+	if proc.Comm != "curl" || proc.PPID != 1 || proc.StartTicks != 1000 || proc.UID != 1000 ||
+		proc.Cgroup != "/user.slice" || len(proc.Argv) != 2 {
+		t.Errorf("unexpected process %+v", proc)
+	}
+
+	// Cached, procfs is not read again
+	removeProc(t, root, 42)
+	if cached, ok := table.Lookup(42); !ok || cached != proc {
+		t.Errorf("Lookup = %v, %v, want the cached process", cached, ok)
+	}
+}
+
+func TestLookupCachesMisses(t *testing.T) {
+	root := fakeProc(t)
+	table, advance := testTable()
+
+	if _, ok := table.Lookup(42); ok {
+		t.Fatal("found a process that doesn't exist")
+	}
+
+	// A process showing up right after a miss is not looked for yet
+	writeProc(t, root, 42, "curl", 1000)
+	if _, ok := table.Lookup(42); ok {
+		t.Error("procfs read again right after a miss")
+	}
+
+	advance(missRetention)
+	if proc, ok := table.Lookup(42); !ok || proc.Comm != "curl" {
+		t.Errorf("Lookup after the miss expired = %v, %v", proc, ok)
+	}
+}
+
+func TestLookupPIDReuse(t *testing.T) {
+	root := fakeProc(t)
+	writeProc(t, root, 42, "curl", 1000)
+	table, advance := testTable()
+	if err := table.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	old, _ := table.Lookup(42)
+
+	// The exit is seen, the PID is still in procfs with the same start
+	// time (a zombie) so the exited entry stays
+	exited := *old
+	exited.ExitedAt = table.now()
+	table.replace(&exited)
+	if proc, ok := table.Lookup(42); !ok || proc != &exited {
+		t.Errorf("Lookup = %v, %v, want the exited entry", proc, ok)
+	}
+
+	// A new process under the same PID has a different start time
+	writeProc(t, root, 42, "wget", 2000)
+	advance(missRetention)
+	proc, ok := table.Lookup(42)
+	if !ok || proc.Comm != "wget" || proc.StartTicks != 2000 || proc.Exited() {
+		t.Errorf("Lookup after PID reuse = %+v, %v", proc, ok)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	root := fakeProc(t)
+	writeProc(t, root, 10, "lost", 100)
+	writeProc(t, root, 20, "reused", 200)
+	writeProc(t, root, 30, "running", 300)
+	table, advance := testTable()
+	if err := table.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 10 exits without an event, 20 exits and its PID is reused
+	removeProc(t, root, 10)
+	writeProc(t, root, 20, "new", 250)
+	table.sweep()
+
+	byPID := func() map[uint32]*Process {
+		procs := make(map[uint32]*Process)
+		for _, proc := range table.Snapshot() {
+			procs[proc.PID] = proc
+		}
+		return procs
+	}
+	procs := byPID()
+	if proc := procs[10]; proc == nil || !proc.Exited() {
+		t.Errorf("lost exit not marked: %+v", proc)
+	}
+	if proc := procs[20]; proc == nil || proc.Comm != "new" || proc.StartTicks != 250 {
+		t.Errorf("reused PID not replaced: %+v", proc)
+	}
+	if proc := procs[30]; proc == nil || proc.Exited() {
+		t.Errorf("running process changed: %+v", proc)
+	}
+
+	// Exited entries are kept for a while, then dropped
+	advance(exitedRetention)
+	table.sweep()
+	if _, ok := byPID()[10]; !ok {
+		t.Error("exited process dropped too early")
+	}
+	advance(time.Second)
+	table.sweep()
+	procs = byPID()
+	if _, ok := procs[10]; ok {
+		t.Error("exited process not dropped after the retention")
+	}
+	if len(procs) != 2 {
+		t.Errorf("%d processes left, want 2", len(procs))
+	}
+}
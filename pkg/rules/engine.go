@@ -0,0 +1,196 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Engine holds the rule set. Rules are evaluated in userspace for queued
+// packets and handed to the kernel for in-kernel enforcement, both with the
+// same semantics:
+//
+//   - a process rule matching the comm of the caller wins,
+//   - otherwise destination rules are tried from the most to the least
+//     specific protocol and port, the longest matching network wins.
+type Engine struct {
+	lock     sync.RWMutex
+	rules    []Rule
+	nextID   uint32
+	watchers []func([]Rule)
+}
+
+func NewEngine() *Engine {
+	return &Engine{nextID: 1}
+}
+
+// LoadFile adds the rules of a JSON file containing a list of rules.
+func (e *Engine) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read rules: %w", err)
+	}
+
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("failed to parse rules: %w", err)
+	}
+	for i, rule := range rules {
+		if _, err := e.Add(rule); err != nil {
+			return fmt.Errorf("rule %d of %s: %w", i+1, path, err)
+		}
+	}
+	return nil
+}
+
+// SaveFile writes the rules to a JSON file LoadFile reads. The file is
+// replaced in one step, a crash leaves the old rules in place.
+func (e *Engine) SaveFile(path string) error {
+	rules := e.Rules()
+	for i := range rules {
+		// IDs are handed out on load
+		rules[i].ID = 0
+	}
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode rules: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save rules: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save rules: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save rules: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save rules: %w", err)
+	}
+	return nil
+}
+
+// OnChange registers a function that is called with the complete rule set
+// whenever it changes. The function is called once right away.
+func (e *Engine) OnChange(fn func([]Rule)) {
+	e.lock.Lock()
+	e.watchers = append(e.watchers, fn)
+	rules := e.snapshot()
+	e.lock.Unlock()
+
+	fn(rules)
+}
+
+// Add adds the rule and returns its ID.
+func (e *Engine) Add(rule Rule) (uint32, error) {
+	if err := rule.Validate(); err != nil {
+		return 0, err
+	}
+	rule.Network = rule.Network.Masked()
+
+	e.lock.Lock()
+	for _, existing := range e.rules {
+		if existing.Process == rule.Process && existing.Network == rule.Network &&
+			existing.Port == rule.Port && existing.Protocol == rule.Protocol {
+			e.lock.Unlock()
+			return 0, fmt.Errorf("rule %d already covers %s", existing.ID, rule)
+		}
+	}
+	rule.ID = e.nextID
+	e.nextID++
+	e.rules = append(e.rules, rule)
+	rules, watchers := e.snapshot(), e.watchers
+	e.lock.Unlock()
+
+	notify(watchers, rules)
+	return rule.ID, nil
+}
+
+// Remove removes the rule with the given ID and returns whether it existed.
+func (e *Engine) Remove(id uint32) bool {
+	e.lock.Lock()
+	found := false
+	for i, rule := range e.rules {
+		if rule.ID == id {
+			e.rules = append(e.rules[:i], e.rules[i+1:]...)
+			found = true
+			break
+		}
+	}
+	rules, watchers := e.snapshot(), e.watchers
+	e.lock.Unlock()
+
+	if found {
+		notify(watchers, rules)
+	}
+	return found
+}
+
+// Rules returns the current rule set.
+func (e *Engine) Rules() []Rule {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.snapshot()
+}
+
+func (e *Engine) snapshot() []Rule {
+	return append([]Rule(nil), e.rules...)
+}
+
+func notify(watchers []func([]Rule), rules []Rule) {
+	for _, fn := range watchers {
+		fn(rules)
+	}
+}
+
+// Match returns the rule deciding about a connection of the process with
+// the given comm.
+func (e *Engine) Match(protocol Protocol, addr netip.Addr, port uint16, comm string) (Rule, bool) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	if comm != "" {
+		for _, rule := range e.rules {
+			if rule.Process == comm {
+				return rule, true
+			}
+		}
+	}
+
+	addr = addr.Unmap()
+	specificity := []struct {
+		protocol Protocol
+		port     uint16
+	}{
+		{protocol, port},
+		{protocol, 0},
+		{AnyProtocol, port},
+		{AnyProtocol, 0},
+	}
+	for _, s := range specificity {
+		var best Rule
+		found := false
+		for _, rule := range e.rules {
+			if rule.Process != "" || rule.Protocol != s.protocol || rule.Port != s.port {
+				continue
+			}
+			if !rule.Network.Contains(addr) {
+				continue
+			}
+			if !found || rule.Network.Bits() > best.Network.Bits() {
+				best, found = rule, true
+			}
+		}
+		if found {
+			return best, true
+		}
+	}
+	return Rule{}, false
+}
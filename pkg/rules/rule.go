@@ -0,0 +1,138 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Action is what happens to a matching connection. The values match the
+// ACTION_* defines in enforce.c.
+type Action uint8
+
+const (
+	Block Action = 1
+	Allow Action = 2
+)
+
+func (a Action) String() string {
+	switch a {
+	case Block:
+		return "block"
+	case Allow:
+		return "allow"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(a))
+	}
+}
+
+func (a Action) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Action) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "block":
+		*a = Block
+	case "allow":
+		*a = Allow
+	default:
+		return fmt.Errorf("unknown action %q", text)
+	}
+	return nil
+}
+
+// Protocol is an IP protocol number, 0 matches any protocol.
+type Protocol uint8
+
+const (
+	AnyProtocol Protocol = 0
+	TCP         Protocol = 6
+	UDP         Protocol = 17
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case AnyProtocol:
+		return "any"
+	case TCP:
+		return "tcp"
+	case UDP:
+		return "udp"
+	default:
+		return strconv.Itoa(int(p))
+	}
+}
+
+func (p Protocol) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Protocol) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "", "any":
+		*p = AnyProtocol
+	case "tcp":
+		*p = TCP
+	case "udp":
+		*p = UDP
+	default:
+		return fmt.Errorf("unknown protocol %q", text)
+	}
+	return nil
+}
+
+// Longest process name the kernel keeps as comm.
+const maxCommLen = 15
+
+// Rule matches outbound connections either by destination or by the name of
+// the connecting process.
+type Rule struct {
+	ID       uint32       `json:"id,omitempty"`
+	Action   Action       `json:"action"`
+	Protocol Protocol     `json:"protocol,omitempty"`
+	Network  netip.Prefix `json:"network,omitempty"` // destination, a single address is a /32 or /128
+	Port     uint16       `json:"port,omitempty"`    // destination port, 0 matches any port
+	Process  string       `json:"process,omitempty"` // comm of the connecting process
+}
+
+// Validate checks that the rule can be enforced both in the kernel and on
+// queued packets.
+func (r *Rule) Validate() error {
+	if r.Action != Block && r.Action != Allow {
+		return fmt.Errorf("invalid action %d", r.Action)
+	}
+	if r.Protocol != AnyProtocol && r.Protocol != TCP && r.Protocol != UDP {
+		return fmt.Errorf("unsupported protocol %s", r.Protocol)
+	}
+
+	if r.Process != "" {
+		if r.Network.IsValid() || r.Port != 0 || r.Protocol != AnyProtocol {
+			return errors.New("process rules apply to all destinations")
+		}
+		if len(r.Process) > maxCommLen {
+			return fmt.Errorf("process name %q is longer than %d characters and would never match", r.Process, maxCommLen)
+		}
+		return nil
+	}
+
+	if !r.Network.IsValid() {
+		return errors.New("rule needs a network or a process")
+	}
+	return nil
+}
+
+// String returns a short description of the rule.
+func (r Rule) String() string {
+	if r.Process != "" {
+		return fmt.Sprintf("#%d %s process %s", r.ID, r.Action, r.Process)
+	}
+
+	dest := r.Network.String()
+	if r.Port != 0 {
+		dest += ":" + strconv.Itoa(int(r.Port))
+	}
+	return fmt.Sprintf("#%d %s %s %s", r.ID, r.Action, r.Protocol, dest)
+}
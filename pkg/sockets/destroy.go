@@ -0,0 +1,105 @@
+//go:build linux
+
+package sockets
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/flow"
+)
+
+// sock_diag message type to close a socket, needs CONFIG_INET_DIAG_DESTROY.
+const sockDestroy = 21
+
+// Size of struct inet_diag_req_v2.
+const sizeofInetDiagReqV2 = 56
+
+// ErrSocketNotFound is returned by Destroy if no local socket has the flow.
+var ErrSocketNotFound = errors.New("socket not found")
+
+// Destroy closes the local socket of a TCP, UDP or UDP-Lite flow. The owning process
+// sees ECONNABORTED on its next call. IPv4 flows are looked up on IPv6
+// sockets, too, as dual stack sockets see them IPv4-mapped.
+func Destroy(f flow.Flow) error {
+	if !f.Protocol.HasPorts() {
+		return fmt.Errorf("%s sockets can't be destroyed", f.Protocol)
+	}
+
+	if f.Local.Addr().Is4() {
+		err := destroy(unix.AF_INET, f.Protocol, f.Local, f.Remote)
+		if !errors.Is(err, ErrSocketNotFound) {
+			return err
+		}
+		mapped := func(ap netip.AddrPort) netip.AddrPort {
+			return netip.AddrPortFrom(netip.AddrFrom16(ap.Addr().As16()), ap.Port())
+		}
+		return destroy(unix.AF_INET6, f.Protocol, mapped(f.Local), mapped(f.Remote))
+	}
+	return destroy(unix.AF_INET6, f.Protocol, f.Local, f.Remote)
+}
+
+func destroy(family uint8, protocol flow.Protocol, local, remote netip.AddrPort) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.NETLINK_SOCK_DIAG)
+	if err != nil {
+		return fmt.Errorf("failed to open sock_diag socket: %w", err)
+	}
+	defer unix.Close(fd)
+
+	req := make([]byte, unix.SizeofNlMsghdr+sizeofInetDiagReqV2)
+	binary.NativeEndian.PutUint32(req[0:], uint32(len(req)))
+	binary.NativeEndian.PutUint16(req[4:], sockDestroy)
+	binary.NativeEndian.PutUint16(req[6:], unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	binary.NativeEndian.PutUint32(req[8:], 1)
+
+	// struct inet_diag_req_v2, all sockets states, no cookie
+	r := req[unix.SizeofNlMsghdr:]
+	r[0] = family
+	r[1] = uint8(protocol)
+	binary.NativeEndian.PutUint32(r[4:], ^uint32(0))
+
+	// struct inet_diag_sockid, ports and addresses in network byte order
+	id := r[8:]
+	binary.BigEndian.PutUint16(id[0:], local.Port())
+	binary.BigEndian.PutUint16(id[2:], remote.Port())
+	putDiagAddr(id[4:20], local.Addr())
+	putDiagAddr(id[20:36], remote.Addr())
+	binary.NativeEndian.PutUint32(id[40:], ^uint32(0))
+	binary.NativeEndian.PutUint32(id[44:], ^uint32(0))
+
+	if err := unix.Sendto(fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return fmt.Errorf("failed to send sock_diag request: %w", err)
+	}
+
+	resp := make([]byte, 4096)
+	n, _, err := unix.Recvfrom(fd, resp, 0)
+	if err != nil {
+		return fmt.Errorf("failed to read sock_diag reply: %w", err)
+	}
+	// The kernel acks with a single NLMSG_ERROR carrying the negated errno
+	if n < unix.SizeofNlMsghdr+4 || binary.NativeEndian.Uint16(resp[4:]) != unix.NLMSG_ERROR {
+		return errors.New("unexpected sock_diag reply")
+	}
+	switch errno := unix.Errno(-int32(binary.NativeEndian.Uint32(resp[unix.SizeofNlMsghdr:]))); errno {
+	case 0:
+		return nil
+	case unix.ENOENT:
+		return ErrSocketNotFound
+	default:
+		return fmt.Errorf("failed to destroy socket: %w", errno)
+	}
+}
+
+func putDiagAddr(dst []byte, addr netip.Addr) {
+	if addr.Is4() {
+		ip := addr.As4()
+		copy(dst, ip[:])
+		return
+	}
+	ip := addr.As16()
+	copy(dst, ip[:])
+}
@@ -0,0 +1,190 @@
+package sockets
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lonelysadness/OpenMonitor/pkg/ebpf"
+	"github.com/lonelysadness/OpenMonitor/pkg/netutils"
+)
+
+// Protocol numbers as used in listen events.
+const (
+	protocolTCP = 6
+	protocolUDP = 17
+)
+
+// How often /proc/net is scanned again to pick up sockets whose events were
+// lost.
+const rescanInterval = time.Minute
+
+// Listener is a listening TCP socket or a bound UDP socket.
+type Listener struct {
+	Protocol uint8
+	Addr     net.IP
+	Port     uint16
+	PID      uint32 // 0 if the owner is unknown
+	Comm     string // from the listen event, empty for sockets found by scanning
+	Since    time.Time
+}
+
+// Exposed returns whether the socket can be reached from other hosts, i.e.
+// it is not bound to a loopback address.
+func (l *Listener) Exposed() bool {
+	return !netutils.GetIPScope(l.Addr).IsLocalhost()
+}
+
+// ProtocolName returns "TCP" or "UDP".
+func (l *Listener) ProtocolName() string {
+	if l.Protocol == protocolUDP {
+		return "UDP"
+	}
+	return "TCP"
+}
+
+// key identifies a listener.
+func (l *Listener) key() string {
+	return fmt.Sprintf("%d/%s/%d", l.Protocol, l.Addr, l.Port)
+}
+
+// Inventory is the set of listening sockets. It is bootstrapped from
+// /proc/net and kept current from listen events.
+type Inventory struct {
+	lock      sync.RWMutex
+	listeners map[string]*Listener
+}
+
+func NewInventory() *Inventory {
+	return &Inventory{
+		listeners: make(map[string]*Listener),
+	}
+}
+
+// Bootstrap adds all sockets currently listening to the inventory.
+func (inv *Inventory) Bootstrap() error {
+	return inv.rescan()
+}
+
+// rescan replaces the inventory with the sockets in /proc/net. Owners known
+// from events are kept if the scan could not resolve them.
+func (inv *Inventory) rescan() error {
+	found, err := scanProcNet()
+	if err != nil {
+		return fmt.Errorf("failed to scan sockets: %w", err)
+	}
+
+	now := time.Now()
+	inv.lock.Lock()
+	defer inv.lock.Unlock()
+
+	listeners := make(map[string]*Listener, len(found))
+	for _, l := range found {
+		key := l.key()
+		if known, ok := inv.listeners[key]; ok {
+			l.Since = known.Since
+			if l.PID == 0 || l.PID == known.PID {
+				l.PID = known.PID
+				l.Comm = known.Comm
+			}
+		} else {
+			l.Since = now
+		}
+		listeners[key] = l
+	}
+	inv.listeners = listeners
+	return nil
+}
+
+// Run applies listen events to the inventory and periodically rescans
+// /proc/net until the context is done or the channel is closed.
+func (inv *Inventory) Run(ctx context.Context, events <-chan *ebpf.ListenEvent) {
+	ticker := time.NewTicker(rescanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			inv.handleEvent(event)
+		case <-ticker.C:
+			// Keep the previous state on errors, the next scan may work.
+			_ = inv.rescan()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (inv *Inventory) handleEvent(event *ebpf.ListenEvent) {
+	l := &Listener{
+		Protocol: event.Protocol,
+		Addr:     event.Addr,
+		Port:     event.Port,
+		PID:      event.PID,
+		Comm:     event.Comm,
+		Since:    time.Now(),
+	}
+
+	inv.lock.Lock()
+	defer inv.lock.Unlock()
+
+	switch event.Type {
+	case ebpf.ListenStart:
+		inv.listeners[l.key()] = l
+	case ebpf.ListenStop:
+		delete(inv.listeners, l.key())
+	}
+}
+
+// Snapshot returns all listeners, exposed ones first, then by protocol and
+// port.
+func (inv *Inventory) Snapshot() []*Listener {
+	inv.lock.RLock()
+	listeners := make([]*Listener, 0, len(inv.listeners))
+	for _, l := range inv.listeners {
+		listeners = append(listeners, l)
+	}
+	inv.lock.RUnlock()
+
+	sort.Slice(listeners, func(i, j int) bool {
+		a, b := listeners[i], listeners[j]
+		if a.Exposed() != b.Exposed() {
+			return a.Exposed()
+		}
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.Addr.String() < b.Addr.String()
+	})
+	return listeners
+}
+
+// Owner returns the PID of the process listening on the port, on any
+// address.
+func (inv *Inventory) Owner(protocol uint8, port uint16) (uint32, bool) {
+	inv.lock.RLock()
+	defer inv.lock.RUnlock()
+
+	for _, l := range inv.listeners {
+		if l.Protocol == protocol && l.Port == port && l.PID != 0 {
+			return l.PID, true
+		}
+	}
+	return 0, false
+}
+
+// Count returns the number of listeners.
+func (inv *Inventory) Count() int {
+	inv.lock.RLock()
+	defer inv.lock.RUnlock()
+	return len(inv.listeners)
+}
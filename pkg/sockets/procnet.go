@@ -0,0 +1,169 @@
+package sockets
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// procRoot is where procfs is mounted.
+var procRoot = "/proc"
+
+// Socket states in /proc/net/*, matching TCP_* in the kernel
+const (
+	stateListen = 0x0a // listening TCP socket
+	stateClose  = 0x07 // unconnected UDP socket
+)
+
+// procNetFiles are the socket tables scanned at startup.
+var procNetFiles = []struct {
+	name     string
+	protocol uint8
+}{
+	{"tcp", protocolTCP},
+	{"tcp6", protocolTCP},
+	{"udp", protocolUDP},
+	{"udp6", protocolUDP},
+}
+
+// scanProcNet returns all listening TCP and bound UDP sockets in /proc/net
+// with the owning process resolved from the socket inodes.
+func scanProcNet() ([]*Listener, error) {
+	var listeners []*Listener
+	byInode := make(map[uint64]*Listener)
+
+	for _, file := range procNetFiles {
+		found, err := readProcNet(filepath.Join(procRoot, "net", file.name), file.protocol)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// IPv6 is disabled.
+				continue
+			}
+			return nil, err
+		}
+		for inode, l := range found {
+			byInode[inode] = l
+			listeners = append(listeners, l)
+		}
+	}
+
+	resolveOwners(byInode)
+	return listeners, nil
+}
+
+// readProcNet returns the listening sockets of a /proc/net table by inode.
+func readProcNet(path string, protocol uint8) (map[uint64]*Listener, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	listeners := make(map[uint64]*Listener)
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+
+		state, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil {
+			continue
+		}
+		_, remotePort, err := parseHexAddr(fields[2])
+		if err != nil {
+			continue
+		}
+		switch {
+		case protocol == protocolTCP && state == stateListen:
+		case protocol == protocolUDP && state == stateClose && remotePort == 0:
+		default:
+			continue
+		}
+
+		addr, port, err := parseHexAddr(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			continue
+		}
+		listeners[inode] = &Listener{
+			Protocol: protocol,
+			Addr:     addr,
+			Port:     port,
+		}
+	}
+	return listeners, scanner.Err()
+}
+
+// parseHexAddr parses an address like "0100007F:0035". The address is
+// printed as 32 bit words in host byte order, the port in host byte order.
+func parseHexAddr(s string) (net.IP, uint16, error) {
+	hexIP, hexPort, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, 0, fmt.Errorf("malformed address %q", s)
+	}
+
+	raw, err := hex.DecodeString(hexIP)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("malformed address %q", s)
+	}
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		binary.NativeEndian.PutUint32(ip[i:], binary.BigEndian.Uint32(raw[i:]))
+	}
+
+	port, err := strconv.ParseUint(hexPort, 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("malformed port %q", s)
+	}
+	return ip, uint16(port), nil
+}
+
+// resolveOwners sets the PID of the listeners by finding their socket inode
+// among the open files of all processes.
+func resolveOwners(byInode map[uint64]*Listener) {
+	procs, err := os.ReadDir(procRoot)
+	if err != nil {
+		return
+	}
+
+	for _, proc := range procs {
+		pid, err := strconv.ParseUint(proc.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+
+		fdDir := filepath.Join(procRoot, proc.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			// Process exited or is a kernel thread.
+			continue
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(target, "socket:[") {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(target[len("socket:["):], "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+			// Sockets shared with children keep the first owner found.
+			if l, ok := byInode[inode]; ok && l.PID == 0 {
+				l.PID = uint32(pid)
+			}
+		}
+	}
+}